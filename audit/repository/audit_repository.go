@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+// AuditTables are the copies of every audit entry, looked up by day, by
+// actor and day, and by the proposal or comment changed.
+var AuditTables = []string{"audit_log_by_day", "audit_log_by_actor", "audit_log_by_target"}
+
+// MaxEntries caps the entries a single query returns.
+const MaxEntries = 1000
+
+// Log records that actor applied action to a proposal or, if commentID is
+// set, to a comment. before and after are stored as JSON snapshots, nil
+// leaves them empty.
+func Log(session *gocql.Session, actor entity.Actor, action, targetType string, proposalID, commentID uuid.UUID, before, after interface{}) error {
+	id := gocql.TimeUUID()
+
+	entry := entity.AuditEntry{
+		ID:         uuid.UUID(id),
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		ProposalID: proposalID,
+		CommentID:  commentID,
+		CreatedAt:  id.Time(),
+	}
+
+	var err error
+	if entry.Before, err = snapshot(before); err != nil {
+		return err
+	}
+	if entry.After, err = snapshot(after); err != nil {
+		return err
+	}
+
+	return Record(session, entry)
+}
+
+func snapshot(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Record writes entry to every one of AuditTables.
+func Record(session *gocql.Session, entry entity.AuditEntry) error {
+	day := entry.CreatedAt.UTC().Truncate(24 * time.Hour)
+
+	for _, table := range AuditTables {
+		err := session.Query(`INSERT INTO `+table+`(day, id, actor_id, actor_username, client_ip, action, target_type,
+			target_id, proposal_id, comment_id, before, after) VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, day, gocql.UUID(entry.ID), gocql.UUID(entry.Actor.UserID), entry.Actor.Username,
+			entry.Actor.IP, entry.Action, entry.TargetType, gocql.UUID(targetID(entry)), gocql.UUID(entry.ProposalID),
+			gocql.UUID(entry.CommentID), string(entry.Before), string(entry.After)).Exec()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// targetID is the comment of an entry about a comment, and the proposal
+// otherwise.
+func targetID(entry entity.AuditEntry) uuid.UUID {
+	if entry.CommentID != uuid.Nil {
+		return entry.CommentID
+	}
+	return entry.ProposalID
+}
+
+// Filter selects audit entries. From and To bound the time of the change and
+// are required, except that From may be left zero with a TargetID. ActorID and
+// TargetID narrow it further when set.
+type Filter struct {
+	From     time.Time
+	To       time.Time
+	ActorID  uuid.UUID
+	TargetID uuid.UUID
+	// Limit caps the entries returned, 0 or more than MaxEntries means
+	// MaxEntries.
+	Limit int
+}
+
+// GetEntries returns the audit entries matching filter, newest first.
+// Entries of a target come from a single partition, entries of an actor or
+// of all users are read day by day from To back to From.
+func GetEntries(session *gocql.Session, filter Filter) ([]entity.AuditEntry, error) {
+	if filter.Limit <= 0 || filter.Limit > MaxEntries {
+		filter.Limit = MaxEntries
+	}
+
+	var entries []entity.AuditEntry
+	keep := func(entry entity.AuditEntry) bool {
+		if filter.ActorID != uuid.Nil && entry.Actor.UserID != filter.ActorID {
+			return true
+		}
+		entries = append(entries, entry)
+		return len(entries) < filter.Limit
+	}
+
+	to := gocql.MaxTimeUUID(filter.To)
+
+	if filter.TargetID != uuid.Nil {
+		if filter.From.IsZero() {
+			err := scan(session.Query(`SELECT * FROM audit_log_by_target
+							WHERE target_id=? AND id<=?;`, gocql.UUID(filter.TargetID), to), keep)
+			return entries, err
+		}
+
+		err := scan(session.Query(`SELECT * FROM audit_log_by_target
+							WHERE target_id=? AND id>=? AND id<=?;`, gocql.UUID(filter.TargetID), gocql.MinTimeUUID(filter.From), to), keep)
+		return entries, err
+	}
+
+	from := gocql.MinTimeUUID(filter.From)
+	first := filter.From.UTC().Truncate(24 * time.Hour)
+	for day := filter.To.UTC().Truncate(24 * time.Hour); !day.Before(first); day = day.Add(-24 * time.Hour) {
+		var query *gocql.Query
+		if filter.ActorID != uuid.Nil {
+			query = session.Query(`SELECT * FROM audit_log_by_actor
+							WHERE actor_id=? AND day=? AND id>=? AND id<=?;`, gocql.UUID(filter.ActorID), day, from, to)
+		} else {
+			query = session.Query(`SELECT * FROM audit_log_by_day
+							WHERE day=? AND id>=? AND id<=?;`, day, from, to)
+		}
+
+		if err := scan(query, keep); err != nil {
+			return entries, err
+		}
+		if len(entries) >= filter.Limit {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// scan passes the rows of query to keep until it returns false.
+func scan(query *gocql.Query, keep func(entity.AuditEntry) bool) error {
+	var m = map[string]interface{}{}
+
+	iter := query.Iter()
+
+	for iter.MapScan(m) {
+		if !keep(entryFromMap(m)) {
+			break
+		}
+		m = map[string]interface{}{}
+	}
+
+	return iter.Close()
+}
+
+func entryFromMap(m map[string]interface{}) entity.AuditEntry {
+	id := m["id"].(gocql.UUID)
+
+	entry := entity.AuditEntry{
+		ID: uuid.UUID(id),
+		Actor: entity.Actor{
+			UserID:   uuid.UUID(m["actor_id"].(gocql.UUID)),
+			Username: m["actor_username"].(string),
+			IP:       m["client_ip"].(string),
+		},
+		Action:     m["action"].(string),
+		TargetType: m["target_type"].(string),
+		ProposalID: uuid.UUID(m["proposal_id"].(gocql.UUID)),
+		CommentID:  uuid.UUID(m["comment_id"].(gocql.UUID)),
+		CreatedAt:  id.Time(),
+	}
+
+	if before := m["before"].(string); before != "" {
+		entry.Before = json.RawMessage(before)
+	}
+	if after := m["after"].(string); after != "" {
+		entry.After = json.RawMessage(after)
+	}
+
+	return entry
+}
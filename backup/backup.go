@@ -0,0 +1,364 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// ManifestFile is the first entry of an archive, followed by one
+// tables/<name>.ndjson entry per table holding the rows as SELECT JSON
+// returns them.
+const ManifestFile = "manifest.json"
+
+// Version of the archive layout, restores refuse archives of a newer one.
+const Version = 1
+
+// TablePrefixes select the tables of the keyspace that are backed up.
+var TablePrefixes = []string{"proposals_by_", "comments_by_"}
+
+// Concurrency is how many rows a restore writes at the same time.
+var Concurrency = 16
+
+var (
+	ErrNoManifest  = errors.New("the archive does not start with " + ManifestFile)
+	ErrNewerFormat = errors.New("the archive was written by a newer version of this tool")
+)
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	Version   int       `json:"version"`
+	Keyspace  string    `json:"keyspace"`
+	CreatedAt time.Time `json:"created_at"`
+	Tables    []Table   `json:"tables"`
+}
+
+// Table is one table of an archive.
+type Table struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Tables lists the tables of keyspace matching TablePrefixes.
+func Tables(session *gocql.Session, keyspace string) ([]string, error) {
+	var tables []string
+	var name string
+
+	iter := session.Query(`SELECT table_name FROM system_schema.tables WHERE keyspace_name=?;`, keyspace).Iter()
+
+	for iter.Scan(&name) {
+		for _, prefix := range TablePrefixes {
+			if strings.HasPrefix(name, prefix) {
+				tables = append(tables, name)
+				break
+			}
+		}
+	}
+
+	return tables, iter.Close()
+}
+
+// Write backs up the tables of keyspace into a gzipped tar archive written
+// to w. Each table is read on its own while writes continue, so the archive
+// is not a point-in-time snapshot across tables. Rows are staged in
+// temporary files until the manifest with their checksums is written.
+func Write(session *gocql.Session, keyspace string, w io.Writer) (Manifest, error) {
+	manifest := Manifest{Version: Version, Keyspace: keyspace, CreatedAt: time.Now().UTC()}
+
+	tables, err := Tables(session, keyspace)
+	if err != nil {
+		return manifest, err
+	}
+
+	dir, err := os.MkdirTemp("", "backup-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, table := range tables {
+		entry, err := dumpTable(session, table, dir)
+		if err != nil {
+			return manifest, fmt.Errorf("%s: %w", table, err)
+		}
+		manifest.Tables = append(manifest.Tables, entry)
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := addEntry(archive, ManifestFile, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return manifest, err
+	}
+
+	for _, table := range manifest.Tables {
+		if err := addFile(archive, table.File, path.Join(dir, table.Name)); err != nil {
+			return manifest, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+func dumpTable(session *gocql.Session, table, dir string) (Table, error) {
+	entry := Table{Name: table, File: "tables/" + table + ".ndjson"}
+
+	file, err := os.Create(path.Join(dir, table))
+	if err != nil {
+		return entry, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(file, hash))
+
+	var row string
+	iter := session.Query(`SELECT JSON * FROM ` + table + `;`).Iter()
+
+	for iter.Scan(&row) {
+		if _, err := out.WriteString(row + "\n"); err != nil {
+			iter.Close()
+			return entry, err
+		}
+		entry.Rows++
+	}
+
+	if err := iter.Close(); err != nil {
+		return entry, err
+	}
+	if err := out.Flush(); err != nil {
+		return entry, err
+	}
+
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return entry, nil
+}
+
+func addFile(archive *tar.Writer, name, source string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return addEntry(archive, name, info.Size(), file)
+}
+
+func addEntry(archive *tar.Writer, name string, size int64, r io.Reader) error {
+	err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(archive, r)
+	return err
+}
+
+// Verify reads the archive and checks the row count and checksum of every
+// table against the manifest, without writing anything.
+func Verify(r io.Reader) (Manifest, error) {
+	return read(r, nil)
+}
+
+// Restore replays the archive into the keyspace of session with INSERT JSON.
+// The tables must exist, rows already in them are overwritten by the rows of
+// the archive with the same primary key and kept otherwise. A table is only
+// written after its checksum was verified.
+func Restore(session *gocql.Session, r io.Reader) (Manifest, error) {
+	return read(r, func(table Table, rows *os.File) error {
+		return replay(session, table.Name, rows)
+	})
+}
+
+// tableName guards the table names of an archive, they end up in queries.
+var tableName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func read(r io.Reader, restore func(Table, *os.File) error) (Manifest, error) {
+	var manifest Manifest
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, err
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+
+	header, err := archive.Next()
+	if err != nil || header.Name != ManifestFile {
+		return manifest, ErrNoManifest
+	}
+	if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("reading the manifest: %w", err)
+	}
+	if manifest.Version > Version {
+		return manifest, ErrNewerFormat
+	}
+
+	tables := make(map[string]Table, len(manifest.Tables))
+	for _, table := range manifest.Tables {
+		if !tableName.MatchString(table.Name) {
+			return manifest, fmt.Errorf("the manifest names an invalid table %q", table.Name)
+		}
+		tables[table.File] = table
+	}
+
+	dir, err := os.MkdirTemp("", "restore-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(dir)
+
+	seen := make(map[string]bool, len(tables))
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		table, ok := tables[header.Name]
+		if !ok {
+			return manifest, fmt.Errorf("the archive holds %s which the manifest does not list", header.Name)
+		}
+		seen[header.Name] = true
+
+		if err := readTable(archive, table, dir, restore); err != nil {
+			return manifest, fmt.Errorf("%s: %w", table.Name, err)
+		}
+	}
+
+	for file, table := range tables {
+		if !seen[file] {
+			return manifest, fmt.Errorf("%s: missing from the archive", table.Name)
+		}
+	}
+
+	return manifest, nil
+}
+
+// readTable stages the rows of a table in a temporary file while checking
+// them, and hands the file to restore once they match the manifest.
+func readTable(archive io.Reader, table Table, dir string, restore func(Table, *os.File) error) error {
+	file, err := os.Create(path.Join(dir, table.Name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	counter := &lineCounter{}
+	if _, err := io.Copy(io.MultiWriter(file, hash, counter), archive); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != table.SHA256 {
+		return errors.New("checksum mismatch, the archive is damaged")
+	}
+	if counter.lines != table.Rows {
+		return fmt.Errorf("holds %d rows, the manifest lists %d", counter.lines, table.Rows)
+	}
+
+	if restore == nil {
+		return nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return restore(table, file)
+}
+
+type lineCounter struct {
+	lines int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			c.lines++
+		}
+	}
+	return len(p), nil
+}
+
+// replay inserts every row of rows into table, with up to Concurrency
+// inserts in flight. It stops at the first failed insert.
+func replay(session *gocql.Session, table string, rows io.Reader) error {
+	scanner := bufio.NewScanner(rows)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+
+	statement := `INSERT INTO ` + table + ` JSON ?;`
+
+	lines := make(chan string)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var insertErr error
+	done := make(chan struct{})
+
+	for i := 0; i < Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				if err := session.Query(statement, line).Exec(); err != nil {
+					once.Do(func() {
+						insertErr = err
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+
+scan:
+	for scanner.Scan() {
+		select {
+		case lines <- scanner.Text():
+		case <-done:
+			break scan
+		}
+	}
+	close(lines)
+	wg.Wait()
+
+	if insertErr != nil {
+		return insertErr
+	}
+	return scanner.Err()
+}
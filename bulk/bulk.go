@@ -0,0 +1,71 @@
+package bulk
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// MaxItems bounds the number of items of a single bulk request.
+	MaxItems = 500
+	// Concurrency is how many items are written at the same time.
+	Concurrency = 8
+)
+
+// Result reports the outcome for the item at Index of the request.
+type Result struct {
+	Index   int    `json:"index"`
+	ID      string `json:"id,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Response summarises a bulk request.
+type Response struct {
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	Results   []Result `json:"results"`
+}
+
+// Run calls fn for every index in [0, n), with at most concurrency calls in
+// flight, and collects the results in index order. fn returns the id of the
+// item it wrote. A panicking item fails on its own instead of the request.
+func Run(n, concurrency int, fn func(i int) (id string, err error)) Response {
+	results := make([]Result, n)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				if r := recover(); r != nil {
+					results[i] = Result{Index: i, Error: fmt.Sprint("internal error: ", r)}
+				}
+				<-sem
+				wg.Done()
+			}()
+
+			id, err := fn(i)
+			results[i] = Result{Index: i, ID: id, Success: err == nil}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	response := Response{Results: results}
+	for _, result := range results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,114 @@
+package cascade
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	auditRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/audit/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/events"
+	moderationRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/moderation/repository"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+// Report lists what deleting a proposal removed.
+type Report struct {
+	ProposalID string `json:"proposal_id"`
+	// ProposalRows counts the copies of the proposal, one per proposal table.
+	ProposalRows int `json:"proposal_rows"`
+	Comments     int `json:"comments"`
+	// CommentRows counts the comment rows across both comment tables.
+	CommentRows       int `json:"comment_rows"`
+	ProposalUpvotes   int `json:"proposal_upvotes"`
+	ProposalDownvotes int `json:"proposal_downvotes"`
+	CommentUpvotes    int `json:"comment_upvotes"`
+	// Reports counts the reports about the proposal and its comments,
+	// ModerationItems their moderation states.
+	Reports         int `json:"reports"`
+	ModerationItems int `json:"moderation_items"`
+}
+
+// DeleteProposal deletes the proposal together with its comments, the votes
+// counted on both and the reports and moderation states about them, and
+// reports what was removed. Everything that
+// depends on the proposal goes first and proposals_by_id last, so when a
+// step fails the proposal can still be found and the deletion is retried
+// as a whole. The deletion of the comments and of the proposal are audited
+// as actor's.
+func DeleteProposal(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID) (Report, error) {
+	report := Report{ProposalID: proposalID.String()}
+
+	proposals, err := proposalRepository.GetLatestProposal(session, proposalID)
+	if err != nil {
+		return report, err
+	}
+	if len(proposals) == 0 {
+		return report, proposalRepository.ErrProposalNotFound
+	}
+	proposal := proposals[0]
+
+	targets := []uuid.UUID{proposalID}
+	err = repository.IterateCommentsByProposalID(session, proposalID, func(comment entity.Comment) error {
+		report.Comments++
+		report.CommentUpvotes += comment.UpVotes
+		targets = append(targets, comment.CommentID)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, targetID := range targets {
+		if err := deleteModeration(session, targetID, &report); err != nil {
+			return report, err
+		}
+	}
+
+	if err := repository.DeleteAllProposalComments(session, actor, proposalID); err != nil {
+		return report, err
+	}
+	report.CommentRows = report.Comments * len(repository.CommentTables)
+
+	tables := proposalRepository.ProposalTables
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := proposalRepository.DeleteProposalRow(session, tables[i], proposal); err != nil {
+			return report, err
+		}
+		report.ProposalRows++
+	}
+
+	report.ProposalUpvotes = proposal.UpVotes
+	report.ProposalDownvotes = proposal.DownVotes
+
+	// the proposal is gone by now, a failed audit write does not bring it back
+	_ = auditRepository.Log(session, actor, entity.AuditDelete, entity.AuditTargetProposal, proposalID, uuid.Nil, proposal, nil)
+	events.Publish(events.Event{Action: entity.AuditDelete, TargetType: entity.AuditTargetProposal, ProposalID: proposalID, Actor: actor, Before: proposal})
+
+	return report, nil
+}
+
+// deleteModeration removes the reports about the target and its moderation
+// state and counts them in report.
+func deleteModeration(session *gocql.Session, targetID uuid.UUID, report *Report) error {
+	reports, err := moderationRepository.GetReports(session, targetID)
+	if err != nil {
+		return err
+	}
+	if len(reports) > 0 {
+		if err := moderationRepository.DeleteReports(session, targetID); err != nil {
+			return err
+		}
+		report.Reports += len(reports)
+	}
+
+	item, err := moderationRepository.GetItem(session, targetID)
+	if err != nil || item == nil {
+		return err
+	}
+	if err := moderationRepository.DeleteItem(session, *item); err != nil {
+		return err
+	}
+	report.ModerationItems++
+
+	return nil
+}
@@ -0,0 +1,160 @@
+// Command backup writes the proposal and comment tables to a portable
+// archive and restores them from it.
+//
+//	backup create -out proposals.tar.gz
+//	backup verify proposals.tar.gz
+//	backup restore -keyspace user_proposals_and_comments_restored proposals.tar.gz
+//
+// An archive is a gzipped tar file holding a manifest.json with the row count
+// and SHA-256 checksum of every table, followed by the rows of each table as
+// JSON lines.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/backup"
+	config "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/config"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "create":
+		err = create(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: backup create|verify|restore [flags]")
+	os.Exit(2)
+}
+
+func create(args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	host := flags.String("host", envOr("CASSANDRA_HOST", "127.0.0.1"), "Cassandra host")
+	keyspace := flags.String("keyspace", config.Keyspace, "keyspace to back up")
+	out := flags.String("out", "", "archive to write, defaults to <keyspace>-<time>.tar.gz")
+	flags.Parse(args)
+
+	if *out == "" {
+		*out = fmt.Sprintf("%s-%s.tar.gz", *keyspace, time.Now().UTC().Format("20060102-150405"))
+	}
+
+	session, err := config.InitializeCassandraKeyspace(*host, *keyspace)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// write next to the target and rename, so a failed backup never leaves
+	// a truncated archive under the final name
+	tmp := *out + ".partial"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := backup.Write(session, *keyspace, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "wrote", *out)
+	return printManifest(manifest)
+}
+
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: backup verify archive")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	manifest, err := backup.Verify(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "the archive is intact")
+	return printManifest(manifest)
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	host := flags.String("host", envOr("CASSANDRA_HOST", "127.0.0.1"), "Cassandra host")
+	keyspace := flags.String("keyspace", config.Keyspace, "existing keyspace to restore into, its tables are created if missing")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: backup restore [-host host] [-keyspace keyspace] archive")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	session, err := config.InitializeCassandraKeyspace(*host, *keyspace)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := config.SchemaMigration(session); err != nil {
+		return err
+	}
+
+	manifest, err := backup.Restore(session, file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "restored into", *keyspace)
+	return printManifest(manifest)
+}
+
+func printManifest(manifest backup.Manifest) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
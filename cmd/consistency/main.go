@@ -0,0 +1,75 @@
+// Command consistency compares the denormalized copies of every proposal and
+// comment with proposals_by_id and comments_by_proposal_and_comment_id and
+// prints the divergences it finds as JSON.
+//
+//	consistency -host 127.0.0.1
+//	consistency -host 127.0.0.1 -repair
+//
+// It exits with status 3 when it found divergences and did not repair them
+// all, so it can run from cron and alert.
+//
+// With -backfill-markup it instead stores the HTML and plain renderings of
+// the proposals and comments written before they were stored, and prints how
+// many rows it rendered.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	config "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/config"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/consistency"
+)
+
+func main() {
+	host := flag.String("host", envOr("CASSANDRA_HOST", "127.0.0.1"), "Cassandra host")
+	repair := flag.Bool("repair", false, "rewrite divergent copies from the source of truth")
+	backfillMarkup := flag.Bool("backfill-markup", false, "render the proposals and comments stored without HTML and plain text")
+	flag.Parse()
+
+	session, err := config.InitializeCassandraDB(*host)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "consistency:", err)
+		os.Exit(1)
+	}
+	defer session.Close()
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if *backfillMarkup {
+		report, err := consistency.BackfillMarkup(session)
+		encoder.Encode(report)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "consistency:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	report, err := consistency.Check(session, consistency.Options{Repair: *repair})
+	encoder.Encode(report)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "consistency:", err)
+		os.Exit(1)
+	}
+
+	found := 0
+	for _, n := range report.Found {
+		found += n
+	}
+	if found > report.Repaired {
+		fmt.Fprintf(os.Stderr, "consistency: %d divergences, %d repaired\n", found, report.Repaired)
+		os.Exit(3)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,85 @@
+// Command export writes proposals with their comments to a CSV or NDJSON
+// file, for reports that are read offline.
+//
+//	export -host 127.0.0.1 -format csv -date-from 2022-01-01 -out proposals.csv
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	config "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/config"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/daterange"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/export"
+)
+
+func main() {
+	host := flag.String("host", envOr("CASSANDRA_HOST", "127.0.0.1"), "Cassandra host")
+	format := flag.String("format", export.FormatCSV, "csv or ndjson")
+	out := flag.String("out", "", "file to write, standard output if empty")
+	dateFrom := flag.String("date-from", "", "only proposals created at or after")
+	dateTo := flag.String("date-to", "", "only proposals created at or before")
+	last := flag.String("last", "", "only proposals created within e.g. 30d")
+	tz := flag.String("tz", "", "time zone of dates without an offset")
+	userID := flag.String("user-id", "", "only proposals of this user")
+	status := flag.String("status", "", "only proposals with this status")
+	comments := flag.Bool("comments", true, "include the comments")
+	flag.Parse()
+
+	if err := run(*host, *out, export.Options{Format: *format, Comments: *comments}, daterange.Query{
+		From: *dateFrom,
+		To:   *dateTo,
+		Last: *last,
+		TZ:   *tz,
+	}, *userID, *status); err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		os.Exit(1)
+	}
+}
+
+func run(host, out string, opts export.Options, q daterange.Query, userID, status string) error {
+	filter, err := export.ParseFilter(q, userID, status, time.Now())
+	if err != nil {
+		return err
+	}
+	opts.Filter = filter
+
+	session, err := config.InitializeCassandraDB(host)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		file, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	buffered := bufio.NewWriter(w)
+	summary, err := export.Write(session, buffered, opts)
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d proposals and %d comments\n", summary.Proposals, summary.Comments)
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,103 @@
+// Command import reads proposals and comments from a CSV or NDJSON file in
+// the layout written by the export command and stores them, keeping their
+// ids, votes and timestamps.
+//
+//	import -host 127.0.0.1 -dry-run proposals.csv
+//	import -host 127.0.0.1 proposals.csv
+//
+// Progress is saved next to the input after every proposal. When an import
+// fails, running the same command again continues where it stopped.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	config "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/config"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/export"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/importer"
+)
+
+func main() {
+	host := flag.String("host", envOr("CASSANDRA_HOST", "127.0.0.1"), "Cassandra host")
+	format := flag.String("format", "", "csv or ndjson, guessed from the file extension if empty")
+	dryRun := flag.Bool("dry-run", false, "validate the file without writing anything")
+	checkpoint := flag.String("checkpoint", "", "progress file, defaults to the input with .checkpoint appended")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: import [flags] file")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+
+	if *format == "" {
+		*format = export.FormatCSV
+		if ext := strings.ToLower(filepath.Ext(input)); ext == ".ndjson" || ext == ".jsonl" {
+			*format = export.FormatNDJSON
+		}
+	}
+	if *checkpoint == "" {
+		*checkpoint = input + ".checkpoint"
+	}
+
+	summary, err := run(*host, input, importer.Options{
+		Format:     *format,
+		DryRun:     *dryRun,
+		Checkpoint: *checkpoint,
+		Input:      filepath.Base(input),
+	})
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(summary)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		if !*dryRun {
+			fmt.Fprintln(os.Stderr, "import: run the same command again to continue from", *checkpoint)
+		}
+		os.Exit(1)
+	}
+	if len(summary.Invalid) > 0 {
+		fmt.Fprintf(os.Stderr, "import: %d rows were not imported\n", len(summary.Invalid))
+		os.Exit(3)
+	}
+}
+
+func run(host, input string, opts importer.Options) (importer.Summary, error) {
+	file, err := os.Open(input)
+	if err != nil {
+		return importer.Summary{}, err
+	}
+	defer file.Close()
+
+	session, err := config.InitializeCassandraDB(host)
+	if err != nil {
+		return importer.Summary{}, err
+	}
+	defer session.Close()
+
+	if !opts.DryRun {
+		if err := config.SchemaMigration(session); err != nil {
+			return importer.Summary{}, err
+		}
+	}
+
+	return importer.Run(session, file, opts)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
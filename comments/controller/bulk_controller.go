@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/bulk"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+)
+
+type BulkComment struct {
+	ProposalID string `json:"proposal_id" validate:"required,uuid"`
+	Comment    string `json:"comment" validate:"required,max=2000"`
+	// The author defaults to the admin sending the request. A username is
+	// required when a user_id is given.
+	UserID   string `json:"user_id" validate:"uuid"`
+	Username string `json:"username" validate:"max=100"`
+}
+
+// Items are validated one by one so a bad row only fails itself.
+type BulkCreateCommentsRequest struct {
+	Items []BulkComment `json:"items" validate:"-"`
+}
+
+type BulkCommentRef struct {
+	ProposalID string `json:"proposal_id" validate:"required,uuid"`
+	CommentID  string `json:"comment_id" validate:"required,uuid"`
+}
+
+type BulkDeleteCommentsRequest struct {
+	Items []BulkCommentRef `json:"items" validate:"-"`
+}
+
+var (
+	errUsernameRequired = errors.New("username is required when user_id is given")
+	errProposalNotFound = errors.New("proposal not found")
+)
+
+// commentCounts tallies the comments written or deleted per proposal during
+// a bulk request, so each count is adjusted once instead of per item.
+type commentCounts struct {
+	mu     sync.Mutex
+	deltas map[uuid.UUID]int
+}
+
+func (c *commentCounts) add(proposalID uuid.UUID, delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deltas[proposalID] += delta
+}
+
+// apply updates the comment counts of the proposals. The comments themselves
+// are already written, so a failed update only leaves a count behind.
+func (c *commentCounts) apply(session *gocql.Session) {
+	for proposalID, delta := range c.deltas {
+		_ = proposalRepository.AdjustNumberOfComments(session, proposalID, delta)
+	}
+}
+
+// BulkCreateComments
+// @Summary Create many comments
+// @Description Create up to 500 comments at once, possibly under different proposals - for only admin. The comments are not run through the content filter, they are stored as sent
+// @Tags proposal comment bulk
+// @Accept json
+// @Produce json
+// @Param bulk_create_comments_request body BulkCreateCommentsRequest true "the comments to create"
+// @Success 200 {object} response.Response{Data=bulk.Response}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/comment/bulk/create [post]
+// @Security JWTToken
+func (p *CommentsController) BulkCreateComments(c echo.Context) error {
+	var req BulkCreateCommentsRequest
+
+	if err := c.Bind(&req); err != nil || len(req.Items) == 0 || len(req.Items) > bulk.MaxItems {
+		return p.WriteBulkSizeError(c)
+	}
+
+	token := c.Request().Header.Get("Authorization")
+	tokenSession, err := p.TokenSessionRepository.GetOneFlexible("token", token)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	actor := entity.Actor{UserID: tokenSession.UserID, Username: tokenSession.User.Username, IP: c.RealIP()}
+
+	// look every proposal up once instead of once per comment
+	exists := make(map[uuid.UUID]bool)
+	for _, item := range req.Items {
+		proposalID, err := uuid.Parse(item.ProposalID)
+		if err != nil {
+			continue
+		}
+		if _, seen := exists[proposalID]; seen {
+			continue
+		}
+
+		proposal, err := proposalRepository.GetLatestProposal(p.Session, proposalID)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 500,
+				Message:   "Something went wrong.",
+			}
+			message := "false"
+			return p.WriteInternalServerError(c, message, resp, "")
+		}
+		exists[proposalID] = len(proposal) > 0
+	}
+
+	counts := commentCounts{deltas: make(map[uuid.UUID]int)}
+	validator := validation.New()
+	result := bulk.Run(len(req.Items), bulk.Concurrency, func(i int) (string, error) {
+		item := req.Items[i]
+		if err := validator.Validate(&item); err != nil {
+			return "", err
+		}
+
+		proposalID, err := uuid.Parse(item.ProposalID)
+		if err != nil {
+			return "", err
+		}
+		if !exists[proposalID] {
+			return "", errProposalNotFound
+		}
+
+		userID, username := tokenSession.UserID, tokenSession.User.Username
+		if item.UserID != "" {
+			if item.Username == "" {
+				return "", errUsernameRequired
+			}
+			if userID, err = uuid.Parse(item.UserID); err != nil {
+				return "", err
+			}
+			username = item.Username
+		}
+
+		comment, err := repository.StoreComment(p.Session, actor, proposalID, item.Comment, userID, username, false)
+		if err != nil {
+			return "", err
+		}
+
+		counts.add(proposalID, 1)
+		return comment.CommentID.String(), nil
+	})
+
+	counts.apply(p.Session)
+
+	return p.WriteSuccess(c, result)
+}
+
+// BulkDeleteComments
+// @Summary Delete many comments
+// @Description Delete up to 500 comments by proposal id and comment id - for only admin
+// @Tags proposal comment bulk
+// @Accept json
+// @Produce json
+// @Param bulk_delete_comments_request body BulkDeleteCommentsRequest true "ids of the comments to delete"
+// @Success 200 {object} response.Response{Data=bulk.Response}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/comment/bulk/delete [post]
+// @Security JWTToken
+func (p *CommentsController) BulkDeleteComments(c echo.Context) error {
+	var req BulkDeleteCommentsRequest
+
+	if err := c.Bind(&req); err != nil || len(req.Items) == 0 || len(req.Items) > bulk.MaxItems {
+		return p.WriteBulkSizeError(c)
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	counts := commentCounts{deltas: make(map[uuid.UUID]int)}
+	validator := validation.New()
+	result := bulk.Run(len(req.Items), bulk.Concurrency, func(i int) (string, error) {
+		item := req.Items[i]
+		if err := validator.Validate(&item); err != nil {
+			return item.CommentID, err
+		}
+
+		proposalID, err := uuid.Parse(item.ProposalID)
+		if err != nil {
+			return item.CommentID, err
+		}
+		commentID, err := uuid.Parse(item.CommentID)
+		if err != nil {
+			return item.CommentID, err
+		}
+		if err := repository.DeleteCommentByID(p.Session, actor, proposalID, commentID); err != nil {
+			return item.CommentID, err
+		}
+
+		counts.add(proposalID, -1)
+		return item.CommentID, nil
+	})
+
+	counts.apply(p.Session)
+
+	return p.WriteSuccess(c, result)
+}
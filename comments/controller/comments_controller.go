@@ -1,12 +1,22 @@
 package controller
 
 import (
+	"net/http"
+	"time"
+
+	"github.com/gocql/gocql"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/contentfilter"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/httpcache"
+	idempotencyRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/idempotency/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/mergepatch"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/controller"
 	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
 )
 
 type CommentsController struct {
@@ -20,25 +30,51 @@ func NewCommentsController(proposalController *controller.ProposalController) *C
 }
 
 type WriteCommentRequest struct {
-	ProposalID string `json:"proposal_id" form:"proposal_id"`
-	Comment    string `json:"comment" form:"comment"`
+	ProposalID string `json:"proposal_id" form:"proposal_id" validate:"required,uuid"`
+	Comment    string `json:"comment" form:"comment" validate:"required,max=2000"`
 }
 
 // WriteComment
 // @Summary Create a comment for a proposal
-// @Description Create a comment under a proposal
+// @Description Create a comment under a proposal. A comment the content filter holds is stored hidden until a moderator reviews it
 // @Tags proposal comment
 // @Accept json
 // @Produce json
 // @Param write_comment_request body WriteCommentRequest true "json request with proposal id and comment"
-// @Success 200 {object} response.Response{Data=string}
-// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Param Idempotency-Key header string false "unique key per comment, retries with the same key do not create duplicates"
+// @Success 201 {object} response.Response{Data=entity.Comment}
+// @Failure 400 {object} response.Response{Data=controller.FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 422 {object} response.Response{Data=controller.ContentRejectedResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/comment/create [post]
 // @Security JWTToken
 func (p *CommentsController) WriteComment(c echo.Context) error {
 	var req WriteCommentRequest
 
+	if err := c.Bind(&req); err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return p.WriteValidationError(c, err)
+	}
+
+	proposalID, err := uuid.Parse(req.ProposalID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
 	token := c.Request().Header.Get("Authorization")
 	tokenSession, err := p.TokenSessionRepository.GetOneFlexible("token", token)
 	if err != nil {
@@ -50,35 +86,91 @@ func (p *CommentsController) WriteComment(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	if req.ProposalID == "" || req.Comment == "" {
+	// checked before the key is reserved, a comment that cannot be stored
+	// must not hold it
+	proposals, err := proposalRepository.GetProposalByProposalID(p.Session, proposalID)
+	if err != nil {
 		resp := response.ErrorResponse{
-			ErrorCode: 400,
-			Message:   "Please check your request again for errors",
+			ErrorCode: 500,
+			Message:   "Something went wrong",
 		}
 		message := "false"
-		return p.WriteBadRequest(c, message, resp)
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if len(proposals) == 0 {
+		return p.WriteNotFound(c, "Proposal not found")
 	}
 
-	if err := c.Bind(&req); err != nil {
-		resp := response.ErrorResponse{
-			ErrorCode: 400,
-			Message:   "Please check your request again for errors",
+	commentID := gocql.TimeUUID()
+	stored := false
+
+	idempotencyKey := c.Request().Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		if len(idempotencyKey) > idempotencyRepository.MaxKeyLength {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "Idempotency-Key is too long",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
 		}
-		message := "false"
-		return p.WriteBadRequest(c, message, resp)
+
+		fingerprint := idempotencyRepository.Fingerprint(proposalID.String(), req.Comment)
+		record, replayed, err := idempotencyRepository.Reserve(p.Session, "comment", tokenSession.UserID, idempotencyKey, fingerprint, idempotencyRepository.Record{ResourceID: uuid.UUID(commentID), ParentID: proposalID})
+		if err == idempotencyRepository.ErrKeyReused {
+			return p.WriteUnprocessableEntity(c, "This Idempotency-Key was already used for a different comment")
+		}
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 500,
+				Message:   "Something went wrong",
+			}
+			message := "false"
+			return p.WriteInternalServerError(c, message, resp, "")
+		}
+
+		if replayed {
+			return p.replayComment(c, record.ParentID, record.ResourceID)
+		}
+
+		// until the comment is stored every way out, a panic included, lets
+		// the client retry with the same key
+		defer func() {
+			if !stored {
+				_ = idempotencyRepository.Release(p.Session, "comment", tokenSession.UserID, idempotencyKey)
+			}
+		}()
 	}
 
-	proposalID, err := uuid.Parse(req.ProposalID)
+	// screened after the replay check, a retry would repeat the first post
+	submission := contentfilter.Submission{Kind: contentfilter.KindComment, UserID: tokenSession.UserID, Text: req.Comment}
+	screened, ok, err := p.ScreenContent(c, submission)
+	if !ok {
+		return err
+	}
+
+	actor := entity.Actor{UserID: tokenSession.UserID, Username: tokenSession.User.Username, IP: c.RealIP()}
+	held := screened.Verdict == contentfilter.Hold
+	comment, err := repository.StoreCommentWithID(p.Session, actor, commentID, proposalID, req.Comment, tokenSession.UserID, tokenSession.User.Username, held)
+	if err == proposalRepository.ErrProposalNotFound {
+		// deleted since it was checked
+		return p.WriteNotFound(c, "Proposal not found")
+	}
 	if err != nil {
 		resp := response.ErrorResponse{
-			ErrorCode: 400,
-			Message:   "Please check your request UUID for errors",
+			ErrorCode: 500,
+			Message:   "Something went wrong",
 		}
 		message := "false"
-		return p.WriteBadRequest(c, message, resp)
+		return p.WriteInternalServerError(c, message, resp, "")
 	}
+	stored = true
 
-	err = repository.StoreComment(p.Session, proposalID, req.Comment, tokenSession.UserID, tokenSession.User.Username)
+	// The comment is stored at this point. A count that failed to update is
+	// corrected by the next reconciliation, so it does not fail the request.
+	_ = proposalRepository.AddToNumberOfComments(p.Session, proposalID)
+
+	err = p.StoredContent(submission, screened, proposalID, comment.CommentID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -88,7 +180,13 @@ func (p *CommentsController) WriteComment(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	err = proposalRepository.AddToNumberOfComments(p.Session, proposalID)
+	return p.WriteCreated(c, CommentLocation(proposalID, comment.CommentID), comment)
+}
+
+// replayComment answers a retried create with the comment the first request
+// created.
+func (p *CommentsController) replayComment(c echo.Context, proposalID, commentID uuid.UUID) error {
+	comment, err := repository.GetCommentByIDAndProposalID(p.Session, proposalID, commentID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -98,7 +196,27 @@ func (p *CommentsController) WriteComment(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, "commented")
+	if comment == nil {
+		return p.WriteConflict(c, "A request with this Idempotency-Key is still being processed", nil)
+	}
+
+	c.Response().Header().Set("Idempotent-Replayed", "true")
+	return p.WriteCreated(c, CommentLocation(proposalID, commentID), comment)
+}
+
+// CommentLocation is the URL a comment can be fetched from. Like proposals,
+// comments are addressed by the ids in the path, PATCH takes the same ones.
+func CommentLocation(proposalID, commentID uuid.UUID) string {
+	return "/api/v1/user/proposal/comment/get/" + proposalID.String() + "/" + commentID.String()
+}
+
+// commentParam returns the id called name of the comment a request addresses,
+// from the path or, on the routes taking the ids in the query, the query.
+func commentParam(c echo.Context, name string) string {
+	if value := c.Param(name); value != "" {
+		return value
+	}
+	return c.QueryParam(name)
 }
 
 // GetCommentsByProposalID
@@ -108,13 +226,21 @@ func (p *CommentsController) WriteComment(c echo.Context) error {
 // @Accept plain
 // @Produce json
 // @Param proposal_id path string true "get all comments by proposal id"
+// @Param If-None-Match header string false "ETag of a previous response"
+// @Param format query string false "text format: markdown (default), html or plain"
 // @Success 200 {object} response.Response{Data=[]entity.Comment}
+// @Success 304 "not modified"
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/comment/getAll/:proposal-id [get]
 // @Security JWTToken
 // @Security APIKey
 func (p *CommentsController) GetCommentsByProposalID(c echo.Context) error {
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
+	}
+
 	proposalIDString := c.Param("proposal-id")
 	proposalID, err := uuid.Parse(proposalIDString)
 	if err != nil {
@@ -136,7 +262,11 @@ func (p *CommentsController) GetCommentsByProposalID(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, comments)
+	if httpcache.NotModified(c, httpcache.ForComments(comments).WithFormat(format)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return p.WriteSuccess(c, controller.FormatComments(comments, format))
 }
 
 // GetCommentsByIDAndProposalID
@@ -147,15 +277,23 @@ func (p *CommentsController) GetCommentsByProposalID(c echo.Context) error {
 // @Produce json
 // @Param proposal_id path string true "a common proposal id "
 // @Param comment_id path string true "a unique comment id"
+// @Param format query string false "text format: markdown (default), html or plain"
 // @Success 200 {object} response.Response{Data=[]entity.Comment}
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/comment/get [get]
+// @Router /proposal/comment/get/:proposal-id/:comment-id [get]
 // @Security JWTToken
 // @Security APIKey
 func (p *CommentsController) GetCommentByIDAndProposalID(c echo.Context) error {
-	proposalIDString := c.QueryParam("proposal-id")
-	commentIDString := c.QueryParam("comment-id")
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
+	}
+
+	proposalIDString := commentParam(c, "proposal-id")
+	commentIDString := commentParam(c, "comment-id")
 
 	proposalID, err := uuid.Parse(proposalIDString)
 	if err != nil {
@@ -187,24 +325,43 @@ func (p *CommentsController) GetCommentByIDAndProposalID(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
+	// hidden comments are answered as missing, so neither they nor their
+	// ETag give away that they exist
+	if comment == nil || !p.CanSee(c, comment.Hidden, comment.UserCommentedID) {
+		return p.WriteNotFound(c, "Comment not found")
+	}
+
+	c.Response().Header().Set("ETag", httpcache.ForComments([]entity.Comment{*comment}).WithFormat(format).ETag)
+	comment = &controller.FormatComments([]entity.Comment{*comment}, format)[0]
+
 	return p.WriteSuccess(c, comment)
 }
 
 type UpdateCommentRequest struct {
-	ProposalID     string `json:"proposal_id" form:"proposal_id"`
-	CommentID      string `json:"comment_id" form:"comment_id"`
-	UpdatedComment string `json:"updated_comment" form:"updated_comment"`
+	ProposalID string `json:"proposal_id" form:"proposal_id" validate:"required,uuid"`
+	CommentID  string `json:"comment_id" form:"comment_id" validate:"required,uuid"`
+	Comment    string `json:"comment" form:"comment" validate:"required,max=2000"`
+	// Deprecated: UpdatedComment is the old name of comment.
+	UpdatedComment string `json:"updated_comment" form:"updated_comment" validate:"-"`
+	// LastUpdated is the version the edit is based on. It can be left out
+	// when the request carries an If-Match header instead.
+	LastUpdated time.Time `json:"last_updated" form:"last_updated"`
 }
 
 // UpdateComment
 // @Summary Update a single comment
-// @Description Update a single comment using proposal and comment id
+// @Description Update a single comment using proposal and comment id. The edit is only applied if the comment has not changed since the version given by last_updated or If-Match
 // @Tags proposal comment
 // @Accept json
 // @Produce json
-// @Param update_comment_request body UpdateCommentRequest true "a json body req with proposal id, comment id and the updated comment"
-// @Success 200 {object} response.Response{Data=string}
-// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Param update_comment_request body UpdateCommentRequest true "a json body req with proposal id, comment id, the updated comment and the last_updated being edited"
+// @Param If-Match header string false "ETag of the comment being edited"
+// @Success 200 {object} response.Response{Data=entity.Comment}
+// @Failure 400 {object} response.Response{Data=controller.FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=controller.ConflictResponse}
+// @Failure 428 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 422 {object} response.Response{Data=controller.ContentRejectedResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/comment/update [put]
 // @Security JWTToken
@@ -220,11 +377,19 @@ func (p *CommentsController) UpdateComment(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
+	if req.Comment == "" {
+		req.Comment = req.UpdatedComment
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return p.WriteValidationError(c, err)
+	}
+
 	proposalID, err := uuid.Parse(req.ProposalID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 400,
-			Message:   "Please check your UUID string for errors",
+			Message:   "Please check your request again for errors",
 		}
 		message := "false"
 		return p.WriteBadRequest(c, message, resp)
@@ -234,14 +399,165 @@ func (p *CommentsController) UpdateComment(c echo.Context) error {
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 400,
-			Message:   "Please check your UUID string for errors",
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	return p.updateComment(c, proposalID, commentID, req.Comment, req.LastUpdated)
+}
+
+// PatchComment
+// @Summary Partially update a comment
+// @Description Change the comment text with a JSON Merge Patch. The edit is only applied if the comment has not changed since the version given by If-Match or last_updated
+// @Tags proposal comment
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param proposal-id path string true "a common proposal id"
+// @Param comment-id path string true "a unique comment id"
+// @Param patch body PatchCommentRequest true "the fields to change"
+// @Param If-Match header string false "ETag of the comment being edited"
+// @Success 200 {object} response.Response{Data=entity.Comment}
+// @Failure 400 {object} response.Response{Data=controller.FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=controller.ConflictResponse}
+// @Failure 415 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 428 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 422 {object} response.Response{Data=controller.ContentRejectedResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/comment/update/:proposal-id/:comment-id [patch]
+// @Security JWTToken
+func (p *CommentsController) PatchComment(c echo.Context) error {
+	proposalID, err := uuid.Parse(c.Param("proposal-id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your proposal ID in request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	commentID, err := uuid.Parse(c.Param("comment-id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your comment ID in request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	patch, err := mergepatch.Decode(c.Request())
+	if err == mergepatch.ErrUnsupportedMediaType {
+		return p.WriteUnsupportedMediaType(c, err.Error())
+	}
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   err.Error(),
 		}
 		message := "false"
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	err = repository.UpdateCommentByID(p.Session, proposalID, commentID, req.UpdatedComment)
+	var fieldErrors validation.Errors
+	for _, field := range patch.Unknown("comment", "last_updated") {
+		fieldErrors = append(fieldErrors, validation.FieldError{Field: field, Code: validation.CodeUnknown, Message: "is not a field of a comment"})
+	}
+
+	comment, commentPresent := controller.PatchText(patch, "comment", "max=2000", &fieldErrors)
+
+	var lastUpdated time.Time
+	if _, err := patch.Unmarshal("last_updated", &lastUpdated); err != nil {
+		fieldErrors = append(fieldErrors, validation.FieldError{Field: "last_updated", Code: validation.CodeType, Message: "must be an RFC 3339 timestamp"})
+	}
+
+	if len(fieldErrors) == 0 && !commentPresent {
+		fieldErrors = append(fieldErrors, validation.FieldError{Code: validation.CodeRequired, Message: "patch must change comment"})
+	}
+
+	if len(fieldErrors) > 0 {
+		return p.WriteFieldErrors(c, fieldErrors)
+	}
+
+	return p.updateComment(c, proposalID, commentID, comment, lastUpdated)
+}
+
+// PatchCommentRequest documents the members PatchComment accepts.
+type PatchCommentRequest struct {
+	Comment     string    `json:"comment,omitempty"`
+	LastUpdated time.Time `json:"last_updated,omitempty"`
+}
+
+// updateComment replaces the text of the latest version of the comment,
+// provided that is the version named by the If-Match header or, without one,
+// by lastUpdated.
+func (p *CommentsController) updateComment(c echo.Context, proposalID, commentID uuid.UUID, updatedComment string, lastUpdated time.Time) error {
+	current, err := repository.GetCommentByIDAndProposalID(p.Session, proposalID, commentID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	if current == nil {
+		return p.WriteNotFound(c, "Comment not found")
+	}
+
+	etag := httpcache.ForComments([]entity.Comment{*current}).ETag
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" {
+		if !httpcache.MatchVersion(ifMatch, etag) {
+			c.Response().Header().Set("ETag", etag)
+			return p.WriteConflict(c, "The comment was changed by someone else, please review the current version", current)
+		}
+
+		lastUpdated = current.LastUpdated
+	}
+
+	if lastUpdated.IsZero() {
+		return p.WritePreconditionRequired(c, "Send the last_updated of the comment you are editing or an If-Match header")
+	}
+
+	if !lastUpdated.Equal(current.LastUpdated) {
+		c.Response().Header().Set("ETag", etag)
+		return p.WriteConflict(c, "The comment was changed by someone else, please review the current version", current)
+	}
+
+	actor, err := p.RequestActor(c)
 	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	// an edit that changes nothing would be taken for a duplicate of itself
+	changed := updatedComment != current.CommentText
+	submission := contentfilter.Submission{Kind: contentfilter.KindComment, UserID: current.UserCommentedID, Text: updatedComment}
+	screened := contentfilter.Result{Verdict: contentfilter.Allow}
+	if changed {
+		var ok bool
+		if screened, ok, err = p.ScreenContent(c, submission); !ok {
+			return err
+		}
+	}
+
+	comment, err := repository.UpdateCommentByID(p.Session, actor, proposalID, commentID, updatedComment, lastUpdated, screened.Verdict == contentfilter.Hold)
+	switch err {
+	case nil:
+	case repository.ErrCommentNotFound:
+		return p.WriteNotFound(c, "Comment not found")
+	case repository.ErrCommentConflict:
+		c.Response().Header().Set("ETag", httpcache.ForComments([]entity.Comment{comment}).ETag)
+		return p.WriteConflict(c, "The comment was changed by someone else, please review the current version", comment)
+	default:
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
 			Message:   "Something went wrong",
@@ -250,7 +566,20 @@ func (p *CommentsController) UpdateComment(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, "updated")
+	if changed {
+		err = p.StoredContent(submission, screened, proposalID, commentID)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 500,
+				Message:   "Something went wrong",
+			}
+			message := "false"
+			return p.WriteInternalServerError(c, message, resp, "")
+		}
+	}
+
+	c.Response().Header().Set("ETag", httpcache.ForComments([]entity.Comment{comment}).ETag)
+	return p.WriteSuccess(c, comment)
 }
 
 // DeleteComment
@@ -290,26 +619,29 @@ func (p *CommentsController) DeleteComment(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	err = repository.DeleteCommentByID(p.Session, proposalID, commentID)
+	actor, err := p.RequestActor(c)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
-			Message:   err.Error(),
+			Message:   "Something went wrong.",
 		}
 		message := "false"
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	err = proposalRepository.SubtractFromNumberOfComments(p.Session, proposalID)
+	err = repository.DeleteCommentByID(p.Session, actor, proposalID, commentID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
-			Message:   "Something went wrong.",
+			Message:   err.Error(),
 		}
 		message := "false"
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
+	// see WriteComment, the reconciliation corrects a count that failed to update
+	_ = proposalRepository.SubtractFromNumberOfComments(p.Session, proposalID)
+
 	return p.WriteSuccess(c, "deleted")
 }
 
@@ -338,7 +670,17 @@ func (p *CommentsController) DeleteAllProposalComments(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	err = repository.DeleteAllProposalComments(p.Session, proposalID)
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	err = repository.DeleteAllProposalComments(p.Session, actor, proposalID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -397,7 +739,18 @@ func (p *CommentsController) UpvoteComment(c echo.Context) error {
 		message := "false"
 		return p.WriteBadRequest(c, message, resp)
 	}
-	err = repository.UpvoteComment(p.Session, proposalID, commentID)
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	err = repository.UpvoteComment(p.Session, actor, proposalID, commentID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	mentionsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/mentions/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/controller"
+)
+
+// MaxMentionsLimit caps the comments a mentions request returns.
+const MaxMentionsLimit = 100
+
+// MentionsResponse lists comments the user was mentioned in, newest first.
+type MentionsResponse struct {
+	Comments []entity.Comment `json:"comments"`
+	// Next is the before parameter of the next page, empty on the last one.
+	Next *time.Time `json:"next,omitempty"`
+}
+
+// GetMentions
+// @Summary Comments mentioning me
+// @Description The comments that mention the requesting user with @username, newest first. Deleted and hidden comments are left out
+// @Tags proposal comment
+// @Accept plain
+// @Produce json
+// @Param limit query int false "at most this many comments, default 20, up to 100"
+// @Param before query string false "RFC 3339, only comments created before it, the next of the previous page"
+// @Param format query string false "text format: markdown (default), html or plain"
+// @Success 200 {object} response.Response{Data=MentionsResponse}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/comment/mentions [get]
+// @Security JWTToken
+func (p *CommentsController) GetMentions(c echo.Context) error {
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
+	}
+
+	limit := 20
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > MaxMentionsLimit {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "limit must be a number between 1 and " + strconv.Itoa(MaxMentionsLimit),
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		limit = n
+	}
+
+	var before time.Time
+	if value := c.QueryParam("before"); value != "" {
+		before, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "before must be an RFC 3339 timestamp",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	mentions, err := mentionsRepository.GetMentions(p.Session, actor.UserID, before, limit)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	result := MentionsResponse{Comments: []entity.Comment{}}
+	for _, mention := range mentions {
+		comment, err := repository.GetCommentByIDAndProposalID(p.Session, mention.ProposalID, mention.CommentID)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 500,
+				Message:   "Something went wrong",
+			}
+			message := "false"
+			return p.WriteInternalServerError(c, message, resp, "")
+		}
+
+		if comment == nil {
+			// left behind by a delete that failed halfway
+			_ = mentionsRepository.RemoveMention(p.Session, actor.UserID, entity.Comment{CommentID: mention.CommentID, CreatedAt: mention.CreatedAt})
+			continue
+		}
+		if comment.Hidden {
+			continue
+		}
+
+		result.Comments = append(result.Comments, *comment)
+	}
+
+	// a full page of index entries may be followed by more, even if some of
+	// them were left out
+	if len(mentions) == limit {
+		next := mentions[len(mentions)-1].CreatedAt
+		result.Next = &next
+	}
+
+	result.Comments = controller.FormatComments(result.Comments, format)
+	return p.WriteSuccess(c, result)
+}
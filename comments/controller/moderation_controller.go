@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+// ReportComment
+// @Summary Report a comment
+// @Description Report a comment as spam, abusive or otherwise inappropriate. Each user can report a comment once.
+// @Tags proposal comment moderation
+// @Accept json
+// @Produce json
+// @Param proposal-id query string true "a common proposal id"
+// @Param comment-id query string true "a unique comment id"
+// @Param report_request body controller.ReportRequest true "why the comment is reported"
+// @Success 200 {object} response.Response{Data=entity.Report}
+// @Failure 400 {object} response.Response{Data=controller.FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=controller.ConflictResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/comment/report [post]
+// @Security JWTToken
+func (p *CommentsController) ReportComment(c echo.Context) error {
+	proposalID, err := uuid.Parse(c.QueryParam("proposal-id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your proposal ID in request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	commentID, err := uuid.Parse(c.QueryParam("comment-id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your comment ID in request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	comment, err := repository.GetCommentByIDAndProposalID(p.Session, proposalID, commentID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if comment == nil {
+		return p.WriteNotFound(c, "Comment not found")
+	}
+
+	report := entity.Report{TargetType: entity.AuditTargetComment, ProposalID: proposalID, CommentID: commentID}
+	return p.SubmitReport(c, report, comment.UserCommentedID)
+}
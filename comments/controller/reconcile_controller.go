@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/consistency"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+// ReconcileCommentCounts
+// @Summary Recompute comment counts
+// @Description Count the comments stored for every proposal, or the one given, and correct no_of_comments where it drifted - for only admin
+// @Tags proposal comment
+// @Accept plain
+// @Produce json
+// @Param proposal-id query string false "only reconcile this proposal"
+// @Success 200 {object} response.Response{Data=consistency.CountReport}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/comment/reconcile [post]
+// @Security JWTToken
+func (p *CommentsController) ReconcileCommentCounts(c echo.Context) error {
+	proposalIDString := c.QueryParam("proposal-id")
+	if proposalIDString == "" {
+		report, err := consistency.ReconcileCommentCounts(p.Session)
+		if err == consistency.ErrReconcileRunning {
+			return p.WriteConflict(c, err.Error(), nil)
+		}
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 500,
+				Message:   err.Error(),
+			}
+			message := "false"
+			return p.WriteInternalServerError(c, message, resp, "")
+		}
+
+		return p.WriteSuccess(c, report)
+	}
+
+	proposalID, err := uuid.Parse(proposalIDString)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	report := consistency.CountReport{StartedAt: time.Now().UTC(), Checked: 1}
+	correction, err := consistency.ReconcileCommentCount(p.Session, proposalID)
+	if err == proposalRepository.ErrProposalNotFound {
+		return p.WriteNotFound(c, "Proposal not found")
+	}
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   err.Error(),
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if correction != nil {
+		report.Corrected = append(report.Corrected, *correction)
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	return p.WriteSuccess(c, report)
+}
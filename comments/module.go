@@ -1,25 +1,97 @@
 package user
 
 import (
+	"time"
+
 	"github.com/gocql/gocql"
 	"github.com/labstack/echo/v4"
 	tokenSessionsRepository "github.com/windswept321/smartest-city-roadmap-go/module/tokensession/repository"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/controller"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/consistency"
+	mentionsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/mentions/repository"
 	proposalController "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/controller"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/ratelimit"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
 	"gorm.io/gorm"
 )
 
+// Rate limits of the comment routes, applied per user and per client IP.
+var (
+	CreateRateLimit = ratelimit.Config{Name: "comment-create", UserRate: ratelimit.PerMinute(10), IPRate: ratelimit.PerMinute(60)}
+	UpdateRateLimit = ratelimit.Config{Name: "comment-update", UserRate: ratelimit.PerMinute(20), IPRate: ratelimit.PerMinute(60)}
+	VoteRateLimit   = ratelimit.Config{Name: "comment-vote", UserRate: ratelimit.PerMinute(30), IPRate: ratelimit.PerMinute(120)}
+	ReportRateLimit = ratelimit.Config{Name: "comment-report", UserRate: ratelimit.PerMinute(10), IPRate: ratelimit.PerMinute(30)}
+)
+
+// ReconcileInterval is how often the comment counts of all proposals are
+// recomputed from the stored comments. 0, the default, takes the interval
+// from COMMENT_COUNT_RECONCILE_INTERVAL; with neither set there is no
+// schedule, the admin endpoint and the consistency command keep working.
+var ReconcileInterval time.Duration
+
 func Initialize(e *echo.Echo, db *gorm.DB, session *gocql.Session, casbinMdw echo.MiddlewareFunc, apiKeyMdw echo.MiddlewareFunc) {
 	tokenSessionRepository := tokenSessionsRepository.NewTokenSessionRepository(db)
+	moderator := proposalController.ModeratorCheck(e, casbinMdw)
 	proposalController := proposalController.NewProposalController(tokenSessionRepository, session)
+	proposalController.Moderator = moderator
 	commentsController := controller.NewCommentsController(proposalController)
+	// users who never posted are not in users_by_username yet
+	mentionsRepository.SetUserLookup(mentionsRepository.TokenSessionUserLookup(tokenSessionRepository))
+
+	if e.Validator == nil {
+		e.Validator = validation.New()
+	}
+	if e.IPExtractor == nil {
+		extractor, err := ratelimit.IPExtractorFromEnv()
+		if err != nil {
+			// refuse to start rather than limit by spoofable addresses
+			panic("rate limit: " + err.Error())
+		}
+		e.IPExtractor = extractor
+	}
+
+	// the limits run after casbinMdw, so users are told apart by the user id
+	// of their token
+	createLimit := ratelimit.New(CreateRateLimit.WithUserKey(proposalController.RateLimitUser))
+	updateLimit := ratelimit.New(UpdateRateLimit.WithUserKey(proposalController.RateLimitUser))
+	voteLimit := ratelimit.New(VoteRateLimit.WithUserKey(proposalController.RateLimitUser))
+	reportLimit := ratelimit.New(ReportRateLimit.WithUserKey(proposalController.RateLimitUser))
 
 	comment := e.Group("api/v1/user/proposal/comment")
-	comment.POST("/create", commentsController.WriteComment, casbinMdw)
+	comment.POST("/create", commentsController.WriteComment, casbinMdw, createLimit)
 	comment.GET("/getAll/:proposal-id", commentsController.GetCommentsByProposalID, apiKeyMdw)
 	comment.GET("/get", commentsController.GetCommentByIDAndProposalID, apiKeyMdw)
-	comment.PUT("/update", commentsController.UpdateComment, casbinMdw)
+	comment.GET("/get/:proposal-id/:comment-id", commentsController.GetCommentByIDAndProposalID, apiKeyMdw)
+	comment.PUT("/update", commentsController.UpdateComment, casbinMdw, updateLimit)
+	comment.PATCH("/update/:proposal-id/:comment-id", commentsController.PatchComment, casbinMdw, updateLimit)
 	comment.DELETE("/delete", commentsController.DeleteComment, casbinMdw)
 	comment.DELETE("/delete/:proposal-id", commentsController.DeleteAllProposalComments, casbinMdw)
-	comment.PUT("/upvote", commentsController.UpvoteComment, casbinMdw)
+	comment.PUT("/upvote", commentsController.UpvoteComment, casbinMdw, voteLimit)
+	comment.POST("/bulk/create", commentsController.BulkCreateComments, casbinMdw)
+	comment.POST("/bulk/delete", commentsController.BulkDeleteComments, casbinMdw)
+	comment.POST("/reconcile", commentsController.ReconcileCommentCounts, casbinMdw)
+	comment.POST("/report", commentsController.ReportComment, casbinMdw, reportLimit)
+	comment.GET("/mentions", commentsController.GetMentions, casbinMdw)
+
+	interval := ReconcileInterval
+	if interval == 0 {
+		var err error
+		if interval, err = consistency.ReconcileIntervalFromEnv(); err != nil {
+			panic("comment count reconciliation: " + err.Error())
+		}
+	}
+	if interval > 0 {
+		stop := make(chan struct{})
+		e.Server.RegisterOnShutdown(func() { close(stop) })
+
+		go consistency.ScheduleCommentCounts(session, interval, stop, func(report consistency.CountReport, err error) {
+			if err != nil {
+				e.Logger.Errorf("reconciling comment counts: %v", err)
+				return
+			}
+			if len(report.Corrected) > 0 {
+				e.Logger.Warnf("corrected the comment counts of %d of %d proposals", len(report.Corrected), report.Checked)
+			}
+		})
+	}
 }
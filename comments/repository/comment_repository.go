@@ -1,51 +1,304 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/google/uuid"
+	auditRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/audit/repository"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/events"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/markup"
+	mentionsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/mentions/repository"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
 )
 
-func StoreComment(session *gocql.Session, proposalID uuid.UUID, comment string, userID uuid.UUID, username string) error {
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	// ErrCommentConflict means the comment was changed after the caller read it.
+	ErrCommentConflict = errors.New("comment was modified by another request")
+)
+
+// audit records a change of a comment in the audit log, or of all comments of
+// the proposal if commentID is uuid.Nil, and publishes it. The change is
+// stored by then, so a failed audit write does not fail it.
+func audit(session *gocql.Session, actor entity.Actor, action string, proposalID, commentID uuid.UUID, before, after interface{}) {
+	_ = auditRepository.Log(session, actor, action, entity.AuditTargetComment, proposalID, commentID, before, after)
+	events.Publish(events.Event{Action: action, TargetType: entity.AuditTargetComment, ProposalID: proposalID, CommentID: commentID, Actor: actor, Before: before, After: after})
+}
+
+// MaxMentions caps the users a comment can mention. Mentions beyond it are
+// left unresolved.
+const MaxMentions = 20
+
+// resolveMentions returns the ids of the users mentioned in text, leaving out
+// the author.
+func resolveMentions(session *gocql.Session, text string, authorID uuid.UUID) ([]uuid.UUID, error) {
+	usernames := markup.Mentions(text)
+	if len(usernames) > MaxMentions {
+		usernames = usernames[:MaxMentions]
+	}
+
+	ids, err := mentionsRepository.ResolveUsernames(session, usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	mentioned := ids[:0]
+	for _, id := range ids {
+		if id != authorID {
+			mentioned = append(mentioned, id)
+		}
+	}
+
+	return mentioned, nil
+}
+
+// indexMentions adds the comment to the mention index of the users in added
+// and removes it from that of the users in removed. The comment is stored by
+// then, a lost index entry only misses from the user's mentions.
+func indexMentions(session *gocql.Session, comment entity.Comment, added, removed []uuid.UUID) {
+	for _, id := range added {
+		_ = mentionsRepository.AddMention(session, id, comment)
+	}
+	for _, id := range removed {
+		_ = mentionsRepository.RemoveMention(session, id, comment)
+	}
+}
+
+// without returns the ids of a that are not in b.
+func without(a, b []uuid.UUID) []uuid.UUID {
+	var diff []uuid.UUID
+	for _, id := range a {
+		found := false
+		for _, other := range b {
+			if id == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, id)
+		}
+	}
+	return diff
+}
+
+func toUUIDs(ids []gocql.UUID) []uuid.UUID {
+	converted := make([]uuid.UUID, len(ids))
+	for i, id := range ids {
+		converted[i] = uuid.UUID(id)
+	}
+	return converted
+}
+
+func fromUUIDs(ids []uuid.UUID) []gocql.UUID {
+	converted := make([]gocql.UUID, len(ids))
+	for i, id := range ids {
+		converted[i] = gocql.UUID(id)
+	}
+	return converted
+}
+
+func StoreComment(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, comment string, userID uuid.UUID, username string, hidden bool) (entity.Comment, error) {
+	return StoreCommentWithID(session, actor, gocql.TimeUUID(), proposalID, comment, userID, username, hidden)
+}
+
+// StoreCommentWithID stores a comment under an id generated by the caller and
+// returns it. The comment's created_at is taken from the timeuuid. A comment
+// held for moderation is stored hidden.
+func StoreCommentWithID(session *gocql.Session, actor entity.Actor, commentID gocql.UUID, proposalID uuid.UUID, comment string, userID uuid.UUID, username string, hidden bool) (entity.Comment, error) {
 	uID := gocql.UUID(userID)
 	if uID == gocql.UUID(uuid.Nil) {
-		return fmt.Errorf("something went wrong")
+		return entity.Comment{}, fmt.Errorf("something went wrong")
 	}
 
+	comment = markup.Sanitize(comment)
 	if comment == "" {
-		return fmt.Errorf("invalid request")
+		return entity.Comment{}, fmt.Errorf("invalid request")
 	}
+	commentHTML, commentPlain := markup.Render(comment)
 
-	time := time.Now()
+	mentions, err := resolveMentions(session, comment, userID)
+	if err != nil {
+		return entity.Comment{}, err
+	}
+
+	// Cassandra stores timestamps with millisecond precision
+	time := commentID.Time().Truncate(time.Millisecond)
 	proposal, err := repository.GetProposalByProposalID(session, proposalID)
 	if err != nil {
-		return err
+		return entity.Comment{}, err
+	}
+	if len(proposal) == 0 {
+		return entity.Comment{}, repository.ErrProposalNotFound
 	}
 
-	commentID := gocql.UUIDFromTime(time)
+	err = session.Query(`INSERT INTO comments_by_proposal_id(proposal_id, id, comment, comment_html, comment_plain, mentions, user_posted_id, user_posted_username, 
+		user_commented_id, user_commented_username, created_at, last_updated, upvotes, hidden) VALUES 
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?);`, gocql.UUID(proposalID), commentID, comment, commentHTML, commentPlain, fromUUIDs(mentions), gocql.UUID(proposal[0].UserID),
+		proposal[0].Username, gocql.UUID(userID), username, time, time, hidden).Exec()
 
-	err = session.Query(`INSERT INTO comments_by_proposal_id(proposal_id, id, comment, user_posted_id, user_posted_username, 
-		user_commented_id, user_commented_username, created_at, last_updated, upvotes) VALUES 
-		(?, ?, ?, ?, ?, ?, ?, ?, ?, 0);`, gocql.UUID(proposalID), commentID, comment, gocql.UUID(proposal[0].UserID),
-		proposal[0].Username, gocql.UUID(userID), username, time, time).Exec()
+	if err != nil {
+		return entity.Comment{}, err
+	}
+
+	err = session.Query(`INSERT INTO comments_by_proposal_and_comment_id(proposal_id, id, comment, comment_html, comment_plain, mentions, user_posted_id, user_posted_username, 
+		user_commented_id, user_commented_username, created_at, last_updated, upvotes, hidden) VALUES 
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?);`, gocql.UUID(proposalID), commentID, comment, commentHTML, commentPlain, fromUUIDs(mentions), gocql.UUID(proposal[0].UserID),
+		proposal[0].Username, gocql.UUID(userID), username, time, time, hidden).Exec()
 
 	if err != nil {
-		return err
+		return entity.Comment{}, err
 	}
 
-	err = session.Query(`INSERT INTO comments_by_proposal_and_comment_id(proposal_id, id, comment, user_posted_id, user_posted_username, 
-		user_commented_id, user_commented_username, created_at, last_updated, upvotes) VALUES 
-		(?, ?, ?, ?, ?, ?, ?, ?, ?, 0);`, gocql.UUID(proposalID), commentID, comment, gocql.UUID(proposal[0].UserID),
-		proposal[0].Username, gocql.UUID(userID), username, time, time).Exec()
+	stored := entity.Comment{
+		ProposalID:            proposalID,
+		CommentID:             uuid.UUID(commentID),
+		CommentText:           comment,
+		CommentHTML:           commentHTML,
+		UserPostedProposalID:  proposal[0].UserID,
+		UserPostedUsername:    proposal[0].Username,
+		UserCommentedID:       userID,
+		UserCommentedUsername: username,
+		Hidden:                hidden,
+		CreatedAt:             time,
+		LastUpdated:           time,
+		Mentions:              mentions,
+		CommentPlain:          commentPlain,
+	}
+	audit(session, actor, entity.AuditCreate, proposalID, stored.CommentID, nil, stored)
+	indexMentions(session, stored, mentions, nil)
+	// the author can be mentioned from now on
+	_ = mentionsRepository.RememberUser(session, userID, username)
+
+	return stored, nil
+}
+
+// CommentTables are the denormalized copies of every comment. Both have
+// proposal_id, created_at and id in their primary key.
+// comments_by_proposal_and_comment_id is the copy the other is repaired from.
+var CommentTables = []string{"comments_by_proposal_and_comment_id", "comments_by_proposal_id"}
+
+// InsertComment writes a complete comment, votes and timestamps included, to
+// both comment tables. Importing existing data uses it, new comments go
+// through StoreComment. The proposal's comment count is left to the caller.
+func InsertComment(session *gocql.Session, actor entity.Actor, comment entity.Comment) error {
+	for _, table := range CommentTables {
+		if err := WriteCommentRow(session, table, comment); err != nil {
+			return err
+		}
+	}
+	audit(session, actor, entity.AuditImport, comment.ProposalID, comment.CommentID, nil, comment)
+	indexMentions(session, comment, comment.Mentions, nil)
+	_ = mentionsRepository.RememberUser(session, comment.UserCommentedID, comment.UserCommentedUsername)
+
+	return nil
+}
+
+// The row functions below repair and remove single copies for the
+// consistency checker. They are not audited.
+
+// WriteCommentRow writes a complete comment to one of CommentTables.
+func WriteCommentRow(session *gocql.Session, table string, comment entity.Comment) error {
+	// imported and restored comments carry only their source
+	if comment.CommentHTML == "" || comment.CommentPlain == "" {
+		comment.CommentHTML, comment.CommentPlain = markup.Render(comment.CommentText)
+	}
 
-	return err
+	return session.Query(`INSERT INTO `+table+`(proposal_id, id, comment, comment_html, comment_plain, mentions, user_posted_id, user_posted_username, 
+		user_commented_id, user_commented_username, created_at, last_updated, upvotes, hidden) VALUES 
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, gocql.UUID(comment.ProposalID), gocql.UUID(comment.CommentID), comment.CommentText,
+		comment.CommentHTML, comment.CommentPlain, fromUUIDs(comment.Mentions), gocql.UUID(comment.UserPostedProposalID), comment.UserPostedUsername, gocql.UUID(comment.UserCommentedID),
+		comment.UserCommentedUsername, comment.CreatedAt, comment.LastUpdated, comment.UpVotes, comment.Hidden).Exec()
+}
+
+// GetCommentRow reads the row of comment from one of CommentTables by its
+// primary key. It returns nil if the table has no such row.
+func GetCommentRow(session *gocql.Session, table string, comment entity.Comment) (*entity.Comment, error) {
+	var row *entity.Comment
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM `+table+`
+							WHERE proposal_id=? AND created_at=? AND id=?;`,
+		gocql.UUID(comment.ProposalID), comment.CreatedAt, gocql.UUID(comment.CommentID)).Iter()
+
+	for iter.MapScan(m) {
+		scanned := commentFromMap(m)
+		row = &scanned
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
 
+	return row, err
 }
 
+// DeleteCommentRow removes the row of comment from one of CommentTables only.
+func DeleteCommentRow(session *gocql.Session, table string, comment entity.Comment) error {
+	return session.Query(`DELETE FROM `+table+`
+							WHERE proposal_id=? AND created_at=? AND id=?;`,
+		gocql.UUID(comment.ProposalID), comment.CreatedAt, gocql.UUID(comment.CommentID)).Exec()
+}
+
+// IterateCommentRows calls fn for every row of one of CommentTables, in
+// token order. It stops at the first error fn returns.
+func IterateCommentRows(session *gocql.Session, table string, fn func(entity.Comment) error) error {
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM ` + table + `;`).Iter()
+
+	for iter.MapScan(m) {
+		if err := fn(commentFromMap(m)); err != nil {
+			iter.Close()
+			return err
+		}
+		m = map[string]interface{}{}
+	}
+
+	return iter.Close()
+}
+
+// BackfillCommentMarkup stores the HTML and plain renderings of the rows of
+// CommentTables written before they were stored, and returns how many rows
+// it updated. It only writes the two renderings, so it can run while the
+// comments are in use.
+func BackfillCommentMarkup(session *gocql.Session) (int, error) {
+	updated := 0
+
+	for _, table := range CommentTables {
+		err := IterateCommentRows(session, table, func(comment entity.Comment) error {
+			if comment.CommentHTML != "" && comment.CommentPlain != "" {
+				return nil
+			}
+
+			commentHTML, commentPlain := markup.Render(comment.CommentText)
+			// IF EXISTS keeps a row deleted meanwhile from coming back
+			applied, err := session.Query(`UPDATE `+table+` SET comment_html=?, comment_plain=?
+							WHERE proposal_id=? AND created_at=? AND id=?
+							IF EXISTS`, commentHTML, commentPlain,
+				gocql.UUID(comment.ProposalID), comment.CreatedAt, gocql.UUID(comment.CommentID)).MapScanCAS(map[string]interface{}{})
+			if err != nil {
+				return err
+			}
+
+			if applied {
+				updated++
+			}
+			return nil
+		})
+		if err != nil {
+			return updated, err
+		}
+	}
+
+	return updated, nil
+}
+
+// GetCommentsByProposalID returns the comments of the proposal, newest first,
+// without those hidden by a moderator.
 func GetCommentsByProposalID(session *gocql.Session, proposalID uuid.UUID) ([]entity.Comment, error) {
 	var comments []entity.Comment
 
@@ -56,18 +309,9 @@ func GetCommentsByProposalID(session *gocql.Session, proposalID uuid.UUID) ([]en
 							ORDER BY created_at DESC;`, gocql.UUID(proposalID)).Iter()
 
 	for iter.MapScan(m) {
-		comments = append(comments, entity.Comment{
-			ProposalID:            uuid.UUID(m["proposal_id"].(gocql.UUID)),
-			CommentID:             uuid.UUID(m["id"].(gocql.UUID)),
-			CommentText:           m["comment"].(string),
-			UserPostedProposalID:  uuid.UUID(m["user_posted_id"].(gocql.UUID)),
-			UserPostedUsername:    m["user_posted_username"].(string),
-			UserCommentedID:       uuid.UUID(m["user_commented_id"].(gocql.UUID)),
-			UserCommentedUsername: m["user_commented_username"].(string),
-			UpVotes:               m["upvotes"].(int),
-			CreatedAt:             m["created_at"].(time.Time),
-			LastUpdated:           m["last_updated"].(time.Time),
-		})
+		if comment := commentFromMap(m); !comment.Hidden {
+			comments = append(comments, comment)
+		}
 		m = map[string]interface{}{}
 	}
 
@@ -76,6 +320,37 @@ func GetCommentsByProposalID(session *gocql.Session, proposalID uuid.UUID) ([]en
 	return comments, err
 }
 
+// CountCommentsByProposalID counts the comment rows of the proposal.
+func CountCommentsByProposalID(session *gocql.Session, proposalID uuid.UUID) (int, error) {
+	var count int64
+
+	err := session.Query(`SELECT COUNT(*) FROM comments_by_proposal_id
+							WHERE proposal_id=?;`, gocql.UUID(proposalID)).Scan(&count)
+
+	return int(count), err
+}
+
+// IterateCommentsByProposalID calls fn for every comment of the proposal,
+// newest first, reading the rows page by page. It stops at the first error
+// fn returns.
+func IterateCommentsByProposalID(session *gocql.Session, proposalID uuid.UUID, fn func(entity.Comment) error) error {
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM comments_by_proposal_id
+							WHERE proposal_id=?
+							ORDER BY created_at DESC;`, gocql.UUID(proposalID)).Iter()
+
+	for iter.MapScan(m) {
+		if err := fn(commentFromMap(m)); err != nil {
+			iter.Close()
+			return err
+		}
+		m = map[string]interface{}{}
+	}
+
+	return iter.Close()
+}
+
 func GetCommentByIDAndProposalID(session *gocql.Session, proposalID uuid.UUID, commentID uuid.UUID) (*entity.Comment, error) {
 	var comment *entity.Comment
 
@@ -85,18 +360,8 @@ func GetCommentByIDAndProposalID(session *gocql.Session, proposalID uuid.UUID, c
 							WHERE proposal_id=? AND id=? LIMIT 1;`, gocql.UUID(proposalID), gocql.UUID(commentID)).Iter()
 
 	for iter.MapScan(m) {
-		comment = &entity.Comment{
-			ProposalID:            uuid.UUID(m["proposal_id"].(gocql.UUID)),
-			CommentID:             uuid.UUID(m["id"].(gocql.UUID)),
-			CommentText:           m["comment"].(string),
-			UserPostedProposalID:  uuid.UUID(m["user_posted_id"].(gocql.UUID)),
-			UserPostedUsername:    m["user_posted_username"].(string),
-			UserCommentedID:       uuid.UUID(m["user_commented_id"].(gocql.UUID)),
-			UserCommentedUsername: m["user_commented_username"].(string),
-			UpVotes:               m["upvotes"].(int),
-			CreatedAt:             m["created_at"].(time.Time),
-			LastUpdated:           m["last_updated"].(time.Time),
-		}
+		scanned := commentFromMap(m)
+		comment = &scanned
 	}
 
 	err := iter.Close()
@@ -104,32 +369,80 @@ func GetCommentByIDAndProposalID(session *gocql.Session, proposalID uuid.UUID, c
 	return comment, err
 }
 
-func UpdateCommentByID(session *gocql.Session, proposalID uuid.UUID, commentID uuid.UUID, updatedComment string) error {
+// UpdateCommentByID replaces the comment text if the comment's last_updated
+// still equals expectedLastUpdated, and returns the updated comment. On
+// ErrCommentConflict the current version is returned instead. With hold set
+// the edit was held for moderation and the comment is hidden in the same
+// write.
+func UpdateCommentByID(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, commentID uuid.UUID, updatedComment string, expectedLastUpdated time.Time, hold bool) (entity.Comment, error) {
 	comment, err := GetCommentByIDAndProposalID(session, proposalID, commentID)
 	if err != nil {
-		return err
+		return entity.Comment{}, err
+	}
+	if comment == nil {
+		return entity.Comment{}, ErrCommentNotFound
 	}
 
-	updateTime := time.Now()
+	// Cassandra stores timestamps with millisecond precision
+	updateTime := time.Now().Truncate(time.Millisecond)
 
-	err = session.Query(`UPDATE comments_by_proposal_id SET comment=?, last_updated=?
-							WHERE proposal_id=? AND id=? AND created_at=?;`, updatedComment, updateTime, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
+	updatedComment = markup.Sanitize(updatedComment)
+	updatedHTML, updatedPlain := markup.Render(updatedComment)
 
+	mentions, err := resolveMentions(session, updatedComment, comment.UserCommentedID)
 	if err != nil {
-		return err
+		return entity.Comment{}, err
 	}
 
-	err = session.Query(`UPDATE comments_by_proposal_and_comment_id SET comment=?, last_updated=?
-							WHERE proposal_id=? AND id=? AND created_at=?;`, updatedComment, updateTime, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
+	hidden := comment.Hidden || hold
 
-	return err
+	applied, err := session.Query(`UPDATE comments_by_proposal_and_comment_id SET comment=?, comment_html=?, comment_plain=?, mentions=?, last_updated=?, hidden=?
+							WHERE proposal_id=? AND id=? AND created_at=?
+							IF last_updated=?;`, updatedComment, updatedHTML, updatedPlain, fromUUIDs(mentions), updateTime, hidden, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt, expectedLastUpdated).MapScanCAS(map[string]interface{}{})
+
+	if err != nil {
+		return entity.Comment{}, err
+	}
+
+	if !applied {
+		current, err := GetCommentByIDAndProposalID(session, proposalID, commentID)
+		if err != nil {
+			return entity.Comment{}, err
+		}
+		if current == nil {
+			return entity.Comment{}, ErrCommentNotFound
+		}
+		return *current, ErrCommentConflict
+	}
+
+	err = session.Query(`UPDATE comments_by_proposal_id SET comment=?, comment_html=?, comment_plain=?, mentions=?, last_updated=?, hidden=?
+							WHERE proposal_id=? AND id=? AND created_at=?;`, updatedComment, updatedHTML, updatedPlain, fromUUIDs(mentions), updateTime, hidden, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
+
+	if err != nil {
+		return entity.Comment{}, err
+	}
+
+	updated := *comment
+	updated.CommentText = updatedComment
+	updated.CommentHTML = updatedHTML
+	updated.CommentPlain = updatedPlain
+	updated.Mentions = mentions
+	updated.Hidden = hidden
+	updated.LastUpdated = updateTime
+	audit(session, actor, entity.AuditUpdate, proposalID, commentID, *comment, updated)
+	indexMentions(session, updated, without(mentions, comment.Mentions), without(comment.Mentions, mentions))
+
+	return updated, nil
 }
 
-func DeleteCommentByID(session *gocql.Session, proposalID uuid.UUID, commentID uuid.UUID) error {
+func DeleteCommentByID(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, commentID uuid.UUID) error {
 	comment, err := GetCommentByIDAndProposalID(session, proposalID, commentID)
 	if err != nil {
 		return err
 	}
+	if comment == nil {
+		return ErrCommentNotFound
+	}
 
 	err = session.Query(`DELETE FROM comments_by_proposal_id
 							WHERE proposal_id=? AND id=? AND created_at=?`, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
@@ -141,11 +454,30 @@ func DeleteCommentByID(session *gocql.Session, proposalID uuid.UUID, commentID u
 	err = session.Query(`DELETE FROM comments_by_proposal_and_comment_id
 							WHERE proposal_id=? AND id=? AND created_at=?`, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
 
-	return err
+	if err != nil {
+		return err
+	}
+	audit(session, actor, entity.AuditDelete, proposalID, commentID, *comment, nil)
+	indexMentions(session, *comment, nil, comment.Mentions)
+
+	return nil
 }
 
-func DeleteAllProposalComments(session *gocql.Session, proposalID uuid.UUID) error {
-	err := session.Query(`DELETE FROM comments_by_proposal_id
+func DeleteAllProposalComments(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID) error {
+	// the mentions go first, once the comments are deleted nothing lists them
+	err := IterateCommentsByProposalID(session, proposalID, func(comment entity.Comment) error {
+		for _, id := range comment.Mentions {
+			if err := mentionsRepository.RemoveMention(session, id, comment); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = session.Query(`DELETE FROM comments_by_proposal_id
 							WHERE proposal_id=?`, gocql.UUID(proposalID)).Exec()
 
 	if err != nil {
@@ -155,10 +487,15 @@ func DeleteAllProposalComments(session *gocql.Session, proposalID uuid.UUID) err
 	err = session.Query(`DELETE FROM comments_by_proposal_and_comment_id
 							WHERE proposal_id=?`, gocql.UUID(proposalID)).Exec()
 
-	return err
+	if err != nil {
+		return err
+	}
+	audit(session, actor, entity.AuditDelete, proposalID, uuid.Nil, nil, nil)
+
+	return nil
 }
 
-func DeleteAllComments(session *gocql.Session) error {
+func DeleteAllComments(session *gocql.Session, actor entity.Actor) error {
 	err := session.Query(`TRUNCATE TABLE user_proposals_and_comments.comments_by_proposal_id`).Exec()
 
 	if err != nil {
@@ -167,14 +504,28 @@ func DeleteAllComments(session *gocql.Session) error {
 
 	err = session.Query(`TRUNCATE TABLE user_proposals_and_comments.comments_by_proposal_and_comment_id`).Exec()
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	err = mentionsRepository.DeleteAllMentions(session)
+
+	if err != nil {
+		return err
+	}
+	audit(session, actor, entity.AuditDeleteAll, uuid.Nil, uuid.Nil, nil, nil)
+
+	return nil
 }
 
-func UpvoteComment(session *gocql.Session, proposalID uuid.UUID, commentID uuid.UUID) error {
+func UpvoteComment(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, commentID uuid.UUID) error {
 	comment, err := GetCommentByIDAndProposalID(session, proposalID, commentID)
 	if err != nil {
 		return err
 	}
+	if comment == nil {
+		return ErrCommentNotFound
+	}
 
 	err = session.Query(`UPDATE comments_by_proposal_id SET upvotes=?
 							WHERE proposal_id=? AND id=? AND created_at=?;`, comment.UpVotes+1, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
@@ -186,5 +537,76 @@ func UpvoteComment(session *gocql.Session, proposalID uuid.UUID, commentID uuid.
 	err = session.Query(`UPDATE comments_by_proposal_and_comment_id SET upvotes=?
 							WHERE proposal_id=? AND id=? AND created_at=?;`, comment.UpVotes+1, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	voted := *comment
+	voted.UpVotes++
+	audit(session, actor, entity.AuditUpvote, proposalID, commentID, *comment, voted)
+
+	return nil
+}
+
+// SetCommentHidden hides the comment from the listings or shows it again, and
+// returns it. The proposal's comment count keeps counting hidden comments. A
+// comment already in that state is left alone.
+func SetCommentHidden(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, commentID uuid.UUID, hidden bool) (entity.Comment, error) {
+	comment, err := GetCommentByIDAndProposalID(session, proposalID, commentID)
+	if err != nil {
+		return entity.Comment{}, err
+	}
+	if comment == nil {
+		return entity.Comment{}, ErrCommentNotFound
+	}
+	if comment.Hidden == hidden {
+		return *comment, nil
+	}
+
+	for _, table := range CommentTables {
+		err = session.Query(`UPDATE `+table+` SET hidden=?
+							WHERE proposal_id=? AND id=? AND created_at=?;`, hidden, gocql.UUID(proposalID), gocql.UUID(commentID), comment.CreatedAt).Exec()
+
+		if err != nil {
+			return entity.Comment{}, err
+		}
+	}
+
+	updated := *comment
+	updated.Hidden = hidden
+
+	action := entity.AuditHide
+	if !hidden {
+		action = entity.AuditUnhide
+	}
+	audit(session, actor, action, proposalID, commentID, *comment, updated)
+
+	return updated, nil
+}
+
+// commentFromMap converts a row scanned from one of the comment tables.
+func commentFromMap(m map[string]interface{}) entity.Comment {
+	hidden, _ := m["hidden"].(bool)
+	// empty on rows written before the renderings were stored, until
+	// BackfillCommentMarkup reached them
+	textHTML, _ := m["comment_html"].(string)
+	textPlain, _ := m["comment_plain"].(string)
+	mentions, _ := m["mentions"].([]gocql.UUID)
+
+	return entity.Comment{
+		ProposalID:            uuid.UUID(m["proposal_id"].(gocql.UUID)),
+		CommentID:             uuid.UUID(m["id"].(gocql.UUID)),
+		CommentText:           m["comment"].(string),
+		CommentHTML:           textHTML,
+		UserPostedProposalID:  uuid.UUID(m["user_posted_id"].(gocql.UUID)),
+		UserPostedUsername:    m["user_posted_username"].(string),
+		UserCommentedID:       uuid.UUID(m["user_commented_id"].(gocql.UUID)),
+		UserCommentedUsername: m["user_commented_username"].(string),
+		UpVotes:               m["upvotes"].(int),
+		Hidden:                hidden,
+		CreatedAt:             m["created_at"].(time.Time),
+		LastUpdated:           m["last_updated"].(time.Time),
+		Mentions:              toUUIDs(mentions),
+		CommentPlain:          textPlain,
+	}
 }
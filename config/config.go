@@ -4,10 +4,19 @@ import (
 	"github.com/gocql/gocql"
 )
 
+// Keyspace holds every proposal and comment table.
+const Keyspace = "user_proposals_and_comments"
+
 func InitializeCassandraDB(cassandraHost string) (*gocql.Session, error) {
+	return InitializeCassandraKeyspace(cassandraHost, Keyspace)
+}
+
+// InitializeCassandraKeyspace connects to another keyspace than Keyspace,
+// e.g. to restore a backup next to the live data. The keyspace must exist.
+func InitializeCassandraKeyspace(cassandraHost, keyspace string) (*gocql.Session, error) {
 	var err error
 	cluster := gocql.NewCluster(cassandraHost)
-	cluster.Keyspace = "user_proposals_and_comments"
+	cluster.Keyspace = keyspace
 	session, err := cluster.CreateSession()
 	if err != nil {
 		return nil, err
@@ -22,7 +31,47 @@ func SchemaMigration(session *gocql.Session) error {
 		return err
 	}
 
-	err := CreateCommentsTable(session)
+	err = CreateCommentsTable(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateIdempotencyTable(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateSafeguardTables(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateAuditTables(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateModerationTables(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateContentFilterTable(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateMentionTables(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateNotificationTables(session)
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	err = CreateWebhookTables(session)
 	if err != nil && err != gocql.ErrTimeoutNoResponse {
 		return err
 	}
@@ -34,9 +83,9 @@ func CreateProposalTables(session *gocql.Session) error {
 
 	// Create Proposal Table By ID
 	err := session.Query(`CREATE TABLE IF NOT EXISTS proposals_by_id(
-			id timeuuid, title text, proposal_text text, user_id uuid, username text,
+			id timeuuid, title text, proposal_text text, proposal_html text, proposal_plain text, user_id uuid, username text,
 			firstname text, lastname text, upvotes int, downvotes int, no_of_comments int,
-			created_at timestamp, last_updated timestamp,
+			status text, hidden boolean, created_at timestamp, last_updated timestamp,
 			PRIMARY KEY (id, created_at, user_id, username)
 			); `).Exec()
 
@@ -46,9 +95,9 @@ func CreateProposalTables(session *gocql.Session) error {
 
 	// Create Proposal Table By UserID
 	err = session.Query(`CREATE TABLE IF NOT EXISTS proposals_by_user_id(
-		id timeuuid, title text, proposal_text text, user_id uuid, username text,
+		id timeuuid, title text, proposal_text text, proposal_html text, proposal_plain text, user_id uuid, username text,
 		firstname text, lastname text, upvotes int, downvotes int, no_of_comments int,
-		created_at timestamp, last_updated timestamp,
+		status text, hidden boolean, created_at timestamp, last_updated timestamp,
 		PRIMARY KEY (user_id, created_at, id, username)
 		); `).Exec()
 
@@ -58,21 +107,48 @@ func CreateProposalTables(session *gocql.Session) error {
 
 	// Create Proposal Table By time created
 	err = session.Query(`CREATE TABLE IF NOT EXISTS proposals_by_created_at(
-		id timeuuid, title text, proposal_text text, user_id uuid, username text,
+		id timeuuid, title text, proposal_text text, proposal_html text, proposal_plain text, user_id uuid, username text,
 		firstname text, lastname text, upvotes int, downvotes int, no_of_comments int,
-		created_at timestamp, last_updated timestamp,
+		status text, hidden boolean, created_at timestamp, last_updated timestamp,
 		PRIMARY KEY (created_at, id, user_id, username)
 		); `).Exec()
 
-	return err
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Add columns introduced after the tables were first created
+	for _, table := range []string{"proposals_by_id", "proposals_by_user_id", "proposals_by_created_at"} {
+		err = AddColumnIfMissing(session, table, "status", "text")
+		if err != nil {
+			return err
+		}
+
+		err = AddColumnIfMissing(session, table, "hidden", "boolean")
+		if err != nil {
+			return err
+		}
+
+		err = AddColumnIfMissing(session, table, "proposal_html", "text")
+		if err != nil {
+			return err
+		}
+
+		err = AddColumnIfMissing(session, table, "proposal_plain", "text")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func CreateCommentsTable(session *gocql.Session) error {
 
 	// Create Comment Table
 	err := session.Query(`CREATE TABLE IF NOT EXISTS comments_by_proposal_id(
-			proposal_id uuid, id timeuuid, comment text, user_posted_id uuid, user_posted_username text,
-			user_commented_id uuid, user_commented_username text, upvotes int,
+			proposal_id uuid, id timeuuid, comment text, comment_html text, comment_plain text, user_posted_id uuid, user_posted_username text,
+			user_commented_id uuid, user_commented_username text, upvotes int, hidden boolean, mentions set<uuid>,
 			created_at timestamp, last_updated timestamp,
 			PRIMARY KEY (proposal_id, created_at, id)
 			); `).Exec()
@@ -82,11 +158,304 @@ func CreateCommentsTable(session *gocql.Session) error {
 	}
 
 	err = session.Query(`CREATE TABLE IF NOT EXISTS comments_by_proposal_and_comment_id(
-			proposal_id uuid, id timeuuid, comment text, user_posted_id uuid, user_posted_username text,
-			user_commented_id uuid, user_commented_username text, upvotes int,
+			proposal_id uuid, id timeuuid, comment text, comment_html text, comment_plain text, user_posted_id uuid, user_posted_username text,
+			user_commented_id uuid, user_commented_username text, upvotes int, hidden boolean, mentions set<uuid>,
 			created_at timestamp, last_updated timestamp,
 			PRIMARY KEY (proposal_id, id, created_at)
 			); `).Exec()
 
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Add columns introduced after the tables were first created
+	for _, table := range []string{"comments_by_proposal_id", "comments_by_proposal_and_comment_id"} {
+		err = AddColumnIfMissing(session, table, "hidden", "boolean")
+		if err != nil {
+			return err
+		}
+
+		err = AddColumnIfMissing(session, table, "comment_html", "text")
+		if err != nil {
+			return err
+		}
+
+		err = AddColumnIfMissing(session, table, "comment_plain", "text")
+		if err != nil {
+			return err
+		}
+
+		err = AddColumnIfMissing(session, table, "mentions", "set<uuid>")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func CreateIdempotencyTable(session *gocql.Session) error {
+
+	// Create Idempotency Key Table, entries expire after a day
+	err := session.Query(`CREATE TABLE IF NOT EXISTS idempotency_keys(
+			scope text, user_id uuid, key text, resource_id timeuuid, parent_id uuid,
+			fingerprint text, created_at timestamp,
+			PRIMARY KEY ((scope, user_id, key))
+			) WITH default_time_to_live = 86400; `).Exec()
+
+	return err
+}
+
+func CreateSafeguardTables(session *gocql.Session) error {
+
+	// Create Confirmation Token Table, tokens are inserted with a TTL
+	err := session.Query(`CREATE TABLE IF NOT EXISTS confirmation_tokens(
+			token text, operation text, user_id uuid, counts map<text, int>, created_at timestamp,
+			PRIMARY KEY (token)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Destructive Operation Table, newest first per operation
+	err = session.Query(`CREATE TABLE IF NOT EXISTS destructive_operations(
+			name text, id timeuuid, user_id uuid, username text, environment text,
+			counts map<text, int>, backup text, outcome text, error text,
+			started_at timestamp, finished_at timestamp,
+			PRIMARY KEY (name, id)
+			) WITH CLUSTERING ORDER BY (id DESC); `).Exec()
+
 	return err
 }
+
+func CreateAuditTables(session *gocql.Session) error {
+
+	// Create Audit Log Table By day, newest first
+	err := session.Query(`CREATE TABLE IF NOT EXISTS audit_log_by_day(
+			day date, id timeuuid, actor_id uuid, actor_username text, client_ip text,
+			action text, target_type text, target_id uuid, proposal_id uuid, comment_id uuid,
+			before text, after text,
+			PRIMARY KEY (day, id)
+			) WITH CLUSTERING ORDER BY (id DESC); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Audit Log Table By actor, one partition per actor and day
+	err = session.Query(`CREATE TABLE IF NOT EXISTS audit_log_by_actor(
+			day date, id timeuuid, actor_id uuid, actor_username text, client_ip text,
+			action text, target_type text, target_id uuid, proposal_id uuid, comment_id uuid,
+			before text, after text,
+			PRIMARY KEY ((actor_id, day), id)
+			) WITH CLUSTERING ORDER BY (id DESC); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Audit Log Table By target, the proposal or comment changed
+	err = session.Query(`CREATE TABLE IF NOT EXISTS audit_log_by_target(
+			day date, id timeuuid, actor_id uuid, actor_username text, client_ip text,
+			action text, target_type text, target_id uuid, proposal_id uuid, comment_id uuid,
+			before text, after text,
+			PRIMARY KEY (target_id, id)
+			) WITH CLUSTERING ORDER BY (id DESC); `).Exec()
+
+	return err
+}
+
+func CreateModerationTables(session *gocql.Session) error {
+
+	// Create Report Table, one report per user and reported proposal or comment
+	err := session.Query(`CREATE TABLE IF NOT EXISTS content_reports(
+			target_id uuid, reporter_id uuid, reporter_username text, target_type text,
+			proposal_id uuid, comment_id uuid, reason text, details text, created_at timestamp,
+			PRIMARY KEY (target_id, reporter_id)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Moderation Queue Table, most reported and most recently reported first
+	err = session.Query(`CREATE TABLE IF NOT EXISTS moderation_queue(
+			status text, report_count int, last_reported_at timestamp, target_id uuid,
+			target_type text, proposal_id uuid, comment_id uuid,
+			PRIMARY KEY (status, report_count, last_reported_at, target_id)
+			) WITH CLUSTERING ORDER BY (report_count DESC, last_reported_at DESC, target_id ASC); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Moderation Table By target, the current state of every reported target
+	err = session.Query(`CREATE TABLE IF NOT EXISTS moderation_by_target(
+			target_id uuid, target_type text, proposal_id uuid, comment_id uuid, author_id uuid,
+			status text, report_count int, reasons map<text, int>, last_reported_at timestamp,
+			action text, moderator_id uuid, moderator_username text, note text, resolved_at timestamp,
+			PRIMARY KEY (target_id)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create User Warning Table, newest first per user
+	err = session.Query(`CREATE TABLE IF NOT EXISTS user_warnings(
+			user_id uuid, id timeuuid, moderator_id uuid, moderator_username text, reason text,
+			target_type text, proposal_id uuid, comment_id uuid,
+			PRIMARY KEY (user_id, id)
+			) WITH CLUSTERING ORDER BY (id DESC); `).Exec()
+
+	return err
+}
+
+func CreateContentFilterTable(session *gocql.Session) error {
+
+	// Create Recent Post Table, fingerprints of each user's posts expire after a week
+	err := session.Query(`CREATE TABLE IF NOT EXISTS recent_posts_by_user(
+			user_id uuid, created_at timestamp, fingerprint text, kind text,
+			PRIMARY KEY (user_id, created_at, fingerprint)
+			) WITH CLUSTERING ORDER BY (created_at DESC, fingerprint ASC)
+			AND default_time_to_live = 604800; `).Exec()
+
+	return err
+}
+
+func CreateMentionTables(session *gocql.Session) error {
+
+	// Create User Table By Username, resolves mentions to user ids
+	err := session.Query(`CREATE TABLE IF NOT EXISTS users_by_username(
+			username_key text, username text, user_id uuid,
+			PRIMARY KEY (username_key)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Username Table By User, the name each user was last remembered
+	// under, so the old name is forgotten on a rename
+	err = session.Query(`CREATE TABLE IF NOT EXISTS usernames_by_user(
+			user_id uuid, username_key text,
+			PRIMARY KEY (user_id)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Mention Table, newest comments first per mentioned user
+	err = session.Query(`CREATE TABLE IF NOT EXISTS comments_by_mentioned_user(
+			user_id uuid, created_at timestamp, comment_id timeuuid, proposal_id uuid,
+			author_id uuid, author_username text,
+			PRIMARY KEY (user_id, created_at, comment_id)
+			) WITH CLUSTERING ORDER BY (created_at DESC, comment_id DESC); `).Exec()
+
+	return err
+}
+
+func CreateNotificationTables(session *gocql.Session) error {
+
+	// Create Follower Table By Proposal
+	err := session.Query(`CREATE TABLE IF NOT EXISTS proposal_followers(
+			proposal_id uuid, user_id uuid, reason text, following boolean, followed_at timestamp,
+			PRIMARY KEY (proposal_id, user_id)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Notification Table, newest first per user, notifications expire after 90 days
+	err = session.Query(`CREATE TABLE IF NOT EXISTS notifications_by_user(
+			user_id uuid, id timeuuid, type text, proposal_id uuid, proposal_title text, comment_id uuid,
+			actor_id uuid, actor_username text, message text, read boolean,
+			PRIMARY KEY (user_id, id)
+			) WITH CLUSTERING ORDER BY (id DESC)
+			AND default_time_to_live = 7776000; `).Exec()
+
+	return err
+}
+
+func CreateWebhookTables(session *gocql.Session) error {
+
+	// Create Webhook Table
+	err := session.Query(`CREATE TABLE IF NOT EXISTS webhooks(
+			id uuid, url text, events set<text>, secret text, active boolean, created_by uuid, created_at timestamp,
+			PRIMARY KEY (id)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Delivery Log Table, newest first per webhook, entries expire after 30 days
+	err = session.Query(`CREATE TABLE IF NOT EXISTS webhook_deliveries(
+			webhook_id uuid, id timeuuid, event_id uuid, event text, attempt int, status_code int, error text,
+			duration_ms int, succeeded boolean,
+			PRIMARY KEY (webhook_id, id)
+			) WITH CLUSTERING ORDER BY (id DESC)
+			AND default_time_to_live = 2592000; `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Dead Letter Table
+	err = session.Query(`CREATE TABLE IF NOT EXISTS webhook_dead_letters(
+			webhook_id uuid, event_id uuid, event text, payload text, attempts int, last_error text, failed_at timestamp,
+			PRIMARY KEY (webhook_id, event_id)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Pending Delivery Table, the deliveries not yet accepted or given
+	// up, partitioned by the minute they are due in
+	err = session.Query(`CREATE TABLE IF NOT EXISTS webhook_pending_by_due(
+			due_bucket timestamp, next_attempt_at timestamp, webhook_id uuid, event_id uuid,
+			event text, payload text, attempts int, last_error text, claimed_until timestamp,
+			PRIMARY KEY ((due_bucket), next_attempt_at, webhook_id, event_id)
+			); `).Exec()
+
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	// Create Webhook Cursor Table, the oldest due minute that may still
+	// hold pending deliveries
+	err = session.Query(`CREATE TABLE IF NOT EXISTS webhook_cursor(
+			name text, due_bucket timestamp,
+			PRIMARY KEY (name)
+			); `).Exec()
+
+	return err
+}
+
+// AddColumnIfMissing adds a column to an existing table. CREATE TABLE IF NOT
+// EXISTS leaves tables from earlier versions untouched, so new columns have
+// to be added separately. The column is probed with a query instead of
+// looked up in system_schema, so this works in whichever keyspace the session
+// uses.
+func AddColumnIfMissing(session *gocql.Session, table, column, columnType string) error {
+	err := session.Query(`SELECT ` + column + ` FROM ` + table + ` LIMIT 1;`).Exec()
+
+	if err == nil {
+		return nil
+	}
+	// selecting an undefined column is an invalid query
+	if requestErr, ok := err.(gocql.RequestError); !ok || requestErr.Code() != gocql.ErrCodeInvalid {
+		return err
+	}
+
+	err = session.Query(`ALTER TABLE ` + table + ` ADD ` + column + ` ` + columnType + `;`).Exec()
+	if err != nil && err != gocql.ErrTimeoutNoResponse {
+		return err
+	}
+
+	return nil
+}
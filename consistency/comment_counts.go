@@ -0,0 +1,135 @@
+package consistency
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+var ErrReconcileRunning = errors.New("a reconciliation of the comment counts is already running")
+
+// CountCorrection is a proposal whose stored comment count was wrong.
+type CountCorrection struct {
+	ProposalID string `json:"proposal_id"`
+	Stored     int    `json:"stored"`
+	Actual     int    `json:"actual"`
+}
+
+// CountReport is the outcome of reconciling comment counts.
+type CountReport struct {
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Checked    int               `json:"checked"`
+	Corrected  []CountCorrection `json:"corrected"`
+}
+
+// running guards against two full reconciliations at once, e.g. the
+// scheduled one and one started by an admin.
+var running int32
+
+// ReconcileCommentCount sets the no_of_comments of the proposal to the number
+// of its rows in comments_by_proposal_id. It returns the correction, or nil
+// if the count was right. A comment written between counting and correcting
+// is only counted by the next run.
+func ReconcileCommentCount(session *gocql.Session, proposalID uuid.UUID) (*CountCorrection, error) {
+	proposals, err := proposalRepository.GetLatestProposal(session, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if len(proposals) == 0 {
+		return nil, proposalRepository.ErrProposalNotFound
+	}
+
+	return reconcile(session, proposals[0])
+}
+
+func reconcile(session *gocql.Session, proposal entity.Proposal) (*CountCorrection, error) {
+	actual, err := repository.CountCommentsByProposalID(session, proposal.ID)
+	if err != nil {
+		return nil, err
+	}
+	if actual == proposal.NoOfComments {
+		return nil, nil
+	}
+
+	if err := proposalRepository.SetNumberOfComments(session, proposal.ID, actual); err != nil {
+		return nil, err
+	}
+
+	return &CountCorrection{ProposalID: proposal.ID.String(), Stored: proposal.NoOfComments, Actual: actual}, nil
+}
+
+// ReconcileCommentCounts reconciles the comment count of every proposal in
+// proposals_by_id. Only one reconciliation runs at a time, a second one
+// returns ErrReconcileRunning.
+func ReconcileCommentCounts(session *gocql.Session) (CountReport, error) {
+	report := CountReport{StartedAt: time.Now().UTC()}
+
+	if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+		return report, ErrReconcileRunning
+	}
+	defer atomic.StoreInt32(&running, 0)
+
+	err := proposalRepository.IterateProposalRows(session, proposalRepository.ProposalTables[0], func(proposal entity.Proposal) error {
+		report.Checked++
+
+		correction, err := reconcile(session, proposal)
+		if err != nil {
+			return err
+		}
+		if correction != nil {
+			report.Corrected = append(report.Corrected, *correction)
+		}
+		return nil
+	})
+
+	report.FinishedAt = time.Now().UTC()
+	return report, err
+}
+
+// ReconcileIntervalFromEnv reads how often the service reconciles the comment
+// counts from COMMENT_COUNT_RECONCILE_INTERVAL, e.g. 6h. Unset it is 0, the
+// schedule is off and the counts are left to the consistency command.
+func ReconcileIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("COMMENT_COUNT_RECONCILE_INTERVAL")
+	if value == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("COMMENT_COUNT_RECONCILE_INTERVAL: %q is not a duration", value)
+	}
+
+	return interval, nil
+}
+
+// ScheduleCommentCounts runs ReconcileCommentCounts right away and then every
+// interval until stop is closed, passing each outcome to done if it is not
+// nil. Every instance of the service runs its own schedule; the runs are
+// idempotent, so that only costs the extra reads.
+func ScheduleCommentCounts(session *gocql.Session, interval time.Duration, stop <-chan struct{}, done func(CountReport, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := ReconcileCommentCounts(session)
+		if done != nil {
+			done(report, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
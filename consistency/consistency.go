@@ -0,0 +1,280 @@
+package consistency
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+// Kinds of divergence between a copy and its source of truth.
+const (
+	// KindMissing is a row of the source the copy does not have.
+	KindMissing = "missing"
+	// KindOrphan is a row of the copy the source does not have.
+	KindOrphan = "orphan"
+	// KindMismatch is a row whose fields differ between source and copy.
+	KindMismatch = "mismatch"
+)
+
+// MaxReported bounds the divergences listed in a report, all of them are
+// still counted and repaired.
+var MaxReported = 10000
+
+// Options controls a check.
+type Options struct {
+	// Repair rewrites every divergent copy from the source of truth:
+	// missing and mismatching rows are written, orphans are deleted.
+	Repair bool
+}
+
+// FieldDiff is a field whose value differs between source and copy.
+type FieldDiff struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Copy   string `json:"copy"`
+}
+
+// Divergence is a row of a copy that does not match its source.
+type Divergence struct {
+	Table      string      `json:"table"`
+	Kind       string      `json:"kind"`
+	ID         string      `json:"id"`
+	ProposalID string      `json:"proposal_id,omitempty"`
+	Fields     []FieldDiff `json:"fields,omitempty"`
+	Repaired   bool        `json:"repaired"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Report is the outcome of a check.
+type Report struct {
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	Repair      bool           `json:"repair"`
+	Checked     map[string]int `json:"checked"`
+	Found       map[string]int `json:"found"`
+	Repaired    int            `json:"repaired"`
+	Divergences []Divergence   `json:"divergences"`
+	Truncated   bool           `json:"truncated,omitempty"`
+}
+
+// Consistent reports whether no divergence was found.
+func (r Report) Consistent() bool {
+	return len(r.Found) == 0
+}
+
+// Check compares every copy of the proposal and comment tables with its
+// source of truth, proposals_by_id and comments_by_proposal_and_comment_id.
+// Rows are compared one at a time while the tables keep changing, so a row
+// written during the check can show up as divergent. Run the check again to
+// tell drift from writes in flight.
+func Check(session *gocql.Session, opts Options) (Report, error) {
+	c := &checker{
+		session: session,
+		opts:    opts,
+		report: Report{
+			StartedAt: time.Now().UTC(),
+			Repair:    opts.Repair,
+			Checked:   make(map[string]int),
+			Found:     make(map[string]int),
+		},
+	}
+
+	if err := c.checkProposals(); err != nil {
+		return c.report, err
+	}
+	if err := c.checkComments(); err != nil {
+		return c.report, err
+	}
+
+	c.report.FinishedAt = time.Now().UTC()
+	return c.report, nil
+}
+
+type checker struct {
+	session *gocql.Session
+	opts    Options
+	report  Report
+}
+
+func (c *checker) checkProposals() error {
+	source, copies := proposalRepository.ProposalTables[0], proposalRepository.ProposalTables[1:]
+
+	// rows of the source missing from or differing in a copy
+	err := proposalRepository.IterateProposalRows(c.session, source, func(proposal entity.Proposal) error {
+		c.report.Checked[source]++
+
+		for _, table := range copies {
+			row, err := proposalRepository.GetProposalRow(c.session, table, proposal)
+			if err != nil {
+				return err
+			}
+
+			divergence := Divergence{Table: table, ID: proposal.ID.String()}
+			switch {
+			case row == nil:
+				divergence.Kind = KindMissing
+			default:
+				divergence.Fields = diffProposals(proposal, *row)
+				if len(divergence.Fields) == 0 {
+					continue
+				}
+				divergence.Kind = KindMismatch
+			}
+
+			c.found(divergence, func() error {
+				return proposalRepository.WriteProposalRow(c.session, table, proposal)
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// rows of a copy the source does not have
+	for _, table := range copies {
+		table := table
+		err := proposalRepository.IterateProposalRows(c.session, table, func(proposal entity.Proposal) error {
+			c.report.Checked[table]++
+
+			row, err := proposalRepository.GetProposalRow(c.session, source, proposal)
+			if err != nil || row != nil {
+				return err
+			}
+
+			c.found(Divergence{Table: table, Kind: KindOrphan, ID: proposal.ID.String()}, func() error {
+				return proposalRepository.DeleteProposalRow(c.session, table, proposal)
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *checker) checkComments() error {
+	source, copies := repository.CommentTables[0], repository.CommentTables[1:]
+
+	err := repository.IterateCommentRows(c.session, source, func(comment entity.Comment) error {
+		c.report.Checked[source]++
+
+		for _, table := range copies {
+			row, err := repository.GetCommentRow(c.session, table, comment)
+			if err != nil {
+				return err
+			}
+
+			divergence := Divergence{Table: table, ID: comment.CommentID.String(), ProposalID: comment.ProposalID.String()}
+			switch {
+			case row == nil:
+				divergence.Kind = KindMissing
+			default:
+				divergence.Fields = diffComments(comment, *row)
+				if len(divergence.Fields) == 0 {
+					continue
+				}
+				divergence.Kind = KindMismatch
+			}
+
+			c.found(divergence, func() error {
+				return repository.WriteCommentRow(c.session, table, comment)
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, table := range copies {
+		table := table
+		err := repository.IterateCommentRows(c.session, table, func(comment entity.Comment) error {
+			c.report.Checked[table]++
+
+			row, err := repository.GetCommentRow(c.session, source, comment)
+			if err != nil || row != nil {
+				return err
+			}
+
+			c.found(Divergence{Table: table, Kind: KindOrphan, ID: comment.CommentID.String(), ProposalID: comment.ProposalID.String()}, func() error {
+				return repository.DeleteCommentRow(c.session, table, comment)
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// found records a divergence and, when repairing, fixes it with repair. A
+// failed repair is reported on the divergence and does not stop the check.
+func (c *checker) found(divergence Divergence, repair func() error) {
+	c.report.Found[divergence.Kind]++
+
+	if c.opts.Repair {
+		if err := repair(); err != nil {
+			divergence.Error = err.Error()
+		} else {
+			divergence.Repaired = true
+			c.report.Repaired++
+		}
+	}
+
+	if len(c.report.Divergences) >= MaxReported {
+		c.report.Truncated = true
+		return
+	}
+	c.report.Divergences = append(c.report.Divergences, divergence)
+}
+
+func diffProposals(source, replica entity.Proposal) []FieldDiff {
+	return diff([][3]interface{}{
+		{"title", source.Title, replica.Title},
+		{"proposal_text", source.ProposalText, replica.ProposalText},
+		{"firstname", source.FirstName, replica.FirstName},
+		{"lastname", source.LastName, replica.LastName},
+		{"upvotes", source.UpVotes, replica.UpVotes},
+		{"downvotes", source.DownVotes, replica.DownVotes},
+		{"no_of_comments", source.NoOfComments, replica.NoOfComments},
+		{"status", source.Status, replica.Status},
+		{"hidden", source.Hidden, replica.Hidden},
+		{"last_updated", source.LastUpdated.UTC(), replica.LastUpdated.UTC()},
+	})
+}
+
+func diffComments(source, replica entity.Comment) []FieldDiff {
+	return diff([][3]interface{}{
+		{"comment", source.CommentText, replica.CommentText},
+		{"user_posted_id", source.UserPostedProposalID, replica.UserPostedProposalID},
+		{"user_posted_username", source.UserPostedUsername, replica.UserPostedUsername},
+		{"user_commented_id", source.UserCommentedID, replica.UserCommentedID},
+		{"user_commented_username", source.UserCommentedUsername, replica.UserCommentedUsername},
+		{"upvotes", source.UpVotes, replica.UpVotes},
+		{"hidden", source.Hidden, replica.Hidden},
+		{"last_updated", source.LastUpdated.UTC(), replica.LastUpdated.UTC()},
+	})
+}
+
+// diff compares triples of field name, source value and copy value.
+func diff(fields [][3]interface{}) []FieldDiff {
+	var diffs []FieldDiff
+	for _, field := range fields {
+		source, replica := fmt.Sprint(field[1]), fmt.Sprint(field[2])
+		if source != replica {
+			diffs = append(diffs, FieldDiff{Field: field[0].(string), Source: source, Copy: replica})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,29 @@
+package consistency
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+// MarkupReport counts the rows BackfillMarkup rendered.
+type MarkupReport struct {
+	Proposals int `json:"proposals"`
+	Comments  int `json:"comments"`
+}
+
+// BackfillMarkup stores the HTML and plain renderings of the proposals and
+// comments written before they were stored. Until then those are read as
+// escaped source. Running it again only renders rows still missing them.
+func BackfillMarkup(session *gocql.Session) (MarkupReport, error) {
+	var report MarkupReport
+	var err error
+
+	report.Proposals, err = proposalRepository.BackfillProposalMarkup(session)
+	if err != nil {
+		return report, err
+	}
+
+	report.Comments, err = repository.BackfillCommentMarkup(session)
+	return report, err
+}
@@ -0,0 +1,270 @@
+package contentfilter
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/contentfilter/repository"
+)
+
+// Verdicts of a filter, from the mildest to the strictest.
+const (
+	// Allow lets the post through.
+	Allow = "allow"
+	// Hold stores the post hidden and queues it for moderation.
+	Hold = "hold"
+	// Reject refuses to store the post.
+	Reject = "reject"
+)
+
+// Kinds of posts.
+const (
+	KindProposal = "proposal"
+	KindComment  = "comment"
+)
+
+// Submission is a post about to be written.
+type Submission struct {
+	Kind   string
+	UserID uuid.UUID
+	// Title is empty for comments.
+	Title string
+	Text  string
+}
+
+// Decision is a filter's verdict on a submission and why.
+type Decision struct {
+	Filter  string `json:"filter"`
+	Verdict string `json:"verdict"`
+	Reason  string `json:"reason"`
+}
+
+// Result is the outcome of running a submission through a pipeline.
+type Result struct {
+	// Verdict is the strictest verdict of all filters.
+	Verdict string `json:"verdict"`
+	// Decisions lists the filters that did not allow the submission.
+	Decisions []Decision `json:"decisions,omitempty"`
+}
+
+// Reasons joins the reasons of the decisions, e.g. for a moderator.
+func (r Result) Reasons() string {
+	reasons := make([]string, len(r.Decisions))
+	for i, decision := range r.Decisions {
+		reasons[i] = decision.Filter + ": " + decision.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Filter inspects submissions. A filter that finds nothing returns a
+// Decision with Verdict Allow.
+type Filter interface {
+	Name() string
+	Check(s Submission) (Decision, error)
+}
+
+// Config selects and tunes the filters of a pipeline.
+type Config struct {
+	// Words are lists of words and phrases, each with the verdict on a post
+	// containing any of them.
+	Words []WordList
+	// MaxLinks is how many links a post may contain before LinkVerdict
+	// applies. 0 disables the limit.
+	MaxLinks    int
+	LinkVerdict string
+	// DuplicateWindow is how far back a post is compared with the user's
+	// earlier posts, DuplicateVerdict applies to a repeated one. 0 disables
+	// the check.
+	DuplicateWindow  time.Duration
+	DuplicateVerdict string
+	// Text holds the length and charset rules.
+	Text TextRules
+}
+
+// Default is the configuration ConfigFromEnv starts from. It ships without
+// word lists, load them with ReadWordList.
+var Default = Config{
+	MaxLinks:         3,
+	LinkVerdict:      Hold,
+	DuplicateWindow:  24 * time.Hour,
+	DuplicateVerdict: Reject,
+	Text: TextRules{
+		MinLength:         2,
+		MaxRepeatedChars:  20,
+		MaxUppercaseRatio: 0.7,
+		Verdict:           Hold,
+	},
+}
+
+// ConfigFromEnv reads the configuration controllers build their pipeline
+// from, starting with Default:
+//
+//	CONTENT_FILTER_REJECT_WORDS, CONTENT_FILTER_HOLD_WORDS  comma separated word list files
+//	CONTENT_FILTER_MAX_LINKS                                links a post may contain, 0 disables the limit
+//	CONTENT_FILTER_LINK_VERDICT                             verdict on a post with too many links
+//	CONTENT_FILTER_DUPLICATE_VERDICT                        verdict on a repeated post
+//	CONTENT_FILTER_TEXT_VERDICT                             verdict on a post breaking the text rules
+//
+// A word list that cannot be read or an invalid value is an error, a filter
+// silently missing its lists would let everything through.
+func ConfigFromEnv() (Config, error) {
+	config := Default
+
+	lists := []struct {
+		key     string
+		verdict string
+	}{
+		{"CONTENT_FILTER_REJECT_WORDS", Reject},
+		{"CONTENT_FILTER_HOLD_WORDS", Hold},
+	}
+	for _, list := range lists {
+		for _, path := range strings.Split(os.Getenv(list.key), ",") {
+			if path = strings.TrimSpace(path); path == "" {
+				continue
+			}
+			words, err := ReadWordList(path, list.verdict)
+			if err != nil {
+				return config, fmt.Errorf("%s: %v", list.key, err)
+			}
+			config.Words = append(config.Words, words)
+		}
+	}
+
+	if value := os.Getenv("CONTENT_FILTER_MAX_LINKS"); value != "" {
+		links, err := strconv.Atoi(value)
+		if err != nil || links < 0 {
+			return config, fmt.Errorf("CONTENT_FILTER_MAX_LINKS: %q is not a number of links", value)
+		}
+		config.MaxLinks = links
+	}
+
+	verdicts := []struct {
+		key     string
+		verdict *string
+	}{
+		{"CONTENT_FILTER_LINK_VERDICT", &config.LinkVerdict},
+		{"CONTENT_FILTER_DUPLICATE_VERDICT", &config.DuplicateVerdict},
+		{"CONTENT_FILTER_TEXT_VERDICT", &config.Text.Verdict},
+	}
+	for _, v := range verdicts {
+		value := os.Getenv(v.key)
+		if value == "" {
+			continue
+		}
+		if value != Allow && value != Hold && value != Reject {
+			return config, fmt.Errorf("%s: %q is not one of %s, %s or %s", v.key, value, Allow, Hold, Reject)
+		}
+		*v.verdict = value
+	}
+
+	return config, nil
+}
+
+// Pipeline runs every filter on a submission.
+type Pipeline struct {
+	session *gocql.Session
+	filters []Filter
+}
+
+// New builds the pipeline configured by config. Duplicate detection keeps
+// the fingerprints of recent posts in session.
+func New(session *gocql.Session, config Config) *Pipeline {
+	p := &Pipeline{session: session}
+
+	for _, words := range config.Words {
+		p.Use(words)
+	}
+	if config.MaxLinks > 0 {
+		p.Use(LinkLimit{Max: config.MaxLinks, Verdict: config.LinkVerdict})
+	}
+	if config.DuplicateWindow > 0 {
+		p.Use(duplicates{session: session, window: config.DuplicateWindow, verdict: config.DuplicateVerdict})
+	}
+	p.Use(config.Text)
+
+	return p
+}
+
+// Use appends a filter to the pipeline.
+func (p *Pipeline) Use(f Filter) {
+	p.filters = append(p.filters, f)
+}
+
+// Check runs s through every filter. It stops at the first filter error.
+func (p *Pipeline) Check(s Submission) (Result, error) {
+	result := Result{Verdict: Allow}
+
+	for _, f := range p.filters {
+		decision, err := f.Check(s)
+		if err != nil {
+			return result, err
+		}
+		if decision.Verdict == Allow || decision.Verdict == "" {
+			continue
+		}
+
+		decision.Filter = f.Name()
+		result.Decisions = append(result.Decisions, decision)
+		if severity(decision.Verdict) > severity(result.Verdict) {
+			result.Verdict = decision.Verdict
+		}
+	}
+
+	return result, nil
+}
+
+func severity(verdict string) int {
+	switch verdict {
+	case Reject:
+		return 2
+	case Hold:
+		return 1
+	}
+	return 0
+}
+
+// Remember records a stored submission for duplicate detection.
+func (p *Pipeline) Remember(s Submission) error {
+	// Cassandra stores timestamps with millisecond precision
+	now := time.Now().Truncate(time.Millisecond)
+	return repository.RememberPost(p.session, s.UserID, s.Kind, fingerprint(s), now)
+}
+
+// fingerprint identifies the text of a submission regardless of case and
+// spacing.
+func fingerprint(s Submission) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(s.Title+" "+s.Text)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadWordList reads a word list with one word or phrase per line. Empty
+// lines and lines starting with # are skipped.
+func ReadWordList(path string, verdict string) (WordList, error) {
+	list := WordList{Verdict: verdict}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return list, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list.Words = append(list.Words, line)
+	}
+
+	return list, scanner.Err()
+}
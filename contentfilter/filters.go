@@ -0,0 +1,177 @@
+package contentfilter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/contentfilter/repository"
+)
+
+// WordList flags posts containing any of its words or phrases. Words match
+// whole words regardless of case, a phrase matches its words in sequence.
+type WordList struct {
+	Words   []string
+	Verdict string
+}
+
+func (WordList) Name() string {
+	return "words"
+}
+
+func (w WordList) Check(s Submission) (Decision, error) {
+	tokens := words(s.Title + " " + s.Text)
+
+	for _, entry := range w.Words {
+		phrase := words(entry)
+		if len(phrase) == 0 {
+			continue
+		}
+		if containsSequence(tokens, phrase) {
+			return Decision{Verdict: w.Verdict, Reason: fmt.Sprintf("contains %q", entry)}, nil
+		}
+	}
+
+	return Decision{Verdict: Allow}, nil
+}
+
+// words splits text into lower case words, dropping punctuation.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+}
+
+func containsSequence(tokens, sequence []string) bool {
+	for i := 0; i+len(sequence) <= len(tokens); i++ {
+		matched := true
+		for j := range sequence {
+			if tokens[i+j] != sequence[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// LinkLimit flags posts with more than Max links.
+type LinkLimit struct {
+	Max     int
+	Verdict string
+}
+
+func (LinkLimit) Name() string {
+	return "links"
+}
+
+func (l LinkLimit) Check(s Submission) (Decision, error) {
+	links := len(linkPattern.FindAllString(s.Title+" "+s.Text, -1))
+	if links > l.Max {
+		return Decision{Verdict: l.Verdict, Reason: fmt.Sprintf("contains %d links, at most %d are allowed", links, l.Max)}, nil
+	}
+
+	return Decision{Verdict: Allow}, nil
+}
+
+// duplicates flags posts repeating one the user made within the window.
+type duplicates struct {
+	session *gocql.Session
+	window  time.Duration
+	verdict string
+}
+
+func (duplicates) Name() string {
+	return "duplicates"
+}
+
+func (d duplicates) Check(s Submission) (Decision, error) {
+	recent, err := repository.RecentFingerprints(d.session, s.UserID, time.Now().Add(-d.window))
+	if err != nil {
+		return Decision{}, err
+	}
+
+	current := fingerprint(s)
+	for _, f := range recent {
+		if f == current {
+			return Decision{Verdict: d.verdict, Reason: "repeats a recent post of the same user"}, nil
+		}
+	}
+
+	return Decision{Verdict: Allow}, nil
+}
+
+// TextRules are length and charset rules. Text that is not valid UTF-8 or
+// contains control characters other than line breaks and tabs is always
+// rejected, the other rules apply Verdict.
+type TextRules struct {
+	// MinLength is the least letters and digits a post must contain.
+	MinLength int
+	// MaxRepeatedChars is the longest run of one character allowed.
+	MaxRepeatedChars int
+	// MaxUppercaseRatio is the highest share of upper case letters allowed,
+	// checked on posts of at least 20 letters.
+	MaxUppercaseRatio float64
+	Verdict           string
+}
+
+func (TextRules) Name() string {
+	return "text"
+}
+
+func (t TextRules) Check(s Submission) (Decision, error) {
+	text := s.Title + "\n" + s.Text
+
+	if !utf8.ValidString(text) {
+		return Decision{Verdict: Reject, Reason: "is not valid UTF-8"}, nil
+	}
+
+	var alphanumeric, letters, upper, run, longestRun int
+	var previous rune
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return Decision{Verdict: Reject, Reason: "contains control characters"}, nil
+		}
+
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			alphanumeric++
+		}
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+
+		if r == previous && !unicode.IsSpace(r) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longestRun {
+			longestRun = run
+		}
+		previous = r
+	}
+
+	if t.MinLength > 0 && alphanumeric < t.MinLength {
+		return Decision{Verdict: t.Verdict, Reason: fmt.Sprintf("is shorter than %d letters", t.MinLength)}, nil
+	}
+	if t.MaxRepeatedChars > 0 && longestRun > t.MaxRepeatedChars {
+		return Decision{Verdict: t.Verdict, Reason: fmt.Sprintf("repeats a character %d times", longestRun)}, nil
+	}
+	if t.MaxUppercaseRatio > 0 && letters >= 20 && float64(upper)/float64(letters) > t.MaxUppercaseRatio {
+		return Decision{Verdict: t.Verdict, Reason: "is mostly upper case"}, nil
+	}
+
+	return Decision{Verdict: Allow}, nil
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// RememberPost stores the fingerprint of a post the user made. Fingerprints
+// expire with the table's default TTL.
+func RememberPost(session *gocql.Session, userID uuid.UUID, kind, fingerprint string, createdAt time.Time) error {
+	return session.Query(`INSERT INTO recent_posts_by_user(user_id, created_at, fingerprint, kind)
+							VALUES (?, ?, ?, ?);`, gocql.UUID(userID), createdAt, fingerprint, kind).Exec()
+}
+
+// RecentFingerprints returns the fingerprints of the posts the user made
+// since the given time.
+func RecentFingerprints(session *gocql.Session, userID uuid.UUID, since time.Time) ([]string, error) {
+	var fingerprints []string
+	var fingerprint string
+
+	iter := session.Query(`SELECT fingerprint FROM recent_posts_by_user
+							WHERE user_id=? AND created_at>=?;`, gocql.UUID(userID), since).Iter()
+
+	for iter.Scan(&fingerprint) {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
+	return fingerprints, iter.Close()
+}
@@ -0,0 +1,219 @@
+package daterange
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxWindow caps how far apart the bounds of a range may be, so a single
+// request cannot scan the whole table.
+var MaxWindow = 92 * 24 * time.Hour
+
+var (
+	ErrNoBounds      = errors.New("give date-from, date-to or last")
+	ErrLastAndBounds = errors.New("last cannot be combined with date-from or date-to")
+	ErrReversed      = errors.New("date-from must not be after date-to")
+)
+
+// Range is an inclusive time interval.
+type Range struct {
+	From time.Time
+	To   time.Time
+}
+
+// Query holds the raw query parameters a range is read from.
+type Query struct {
+	// From and To are the bounds, either may be left out. See ParseTime for
+	// the accepted formats.
+	From string
+	To   string
+	// Last is a range ending now, like "7d", "12h" or "2w".
+	Last string
+	// TZ is the time zone of bounds without an offset, an IANA name or an
+	// offset like "+02:00". Defaults to UTC.
+	TZ string
+}
+
+// Parse resolves q into a Range relative to now. A missing From starts the
+// range MaxWindow before To, a missing To ends it at now.
+func Parse(q Query, now time.Time) (Range, error) {
+	return ParseWindow(q, now, MaxWindow)
+}
+
+// ParseWindow is Parse with a different limit on the length of the range. A
+// window of 0 allows any length, and a missing From then leaves Range.From
+// zero so the range is open towards the past.
+func ParseWindow(q Query, now time.Time, window time.Duration) (Range, error) {
+	loc, err := location(q.TZ)
+	if err != nil {
+		return Range{}, err
+	}
+
+	if q.Last != "" {
+		if q.From != "" || q.To != "" {
+			return Range{}, ErrLastAndBounds
+		}
+
+		d, err := ParseDuration(q.Last)
+		if err != nil {
+			return Range{}, err
+		}
+
+		return check(Range{From: now.Add(-d), To: now}, window)
+	}
+
+	if q.From == "" && q.To == "" {
+		return Range{}, ErrNoBounds
+	}
+
+	r := Range{To: now}
+
+	if q.To != "" {
+		to, dateOnly, err := ParseTime(q.To, loc)
+		if err != nil {
+			return Range{}, fmt.Errorf("date-to: %w", err)
+		}
+		if dateOnly {
+			// a day given as upper bound includes the whole day
+			to = to.AddDate(0, 0, 1).Add(-time.Millisecond)
+		}
+		r.To = to
+	}
+
+	if q.From != "" {
+		from, _, err := ParseTime(q.From, loc)
+		if err != nil {
+			return Range{}, fmt.Errorf("date-from: %w", err)
+		}
+		r.From = from
+	} else if window > 0 {
+		r.From = r.To.Add(-window)
+	}
+
+	return check(r, window)
+}
+
+func check(r Range, window time.Duration) (Range, error) {
+	if r.From.After(r.To) {
+		return Range{}, ErrReversed
+	}
+	if window > 0 && r.To.Sub(r.From) > window {
+		return Range{}, fmt.Errorf("the range must not be longer than %s", formatDuration(window))
+	}
+	return r, nil
+}
+
+// layouts are tried in order. Layouts without an offset are read in the
+// requested time zone.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	// the format this endpoint used to require
+	"2006-01-02-15:04",
+}
+
+const dateLayout = "2006-01-02"
+
+// ParseTime reads RFC 3339 timestamps, dates with an optional time, Unix
+// timestamps in seconds or milliseconds and the legacy "2006-01-02-15:04"
+// format. dateOnly reports a value without a time of day.
+func ParseTime(value string, loc *time.Location) (t time.Time, dateOnly bool, err error) {
+	value = strings.TrimSpace(value)
+
+	if isDigits(value) {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		// 13 digits and more are milliseconds, seconds only get there in the year 33658
+		if len(value) >= 13 {
+			return time.UnixMilli(n).UTC(), false, nil
+		}
+		return time.Unix(n, 0).UTC(), false, nil
+	}
+
+	if t, err := time.ParseInLocation(dateLayout, value, loc); err == nil {
+		return t, true, nil
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, false, nil
+		}
+	}
+
+	// an unescaped "+" of an offset arrives as a space
+	if i := strings.LastIndex(value, " "); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, value[:i]+"+"+value[i+1:]); err == nil {
+			return t, false, nil
+		}
+	}
+
+	return time.Time{}, false, fmt.Errorf("cannot read %q as a time, use RFC 3339, 2006-01-02 or a Unix timestamp", value)
+}
+
+// ParseDuration reads durations like "30m", "12h", "7d" or "2w".
+func ParseDuration(value string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+
+	if len(value) < 2 {
+		return 0, fmt.Errorf("cannot read %q as a duration, use e.g. 12h, 7d or 2w", value)
+	}
+
+	unit, ok := units[value[len(value)-1]]
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("cannot read %q as a duration, use e.g. 12h, 7d or 2w", value)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+func location(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+
+	// an unescaped "+" arrives as a space
+	if strings.HasPrefix(tz, " ") {
+		tz = "+" + tz[1:]
+	}
+
+	if t, err := time.Parse("-07:00", tz); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(tz, offset), nil
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+	return loc, nil
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func formatDuration(d time.Duration) string {
+	return strconv.Itoa(int(d.Hours()/24)) + " days"
+}
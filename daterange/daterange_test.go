@@ -0,0 +1,118 @@
+package daterange
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		loc      *time.Location
+		want     time.Time
+		dateOnly bool
+	}{
+		{"rfc 3339", "2021-03-04T05:06:07Z", time.UTC, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), false},
+		{"rfc 3339 offset", "2021-03-04T05:06:07+02:00", time.UTC, time.Date(2021, 3, 4, 3, 6, 7, 0, time.UTC), false},
+		{"unescaped plus", "2021-03-04T05:06:07 02:00", time.UTC, time.Date(2021, 3, 4, 3, 6, 7, 0, time.UTC), false},
+		{"date", "2021-03-04", time.UTC, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), true},
+		{"date in zone", "2021-03-04", berlin, time.Date(2021, 3, 4, 0, 0, 0, 0, berlin), true},
+		{"date and time", "2021-03-04 05:06", time.UTC, time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC), false},
+		{"legacy", "2021-03-04-05:06", time.UTC, time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC), false},
+		{"unix seconds", "1614834367", time.UTC, time.Unix(1614834367, 0), false},
+		{"unix milliseconds", "1614834367123", time.UTC, time.Unix(1614834367, 123000000), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dateOnly, err := ParseTime(tt.value, tt.loc)
+			if err != nil {
+				t.Fatalf("ParseTime(%q) failed: %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) || dateOnly != tt.dateOnly {
+				t.Errorf("ParseTime(%q) = %v, %v, want %v, %v", tt.value, got, dateOnly, tt.want, tt.dateOnly)
+			}
+		})
+	}
+
+	if _, _, err := ParseTime("yesterday", time.UTC); err == nil {
+		t.Error("ParseTime(\"yesterday\") did not fail")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30m", 30 * time.Minute, false},
+		{"12h", 12 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"0d", 0, true},
+		{"-1d", 0, true},
+		{"7", 0, true},
+		{"d", 0, true},
+		{"7y", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseDuration(%q) = %v, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   Query
+		want    Range
+		wantErr bool
+	}{
+		{"last", Query{Last: "7d"}, Range{From: now.AddDate(0, 0, -7), To: now}, false},
+		{"day as upper bound", Query{From: "2021-03-01", To: "2021-03-02"}, Range{From: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2021, 3, 2, 23, 59, 59, 999000000, time.UTC)}, false},
+		{"open end", Query{From: "2021-03-01"}, Range{From: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), To: now}, false},
+		{"open start", Query{To: "2021-03-10T12:00:00Z"}, Range{From: now.Add(-MaxWindow), To: now}, false},
+		{"offset zone", Query{From: "2021-03-01T00:00", To: "2021-03-02T00:00", TZ: "+02:00"}, Range{From: time.Date(2021, 2, 28, 22, 0, 0, 0, time.UTC), To: time.Date(2021, 3, 1, 22, 0, 0, 0, time.UTC)}, false},
+		{"no bounds", Query{}, Range{}, true},
+		{"last and bounds", Query{Last: "7d", From: "2021-03-01"}, Range{}, true},
+		{"reversed", Query{From: "2021-03-02", To: "2021-03-01"}, Range{}, true},
+		{"too long", Query{From: "2020-01-01", To: "2021-01-01"}, Range{}, true},
+		{"unknown zone", Query{From: "2021-03-01", TZ: "Mars/Olympus"}, Range{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.query, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%+v) error = %v", tt.query, err)
+			}
+			if !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) {
+				t.Errorf("Parse(%+v) = %v - %v, want %v - %v", tt.query, got.From, got.To, tt.want.From, tt.want.To)
+			}
+		})
+	}
+}
+
+func TestParseWindowUnlimited(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	got, err := ParseWindow(Query{To: "2021-03-10"}, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.From.IsZero() {
+		t.Errorf("From = %v, want the zero time", got.From)
+	}
+}
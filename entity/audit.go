@@ -0,0 +1,55 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditCreate       = "create"
+	AuditUpdate       = "update"
+	AuditDelete       = "delete"
+	AuditDeleteAll    = "delete_all"
+	AuditUpvote       = "upvote"
+	AuditDownvote     = "downvote"
+	AuditStatusChange = "status_change"
+	AuditImport       = "import"
+	AuditHide         = "hide"
+	AuditUnhide       = "unhide"
+)
+
+// Targets of audited actions.
+const (
+	AuditTargetProposal = "proposal"
+	AuditTargetComment  = "comment"
+)
+
+// Actor is the user behind a change and the client it was sent from.
+type Actor struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	IP       string    `json:"ip,omitempty"`
+}
+
+// SystemActor is the actor of changes no user asked for, like imports run
+// from the command line.
+func SystemActor(name string) Actor {
+	return Actor{Username: "system:" + name}
+}
+
+// AuditEntry records one change of a proposal or comment. Before and After
+// are JSON snapshots of the target, left empty where it did not exist.
+type AuditEntry struct {
+	ID         uuid.UUID       `json:"id"`
+	Actor      Actor           `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	ProposalID uuid.UUID       `json:"proposal_id,omitempty"`
+	CommentID  uuid.UUID       `json:"comment_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
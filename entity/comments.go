@@ -7,14 +7,33 @@ import (
 )
 
 type Comment struct {
-	ProposalID            uuid.UUID `json:"proposal_id,omitempty" form:"proposal_id"` //Partition key
+	ProposalID            uuid.UUID `json:"proposal_id,omitempty" form:"proposal_id" validate:"required"` //Partition key
 	CommentID             uuid.UUID `json:"id,omitempty" form:"id"`
-	CommentText           string    `json:"comment,omitempty" form:"id"`
+	CommentText           string    `json:"comment,omitempty" form:"id" validate:"required,max=2000"`
+	CommentHTML           string    `json:"-"`
 	UserPostedProposalID  uuid.UUID `json:"user_posted_id,omitempty" form:"posted_user_id"`
 	UserPostedUsername    string    `json:"user_posted,omitempty" form:"user_posted"`
-	UserCommentedID       uuid.UUID `json:"user_commented_id,omitempty" form:"user_commented_id"`
-	UserCommentedUsername string    `json:"user_commented,omitempty" form:"user_commented"`
+	UserCommentedID       uuid.UUID `json:"user_commented_id,omitempty" form:"user_commented_id" validate:"required"`
+	UserCommentedUsername string    `json:"user_commented,omitempty" form:"user_commented" validate:"required"`
 	UpVotes               int       `json:"upvotes,omitempty" form:"upvotes"`
-	CreatedAt             time.Time `json:"created_at,omitempty"`
+	Hidden                bool      `json:"hidden,omitempty"`
+	CreatedAt             time.Time `json:"created_at,omitempty" validate:"required"`
 	LastUpdated           time.Time `json:"last_updated,omitempty"`
+
+	// Mentions are the ids of the users mentioned in the comment.
+	Mentions []uuid.UUID `json:"mentions,omitempty"`
+
+	// CommentPlain is the text without markup, stored next to CommentHTML.
+	CommentPlain string `json:"-"`
+}
+
+// Mention is the entry of a comment in the index of the comments a user was
+// mentioned in.
+type Mention struct {
+	UserID         uuid.UUID `json:"user_id"`
+	ProposalID     uuid.UUID `json:"proposal_id"`
+	CommentID      uuid.UUID `json:"comment_id"`
+	AuthorID       uuid.UUID `json:"author_id"`
+	AuthorUsername string    `json:"author_username"`
+	CreatedAt      time.Time `json:"created_at"`
 }
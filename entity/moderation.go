@@ -0,0 +1,78 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a proposal or comment can be reported for.
+const (
+	ReportSpam           = "spam"
+	ReportAbuse          = "abuse"
+	ReportHarassment     = "harassment"
+	ReportOffTopic       = "off_topic"
+	ReportMisinformation = "misinformation"
+	ReportOther          = "other"
+	// ReportContentFilter is the reason of content the content filter held.
+	ReportContentFilter = "content_filter"
+)
+
+// Statuses of reported content.
+const (
+	ModerationPending  = "pending"
+	ModerationResolved = "resolved"
+)
+
+// Actions a moderator resolves reports with.
+const (
+	ModerationHide    = "hide"
+	ModerationRemove  = "remove"
+	ModerationDismiss = "dismiss"
+	ModerationWarn    = "warn"
+)
+
+// Report is a user's complaint about a proposal or, if CommentID is set, a
+// comment.
+type Report struct {
+	TargetType       string    `json:"target_type"`
+	ProposalID       uuid.UUID `json:"proposal_id"`
+	CommentID        uuid.UUID `json:"comment_id,omitempty"`
+	ReporterID       uuid.UUID `json:"reporter_id"`
+	ReporterUsername string    `json:"reporter_username"`
+	Reason           string    `json:"reason"`
+	Details          string    `json:"details,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ModerationItem is the moderation state of reported content, with the
+// reports counted per reason and the moderator's decision once resolved.
+type ModerationItem struct {
+	TargetID          uuid.UUID      `json:"target_id"`
+	TargetType        string         `json:"target_type"`
+	ProposalID        uuid.UUID      `json:"proposal_id"`
+	CommentID         uuid.UUID      `json:"comment_id,omitempty"`
+	AuthorID          uuid.UUID      `json:"author_id"`
+	Status            string         `json:"status"`
+	ReportCount       int            `json:"report_count"`
+	Reasons           map[string]int `json:"reasons"`
+	LastReportedAt    time.Time      `json:"last_reported_at"`
+	Action            string         `json:"action,omitempty"`
+	ModeratorID       uuid.UUID      `json:"moderator_id,omitempty"`
+	ModeratorUsername string         `json:"moderator_username,omitempty"`
+	Note              string         `json:"note,omitempty"`
+	ResolvedAt        time.Time      `json:"resolved_at,omitempty"`
+}
+
+// Warning is a moderator's warning to the author of reported content.
+type Warning struct {
+	UserID            uuid.UUID `json:"user_id"`
+	ID                uuid.UUID `json:"id"`
+	ModeratorID       uuid.UUID `json:"moderator_id"`
+	ModeratorUsername string    `json:"moderator_username"`
+	Reason            string    `json:"reason,omitempty"`
+	TargetType        string    `json:"target_type"`
+	ProposalID        uuid.UUID `json:"proposal_id"`
+	CommentID         uuid.UUID `json:"comment_id,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
@@ -0,0 +1,53 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of notifications.
+const (
+	// NotificationComment tells a follower about a new comment.
+	NotificationComment = "comment"
+	// NotificationReply tells a follower who commented on the proposal about
+	// a new comment in the discussion.
+	NotificationReply = "reply"
+	// NotificationStatusChange tells a follower the proposal's status changed.
+	NotificationStatusChange = "status_change"
+	// NotificationVoteMilestone tells the author the proposal reached a
+	// number of upvotes.
+	NotificationVoteMilestone = "vote_milestone"
+)
+
+// Why a user follows a proposal.
+const (
+	FollowAuthor    = "author"
+	FollowCommented = "commented"
+	FollowManual    = "manual"
+)
+
+// Follower is a user following a proposal. Following is false after the
+// user unfollowed it, so commenting does not follow it again.
+type Follower struct {
+	ProposalID uuid.UUID `json:"proposal_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Reason     string    `json:"reason"`
+	Following  bool      `json:"following"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// Notification tells a user about activity on a proposal.
+type Notification struct {
+	UserID        uuid.UUID `json:"user_id"`
+	ID            uuid.UUID `json:"id"`
+	Type          string    `json:"type"`
+	ProposalID    uuid.UUID `json:"proposal_id"`
+	ProposalTitle string    `json:"proposal_title"`
+	CommentID     uuid.UUID `json:"comment_id,omitempty"`
+	ActorID       uuid.UUID `json:"actor_id,omitempty"`
+	ActorUsername string    `json:"actor_username,omitempty"`
+	Message       string    `json:"message"`
+	Read          bool      `json:"read"`
+	CreatedAt     time.Time `json:"created_at"`
+}
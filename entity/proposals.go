@@ -6,17 +6,32 @@ import (
 	"github.com/google/uuid"
 )
 
+// Statuses a proposal moves through after it was submitted.
+const (
+	ProposalStatusOpen        = "open"
+	ProposalStatusUnderReview = "under_review"
+	ProposalStatusAccepted    = "accepted"
+	ProposalStatusRejected    = "rejected"
+	ProposalStatusImplemented = "implemented"
+)
+
 type Proposal struct {
 	ID           uuid.UUID `json:"id,omitempty"  form:"id"`
-	Title        string    `json:"title,omitempty" form:"title"`
-	ProposalText string    `json:"proposal_text,omitempty"  form:"proposal_text"`
-	UserID       uuid.UUID `json:"user_id,omitempty"  form:"user_id"`
-	Username     string    `json:"username,omitempty"  form:"username"`
+	Title        string    `json:"title,omitempty" form:"title" validate:"required,max=200"`
+	ProposalText string    `json:"proposal_text,omitempty"  form:"proposal_text" validate:"required,max=10000"`
+	ProposalHTML string    `json:"-"`
+	UserID       uuid.UUID `json:"user_id,omitempty"  form:"user_id" validate:"required"`
+	Username     string    `json:"username,omitempty"  form:"username" validate:"required"`
 	FirstName    string    `json:"firstname,omitempty"  form:"firstname"`
 	LastName     string    `json:"lastname,omitempty"  form:"lastname"`
 	UpVotes      int       `json:"upvotes,omitempty"`
 	DownVotes    int       `json:"downvotes,omitempty"`
 	NoOfComments int       `json:"no_of_comments,omitempty" form:"no_of_comments"`
-	CreatedAt    time.Time `json:"created_at,omitempty"`
+	Status       string    `json:"status,omitempty" form:"status" validate:"oneof=open under_review accepted rejected implemented"`
+	Hidden       bool      `json:"hidden,omitempty"`
+	CreatedAt    time.Time `json:"created_at,omitempty" validate:"required"`
 	LastUpdated  time.Time `json:"last_updated,omitempty"`
+
+	// ProposalPlain is the text without markup, stored next to ProposalHTML.
+	ProposalPlain string `json:"-"`
 }
@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookAllEvents subscribes a webhook to every event.
+const WebhookAllEvents = "*"
+
+// WebhookEvents are the events a webhook can subscribe to, named
+// <target>.<action> after the audited actions.
+var WebhookEvents = []string{
+	"proposal.create", "proposal.update", "proposal.delete", "proposal.upvote", "proposal.downvote",
+	"proposal.status_change", "proposal.hide", "proposal.unhide", "proposal.import", "proposal.delete_all",
+	"comment.create", "comment.update", "comment.delete", "comment.upvote", "comment.hide", "comment.unhide",
+	"comment.import", "comment.delete_all",
+}
+
+// Webhook is an endpoint that is sent the events it subscribed to.
+type Webhook struct {
+	ID     uuid.UUID `json:"id"`
+	URL    string    `json:"url"`
+	Events []string  `json:"events"`
+	// Secret signs the payloads. It is only returned when the webhook is
+	// created.
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedBy uuid.UUID `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribes reports whether the webhook is sent the event.
+func (w Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event || e == WebhookAllEvents {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery is one attempt to send an event to a webhook.
+type WebhookDelivery struct {
+	WebhookID uuid.UUID `json:"webhook_id"`
+	ID        uuid.UUID `json:"id"`
+	// EventID is the same for every attempt to send the event.
+	EventID    uuid.UUID `json:"event_id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int       `json:"duration_ms"`
+	Succeeded  bool      `json:"succeeded"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookDeadLetter is an event that could not be sent to a webhook within
+// the allowed attempts. It can be sent again by hand.
+type WebhookDeadLetter struct {
+	WebhookID uuid.UUID `json:"webhook_id"`
+	EventID   uuid.UUID `json:"event_id"`
+	Event     string    `json:"event"`
+	Payload   string    `json:"payload"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// WebhookPendingDelivery is an event waiting to be sent to a webhook, stored
+// so it survives a restart. Without a WebhookID it is the event itself,
+// waiting to be handed to the webhooks subscribed to it.
+type WebhookPendingDelivery struct {
+	WebhookID     uuid.UUID
+	EventID       uuid.UUID
+	Event         string
+	Payload       string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	// ClaimedUntil is when the instance sending the delivery gives it up,
+	// the zero time if none claimed it.
+	ClaimedUntil time.Time
+}
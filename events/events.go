@@ -0,0 +1,187 @@
+// Package events publishes the changes of proposals and comments to
+// subscribers inside the process. Every change recorded in the audit log is
+// published as well, with the same action and versions.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+// Event is a change of a proposal or, with CommentID set, a comment. Action
+// is one of the entity.Audit actions. Before and After are the changed
+// entity.Proposal or entity.Comment, nil where there is no such version.
+type Event struct {
+	Action     string
+	TargetType string
+	ProposalID uuid.UUID
+	CommentID  uuid.UUID
+	Actor      entity.Actor
+	Before     interface{}
+	After      interface{}
+	At         time.Time
+}
+
+// DefaultBuffer is how many events a subscription holds before it drops new
+// ones.
+const DefaultBuffer = 1024
+
+// Subscription receives the published events in order on its own goroutine,
+// so a slow subscriber delays neither the publisher nor the others.
+type Subscription struct {
+	Name    string
+	events  chan Event
+	done    chan struct{}
+	mu      sync.Mutex
+	dropped int64
+
+	// queued subscriptions keep the events in queue instead of events and
+	// never drop one, signal tells their goroutine there are new ones
+	queued bool
+	queue  []Event
+	closed bool
+	signal chan struct{}
+}
+
+var (
+	mu            sync.RWMutex
+	subscriptions = map[*Subscription]bool{}
+)
+
+// Subscribe calls handle for every event published from now on until the
+// subscription is closed. Events published while buffer of them are waiting
+// are dropped.
+func Subscribe(name string, buffer int, handle func(Event)) *Subscription {
+	return subscribe(name, buffer, false, handle)
+}
+
+// SubscribeQueued is Subscribe for subscribers that must not miss an event.
+// The waiting events are queued without a bound instead of being dropped, so
+// Publish never waits for the subscriber; handle should be quick, or the
+// queue grows while it falls behind.
+func SubscribeQueued(name string, handle func(Event)) *Subscription {
+	return subscribe(name, 0, true, handle)
+}
+
+func subscribe(name string, buffer int, queued bool, handle func(Event)) *Subscription {
+	s := &Subscription{
+		Name:   name,
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+		queued: queued,
+		signal: make(chan struct{}, 1),
+	}
+
+	if queued {
+		go s.drain(handle)
+	} else {
+		go func() {
+			defer close(s.done)
+			for event := range s.events {
+				handle(event)
+			}
+		}()
+	}
+
+	mu.Lock()
+	subscriptions[s] = true
+	mu.Unlock()
+
+	return s
+}
+
+// drain hands the queued events to handle until the subscription is closed
+// and its queue is empty.
+func (s *Subscription) drain(handle func(Event)) {
+	defer close(s.done)
+
+	for {
+		s.mu.Lock()
+		queue, closed := s.queue, s.closed
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, event := range queue {
+			handle(event)
+		}
+		if closed && len(queue) == 0 {
+			return
+		}
+		if len(queue) == 0 {
+			<-s.signal
+		}
+	}
+}
+
+// enqueue adds the event to the queue of a queued subscription.
+func (s *Subscription) enqueue(event Event) {
+	s.mu.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, event)
+	}
+	s.mu.Unlock()
+
+	s.wake()
+}
+
+func (s *Subscription) wake() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the subscription after the waiting events were handled.
+func (s *Subscription) Close() {
+	mu.Lock()
+	if subscriptions[s] {
+		delete(subscriptions, s)
+		if s.queued {
+			s.mu.Lock()
+			s.closed = true
+			s.mu.Unlock()
+			s.wake()
+		} else {
+			close(s.events)
+		}
+	}
+	mu.Unlock()
+
+	<-s.done
+}
+
+// Dropped returns how many events the subscription dropped because its
+// buffer was full.
+func (s *Subscription) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Publish hands the event to every subscription without waiting for them.
+func Publish(event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for s := range subscriptions {
+		if s.queued {
+			s.enqueue(event)
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		}
+	}
+}
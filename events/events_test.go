@@ -0,0 +1,40 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueuedSubscriptionDoesNotBlockPublish(t *testing.T) {
+	release := make(chan struct{})
+	var handled []int
+	s := SubscribeQueued("test", func(event Event) {
+		<-release
+		handled = append(handled, int(event.At.Unix()))
+	})
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 3*DefaultBuffer; i++ {
+			Publish(Event{Action: "test", At: time.Unix(int64(i), 0)})
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish waited for a stalled subscriber")
+	}
+
+	close(release)
+	s.Close()
+	if len(handled) != 3*DefaultBuffer {
+		t.Fatalf("handled %d events, want %d", len(handled), 3*DefaultBuffer)
+	}
+	for i, at := range handled {
+		if at != i {
+			t.Fatalf("event %d handled as %d, want them in order", i, at)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/daterange"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+)
+
+// Formats an export can be written in.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrUnknownFormat = errors.New("format must be csv or ndjson")
+
+// Header is the first row of a CSV export. Proposals and comments share the
+// columns, record_type tells them apart and columns a record does not have
+// stay empty. A comment follows the proposal it belongs to.
+var Header = []string{
+	"record_type", "id", "proposal_id", "title", "text", "user_id", "username",
+	"firstname", "lastname", "status", "upvotes", "downvotes", "no_of_comments",
+	"created_at", "last_updated",
+}
+
+// Options selects what is exported and how.
+type Options struct {
+	Format   string
+	Filter   proposalRepository.ProposalFilter
+	Comments bool
+}
+
+// Summary counts the exported records.
+type Summary struct {
+	Proposals int `json:"proposals"`
+	Comments  int `json:"comments"`
+}
+
+// Record is one line of an NDJSON export.
+type Record struct {
+	entity.Proposal
+	Comments []entity.Comment `json:"comments,omitempty"`
+}
+
+// ParseFilter reads the filter of an export from its raw parameters. Without
+// any bounds every proposal is exported, and the range may be of any length.
+func ParseFilter(q daterange.Query, userID, status string, now time.Time) (proposalRepository.ProposalFilter, error) {
+	var filter proposalRepository.ProposalFilter
+
+	if q.From != "" || q.To != "" || q.Last != "" {
+		dateRange, err := daterange.ParseWindow(q, now, 0)
+		if err != nil {
+			return filter, err
+		}
+		filter.From, filter.To = dateRange.From, dateRange.To
+	}
+
+	if userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			return filter, fmt.Errorf("user id: %w", err)
+		}
+		filter.UserID = id
+	}
+
+	if errs := validation.Value("status", status, "oneof="+strings.Join(Statuses, " ")); len(errs) > 0 {
+		return filter, errs
+	}
+	filter.Status = status
+
+	return filter, nil
+}
+
+// Statuses a proposal can be filtered by.
+var Statuses = []string{
+	entity.ProposalStatusOpen, entity.ProposalStatusUnderReview, entity.ProposalStatusAccepted,
+	entity.ProposalStatusRejected, entity.ProposalStatusImplemented,
+}
+
+// ContentType returns the media type of an export in format.
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Write streams the proposals matching opts.Filter to w. Rows are written as
+// they are read, only the comments of one proposal are held in memory at a
+// time.
+func Write(session *gocql.Session, w io.Writer, opts Options) (Summary, error) {
+	switch opts.Format {
+	case FormatCSV:
+		return writeCSV(session, w, opts)
+	case FormatNDJSON:
+		return writeNDJSON(session, w, opts)
+	}
+	return Summary{}, ErrUnknownFormat
+}
+
+func writeCSV(session *gocql.Session, w io.Writer, opts Options) (Summary, error) {
+	var summary Summary
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(Header); err != nil {
+		return summary, err
+	}
+
+	err := proposalRepository.IterateProposals(session, opts.Filter, func(proposal entity.Proposal) error {
+		summary.Proposals++
+		if err := writer.Write(proposalRow(proposal)); err != nil {
+			return err
+		}
+
+		if !opts.Comments {
+			return nil
+		}
+
+		return repository.IterateCommentsByProposalID(session, proposal.ID, func(comment entity.Comment) error {
+			summary.Comments++
+			return writer.Write(commentRow(comment))
+		})
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	writer.Flush()
+	return summary, writer.Error()
+}
+
+func writeNDJSON(session *gocql.Session, w io.Writer, opts Options) (Summary, error) {
+	var summary Summary
+	encoder := json.NewEncoder(w)
+
+	err := proposalRepository.IterateProposals(session, opts.Filter, func(proposal entity.Proposal) error {
+		record := Record{Proposal: proposal}
+
+		if opts.Comments {
+			err := repository.IterateCommentsByProposalID(session, proposal.ID, func(comment entity.Comment) error {
+				record.Comments = append(record.Comments, comment)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		summary.Proposals++
+		summary.Comments += len(record.Comments)
+		return encoder.Encode(record)
+	})
+
+	return summary, err
+}
+
+func proposalRow(p entity.Proposal) []string {
+	return []string{
+		"proposal", p.ID.String(), p.ID.String(), p.Title, p.ProposalText,
+		p.UserID.String(), p.Username, p.FirstName, p.LastName, p.Status,
+		strconv.Itoa(p.UpVotes), strconv.Itoa(p.DownVotes), strconv.Itoa(p.NoOfComments),
+		formatTime(p.CreatedAt), formatTime(p.LastUpdated),
+	}
+}
+
+func commentRow(c entity.Comment) []string {
+	return []string{
+		"comment", c.CommentID.String(), c.ProposalID.String(), "", c.CommentText,
+		c.UserCommentedID.String(), c.UserCommentedUsername, "", "", "",
+		strconv.Itoa(c.UpVotes), "", "",
+		formatTime(c.CreatedAt), formatTime(c.LastUpdated),
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -1,12 +1,27 @@
 module github.com/Emmrys-Jay/golang-cassandra-rest-api
 
-go 1.17
+go 1.25.0
 
-require github.com/gocql/gocql v1.1.0
+require (
+	github.com/gocql/gocql v1.1.0
+	github.com/google/uuid v1.3.0
+	github.com/labstack/echo/v4 v4.16.0
+	gorm.io/gorm v1.31.2
+)
 
 require (
 	github.com/golang/snappy v0.0.3 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/labstack/gommon v0.5.0 // indirect
+	github.com/mattn/go-colorable v0.1.15 // indirect
+	github.com/mattn/go-isatty v0.0.22 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v1.2.2 // indirect
+	golang.org/x/crypto v0.53.0 // indirect
+	golang.org/x/net v0.56.0 // indirect
+	golang.org/x/sys v0.46.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
 	gopkg.in/inf.v0 v0.9.1 // indirect
 )
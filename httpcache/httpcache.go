@@ -0,0 +1,136 @@
+package httpcache
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/markup"
+)
+
+// CacheControl makes clients revalidate every time. The responses depend on
+// the caller's credentials, so shared caches must not store them.
+const CacheControl = "private, no-cache"
+
+// Validators identify one version of a response. A zero LastModified sends
+// no Last-Modified header and ignores If-Modified-Since.
+type Validators struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// ForProposals derives the ETag of proposals from their ids, LastUpdated
+// values, votes, comment counts and statuses. They carry no Last-Modified:
+// votes, comment counts, status changes, hiding and deleting do not move
+// LastUpdated, so If-Modified-Since would answer 304 for a changed proposal.
+func ForProposals(proposals []entity.Proposal) Validators {
+	hash := sha1.New()
+
+	for _, proposal := range proposals {
+		hash.Write(proposal.ID[:])
+		writeInt(hash, proposal.LastUpdated.UnixNano())
+		writeInt(hash, int64(proposal.UpVotes))
+		writeInt(hash, int64(proposal.DownVotes))
+		writeInt(hash, int64(proposal.NoOfComments))
+		hash.Write([]byte(proposal.Status))
+	}
+
+	return Validators{ETag: etag(hash.Sum(nil))}
+}
+
+// ForComments derives the ETag of comments from their ids,
+// LastUpdated values and vote counts. As for ForProposals, they carry no
+// Last-Modified.
+func ForComments(comments []entity.Comment) Validators {
+	hash := sha1.New()
+
+	for _, comment := range comments {
+		hash.Write(comment.CommentID[:])
+		writeInt(hash, comment.LastUpdated.UnixNano())
+		writeInt(hash, int64(comment.UpVotes))
+	}
+
+	return Validators{ETag: etag(hash.Sum(nil))}
+}
+
+// WithFormat returns the validators of a response with the text in format.
+// Every format gets its own ETag, so a client revalidating one format is not
+// answered 304 for another. Markdown, the default, keeps the ETag as it is.
+func (v Validators) WithFormat(format string) Validators {
+	if format != "" && format != markup.FormatMarkdown {
+		v.ETag = strings.TrimSuffix(v.ETag, `"`) + "-" + format + `"`
+	}
+	return v
+}
+
+// NotModified sets the validator and Cache-Control headers on the response and
+// reports whether the request's If-None-Match or If-Modified-Since header
+// already matches v, in which case the handler should answer 304.
+func NotModified(c echo.Context, v Validators) bool {
+	header := c.Response().Header()
+	header.Set("ETag", v.ETag)
+	header.Set("Cache-Control", CacheControl)
+	if !v.LastModified.IsZero() {
+		header.Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	request := c.Request().Header
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 7232 3.3).
+	if ifNoneMatch := request.Get("If-None-Match"); ifNoneMatch != "" {
+		return MatchETag(ifNoneMatch, v.ETag)
+	}
+
+	if ifModifiedSince := request.Get("If-Modified-Since"); ifModifiedSince != "" && !v.LastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		return !v.LastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// MatchETag reports whether the comma separated list of entity tags in header
+// contains etag or is "*". Weak tags compare equal to their strong form.
+func MatchETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchVersion is MatchETag for If-Match preconditions. The entity tags of
+// every format of a version match, the client may have read any of them.
+func MatchVersion(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if i := strings.LastIndex(candidate, "-"); i >= 0 && strings.HasSuffix(candidate, `"`) {
+			candidate = candidate[:i] + `"`
+		}
+		if MatchETag(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func etag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+func writeInt(w io.Writer, v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	w.Write(b[:])
+}
@@ -0,0 +1,47 @@
+package httpcache
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/markup"
+)
+
+func TestWithFormat(t *testing.T) {
+	v := ForProposals([]entity.Proposal{{ID: uuid.New(), UpVotes: 1}})
+
+	if got := v.WithFormat(markup.FormatMarkdown).ETag; got != v.ETag {
+		t.Errorf("markdown ETag = %s, want %s", got, v.ETag)
+	}
+	html, plain := v.WithFormat(markup.FormatHTML).ETag, v.WithFormat(markup.FormatPlain).ETag
+	if html == v.ETag || plain == v.ETag || html == plain {
+		t.Errorf("ETags %s, %s and %s are not distinct", v.ETag, html, plain)
+	}
+
+	e := echo.New()
+	request := httptest.NewRequest("GET", "/?format=plain", nil)
+	request.Header.Set("If-None-Match", html)
+	if NotModified(e.NewContext(request, httptest.NewRecorder()), v.WithFormat(markup.FormatPlain)) {
+		t.Error("the HTML ETag revalidated the plain response")
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	v := ForComments([]entity.Comment{{CommentID: uuid.New()}})
+	other := ForComments([]entity.Comment{{CommentID: uuid.New()}})
+
+	for _, format := range []string{markup.FormatMarkdown, markup.FormatHTML, markup.FormatPlain} {
+		if !MatchVersion(v.WithFormat(format).ETag, v.ETag) {
+			t.Errorf("MatchVersion(%s) = false", format)
+		}
+		if MatchVersion(other.WithFormat(format).ETag, v.ETag) {
+			t.Errorf("MatchVersion(%s) matched another version", format)
+		}
+	}
+	if !MatchVersion(`"x", `+v.WithFormat(markup.FormatHTML).ETag, v.ETag) {
+		t.Error("MatchVersion did not search the list")
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// KeyTTL is how long a key is remembered. It must match the default TTL of
+// the idempotency_keys table.
+const KeyTTL = 24 * time.Hour
+
+// MaxKeyLength bounds the Idempotency-Key header.
+const MaxKeyLength = 255
+
+// ErrKeyReused means the key was already used for a request with a different body.
+var ErrKeyReused = errors.New("idempotency key was already used for a different request")
+
+// Record is what a key resolves to: the created resource and, for comments,
+// the proposal it belongs to.
+type Record struct {
+	ResourceID uuid.UUID
+	ParentID   uuid.UUID
+}
+
+// Fingerprint hashes the parts of a request that must be identical when it
+// is retried with the same key.
+func Fingerprint(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reserve claims key for record with a lightweight transaction. If the key
+// was claimed before, the record stored then is returned with replayed set.
+func Reserve(session *gocql.Session, scope string, userID uuid.UUID, key, fingerprint string, record Record) (Record, bool, error) {
+	existing := map[string]interface{}{}
+
+	applied, err := session.Query(`INSERT INTO idempotency_keys(scope, user_id, key, resource_id, parent_id, fingerprint, created_at)
+							VALUES (?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS USING TTL ?;`, scope, gocql.UUID(userID), key, gocql.UUID(record.ResourceID),
+		gocql.UUID(record.ParentID), fingerprint, time.Now(), int(KeyTTL.Seconds())).MapScanCAS(existing)
+
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	if applied {
+		return record, false, nil
+	}
+
+	if existing["fingerprint"].(string) != fingerprint {
+		return Record{}, true, ErrKeyReused
+	}
+
+	return Record{
+		ResourceID: uuid.UUID(existing["resource_id"].(gocql.UUID)),
+		ParentID:   uuid.UUID(existing["parent_id"].(gocql.UUID)),
+	}, true, nil
+}
+
+// Release forgets key so the client can retry after the write it guarded failed.
+func Release(session *gocql.Session, scope string, userID uuid.UUID, key string) error {
+	return session.Query(`DELETE FROM idempotency_keys WHERE scope=? AND user_id=? AND key=?;`, scope, gocql.UUID(userID), key).Exec()
+}
@@ -0,0 +1,428 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/consistency"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/export"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+)
+
+// CodeNotImported marks comments left out because their proposal was.
+const CodeNotImported = "proposal_not_imported"
+
+var ErrCheckpointMismatch = errors.New("the checkpoint belongs to another input, remove it to start over")
+
+// Actor is who imported rows are recorded as in the audit log.
+var Actor = entity.SystemActor("import")
+
+// Options controls an import.
+type Options struct {
+	// Format is export.FormatCSV or export.FormatNDJSON, the layouts export
+	// writes.
+	Format string
+	// DryRun reads and validates every row without writing anything.
+	DryRun bool
+	// Checkpoint is the file progress is saved to after every proposal and
+	// resumed from when the import is run again. Empty disables checkpoints.
+	Checkpoint string
+	// Input names the imported file in the checkpoint.
+	Input string
+}
+
+// RowError lists why a row was not imported.
+type RowError struct {
+	Row    int               `json:"row"`
+	Type   string            `json:"type"`
+	ID     string            `json:"id,omitempty"`
+	Errors validation.Errors `json:"errors"`
+}
+
+// Summary counts the imported rows, those of a resumed run included.
+type Summary struct {
+	Proposals int        `json:"proposals"`
+	Comments  int        `json:"comments"`
+	Resumed   int        `json:"resumed_after_row,omitempty"`
+	Invalid   []RowError `json:"invalid,omitempty"`
+}
+
+// Checkpoint records how far an import got.
+type Checkpoint struct {
+	Input     string    `json:"input"`
+	Row       int       `json:"row"`
+	Proposals int       `json:"proposals"`
+	Comments  int       `json:"comments"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Run imports the proposals and comments read from r. Invalid rows are
+// skipped and listed in the summary, the comments of a skipped proposal with
+// it. A failed write stops the import, running it again with the same
+// checkpoint continues after the last proposal that was written completely.
+//
+// Proposals keep their created_at: a row whose id is not a timeuuid of that
+// instant gets one that is, derived from the original id so every run picks
+// the same. Imported rows therefore overwrite themselves when imported again.
+// Comments that follow their proposal are counted into its no_of_comments,
+// comments of proposals already stored add to the stored count.
+func Run(session *gocql.Session, r io.Reader, opts Options) (Summary, error) {
+	var rd reader
+	switch opts.Format {
+	case export.FormatCSV:
+		csvReader, err := newCSVReader(r)
+		if err != nil {
+			return Summary{}, err
+		}
+		rd = csvReader
+	case export.FormatNDJSON:
+		rd = newNDJSONReader(r)
+	default:
+		return Summary{}, export.ErrUnknownFormat
+	}
+
+	imp := &importer{
+		session:   session,
+		opts:      opts,
+		reader:    rd,
+		validator: validation.New(),
+	}
+
+	checkpoint := Checkpoint{Input: opts.Input}
+	if opts.Checkpoint != "" && !opts.DryRun {
+		var err error
+		checkpoint, err = loadCheckpoint(opts.Checkpoint, opts.Input)
+		if err != nil {
+			return Summary{}, err
+		}
+		imp.summary.Proposals = checkpoint.Proposals
+		imp.summary.Comments = checkpoint.Comments
+		imp.summary.Resumed = checkpoint.Row
+	}
+
+	for {
+		g, err := imp.nextGroup()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp.summary, err
+		}
+
+		if g.lastRow <= checkpoint.Row {
+			continue
+		}
+
+		if err := imp.importGroup(g); err != nil {
+			return imp.summary, fmt.Errorf("row %d: %w", g.firstRow, err)
+		}
+
+		if opts.Checkpoint != "" && !opts.DryRun {
+			checkpoint.Row = g.lastRow
+			checkpoint.Proposals = imp.summary.Proposals
+			checkpoint.Comments = imp.summary.Comments
+			if err := checkpoint.save(opts.Checkpoint); err != nil {
+				return imp.summary, err
+			}
+		}
+	}
+
+	if opts.Checkpoint != "" && !opts.DryRun {
+		if err := os.Remove(opts.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return imp.summary, err
+		}
+	}
+
+	return imp.summary, nil
+}
+
+type importer struct {
+	session   *gocql.Session
+	opts      Options
+	reader    reader
+	ahead     *record
+	validator *validation.Validator
+	summary   Summary
+}
+
+// group is a proposal with the comments that follow it, or comments of a
+// proposal that is not part of the input. A group is imported as a whole.
+type group struct {
+	proposal   *record
+	comments   []record
+	proposalID uuid.UUID
+	firstRow   int
+	lastRow    int
+}
+
+func (imp *importer) read() (record, error) {
+	if imp.ahead != nil {
+		rec := *imp.ahead
+		imp.ahead = nil
+		return rec, nil
+	}
+	return imp.reader.next()
+}
+
+func (imp *importer) nextGroup() (group, error) {
+	first, err := imp.read()
+	if err != nil {
+		return group{}, err
+	}
+
+	g := group{firstRow: first.row, lastRow: first.row}
+	switch {
+	case first.proposal != nil:
+		g.proposal = &first
+		g.proposalID = first.proposal.ID
+	case first.comment != nil:
+		g.comments = append(g.comments, first)
+		g.proposalID = first.comment.ProposalID
+	default:
+		// a row of an unknown type is a group of its own that imports nothing
+		imp.invalid(first.row, "", uuid.Nil, first.errs)
+		return g, nil
+	}
+
+	for {
+		next, err := imp.read()
+		if err == io.EOF {
+			return g, nil
+		}
+		if err != nil {
+			return g, err
+		}
+
+		if next.comment == nil || next.comment.ProposalID != g.proposalID {
+			imp.ahead = &next
+			return g, nil
+		}
+
+		g.comments = append(g.comments, next)
+		g.lastRow = next.row
+	}
+}
+
+func (imp *importer) importGroup(g group) error {
+	if g.proposal != nil {
+		return imp.importProposal(g)
+	}
+	if len(g.comments) > 0 {
+		return imp.importComments(g)
+	}
+	return nil
+}
+
+func (imp *importer) importProposal(g group) error {
+	rec := g.proposal
+	proposal := *rec.proposal
+
+	if proposal.Status == "" {
+		proposal.Status = entity.ProposalStatusOpen
+	}
+
+	errs := rec.errs
+	if len(errs) == 0 {
+		errs = imp.validate(&proposal)
+	}
+	if len(errs) > 0 {
+		imp.invalid(rec.row, "proposal", rec.proposal.ID, errs)
+		imp.skipComments(g.comments, fmt.Sprintf("belongs to the proposal in row %d that was not imported", rec.row))
+		return nil
+	}
+
+	proposal.CreatedAt, proposal.LastUpdated = timestamps(proposal.CreatedAt, proposal.LastUpdated)
+	proposal.ID = importID(rec.proposal.ID, proposal.CreatedAt, proposal.UserID.String(), proposal.Title)
+
+	comments := imp.prepareComments(g.comments, proposal)
+	if len(comments) > 0 {
+		proposal.NoOfComments = len(comments)
+	}
+
+	if !imp.opts.DryRun {
+		if err := proposalRepository.InsertProposal(imp.session, Actor, proposal); err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			if err := repository.InsertComment(imp.session, Actor, comment); err != nil {
+				return err
+			}
+		}
+	}
+
+	imp.summary.Proposals++
+	imp.summary.Comments += len(comments)
+	return nil
+}
+
+// importComments imports comments of a proposal that is already stored.
+func (imp *importer) importComments(g group) error {
+	var proposals []entity.Proposal
+	if g.proposalID != uuid.Nil {
+		var err error
+		proposals, err = proposalRepository.GetLatestProposal(imp.session, g.proposalID)
+		if err != nil {
+			return err
+		}
+	}
+	if len(proposals) == 0 {
+		imp.skipComments(g.comments, "belongs to a proposal that neither is stored nor precedes the comment")
+		return nil
+	}
+
+	comments := imp.prepareComments(g.comments, proposals[0])
+	if len(comments) == 0 {
+		return nil
+	}
+
+	if !imp.opts.DryRun {
+		for _, comment := range comments {
+			if err := repository.InsertComment(imp.session, Actor, comment); err != nil {
+				return err
+			}
+		}
+		// counted rather than added to, comments imported before are
+		// overwritten and would be counted twice
+		if _, err := consistency.ReconcileCommentCount(imp.session, proposals[0].ID); err != nil {
+			return err
+		}
+	}
+
+	imp.summary.Comments += len(comments)
+	return nil
+}
+
+// prepareComments attaches the comments to proposal and returns the valid
+// ones, ready to be written.
+func (imp *importer) prepareComments(recs []record, proposal entity.Proposal) []entity.Comment {
+	var comments []entity.Comment
+
+	for _, rec := range recs {
+		comment := *rec.comment
+		comment.ProposalID = proposal.ID
+		comment.UserPostedProposalID = proposal.UserID
+		comment.UserPostedUsername = proposal.Username
+
+		errs := rec.errs
+		if len(errs) == 0 {
+			errs = imp.validate(&comment)
+		}
+		if len(errs) > 0 {
+			imp.invalid(rec.row, "comment", rec.comment.CommentID, errs)
+			continue
+		}
+
+		comment.CreatedAt, comment.LastUpdated = timestamps(comment.CreatedAt, comment.LastUpdated)
+		comment.CommentID = importID(rec.comment.CommentID, comment.CreatedAt, rec.comment.ProposalID.String(), comment.UserCommentedID.String(), comment.CommentText)
+
+		comments = append(comments, comment)
+	}
+
+	return comments
+}
+
+func (imp *importer) skipComments(recs []record, message string) {
+	for _, rec := range recs {
+		imp.invalid(rec.row, "comment", rec.comment.CommentID, validation.Errors{{
+			Field:   "proposal_id",
+			Code:    CodeNotImported,
+			Message: message,
+		}})
+	}
+}
+
+func (imp *importer) validate(v interface{}) validation.Errors {
+	if err := imp.validator.Validate(v); err != nil {
+		if errs, ok := err.(validation.Errors); ok {
+			return errs
+		}
+		return validation.Errors{{Code: validation.CodeType, Message: err.Error()}}
+	}
+	return nil
+}
+
+func (imp *importer) invalid(row int, recordType string, id uuid.UUID, errs validation.Errors) {
+	rowError := RowError{Row: row, Type: recordType, Errors: errs}
+	if id != uuid.Nil {
+		rowError.ID = id.String()
+	}
+	imp.summary.Invalid = append(imp.summary.Invalid, rowError)
+}
+
+// timestamps truncates the times to the millisecond Cassandra stores. A
+// missing last_updated is the creation time.
+func timestamps(createdAt, lastUpdated time.Time) (time.Time, time.Time) {
+	createdAt = createdAt.UTC().Truncate(time.Millisecond)
+	if lastUpdated.IsZero() || lastUpdated.Before(createdAt) {
+		return createdAt, createdAt
+	}
+	return createdAt, lastUpdated.UTC().Truncate(time.Millisecond)
+}
+
+// importID returns original if it is a timeuuid of createdAt. Otherwise it
+// returns a timeuuid of createdAt whose clock sequence and node are hashed
+// from original and seed, so importing the same row again yields the same id.
+func importID(original uuid.UUID, createdAt time.Time, seed ...string) uuid.UUID {
+	if original.Version() == 1 && gocql.UUID(original).Time().Truncate(time.Millisecond).Equal(createdAt) {
+		return original
+	}
+
+	hash := sha256.New()
+	hash.Write(original[:])
+	for _, s := range seed {
+		io.WriteString(hash, s)
+		hash.Write([]byte{0})
+	}
+	sum := hash.Sum(nil)
+
+	clock := uint32(sum[0])<<8 | uint32(sum[1])
+	return uuid.UUID(gocql.TimeUUIDWith(gocql.MinTimeUUID(createdAt).Timestamp(), clock, sum[2:8]))
+}
+
+func loadCheckpoint(path, input string) (Checkpoint, error) {
+	checkpoint := Checkpoint{Input: input}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("reading the checkpoint: %w", err)
+	}
+	if checkpoint.Input != input {
+		return checkpoint, ErrCheckpointMismatch
+	}
+
+	return checkpoint, nil
+}
+
+// save replaces the checkpoint file in one step, so an interrupted save
+// leaves the previous checkpoint intact.
+func (c Checkpoint) save(path string) error {
+	c.UpdatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,33 @@
+package importer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+func TestImportID(t *testing.T) {
+	createdAt := time.Date(2021, 3, 4, 5, 6, 7, 8000000, time.UTC)
+
+	original := uuid.UUID(gocql.TimeUUIDWith(gocql.MinTimeUUID(createdAt).Timestamp(), 1, []byte{1, 2, 3, 4, 5, 6}))
+	if got := importID(original, createdAt, "seed"); got != original {
+		t.Errorf("importID() = %v, want the timeuuid of createdAt kept", got)
+	}
+
+	random := uuid.MustParse("6ba7b810-9dad-41d1-80b4-00c04fd430c8")
+	got := importID(random, createdAt, "seed")
+	if got.Version() != 1 || !gocql.UUID(got).Time().Equal(createdAt) {
+		t.Errorf("importID() = %v, want a timeuuid of %v", got, createdAt)
+	}
+	if again := importID(random, createdAt, "seed"); again != got {
+		t.Errorf("importID() = %v, then %v, want the same id", got, again)
+	}
+	if other := importID(random, createdAt, "other seed"); other == got {
+		t.Errorf("importID() ignores the seed")
+	}
+	if moved := importID(original, createdAt.Add(time.Second), "seed"); moved == original {
+		t.Errorf("importID() kept a timeuuid of another time")
+	}
+}
@@ -0,0 +1,219 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/daterange"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/export"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+)
+
+// record is a proposal or a comment read from the input, with the errors
+// found while reading its fields.
+type record struct {
+	row      int
+	proposal *entity.Proposal
+	comment  *entity.Comment
+	errs     validation.Errors
+}
+
+type reader interface {
+	// next returns io.EOF after the last record.
+	next() (record, error)
+}
+
+// csvReader reads the layout written by export: one row per proposal or
+// comment, told apart by record_type. Columns are found by their name in the
+// header, so files with fewer columns in any order work as well. Rows without
+// a record_type are proposals.
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+	row     int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	return &csvReader{r: reader, columns: columns, row: 1}, nil
+}
+
+func (c *csvReader) next() (record, error) {
+	fields, err := c.r.Read()
+	if err != nil {
+		return record{}, err
+	}
+	c.row++
+
+	p := fieldParser{columns: c.columns, fields: fields}
+	rec := record{row: c.row}
+
+	switch p.str("record_type") {
+	case "", "proposal":
+		rec.proposal = &entity.Proposal{
+			ID:           p.id("id"),
+			Title:        p.str("title"),
+			ProposalText: p.str("text"),
+			UserID:       p.id("user_id"),
+			Username:     p.str("username"),
+			FirstName:    p.str("firstname"),
+			LastName:     p.str("lastname"),
+			Status:       p.str("status"),
+			UpVotes:      p.number("upvotes"),
+			DownVotes:    p.number("downvotes"),
+			NoOfComments: p.number("no_of_comments"),
+			CreatedAt:    p.timestamp("created_at"),
+			LastUpdated:  p.timestamp("last_updated"),
+		}
+	case "comment":
+		rec.comment = &entity.Comment{
+			CommentID:             p.id("id"),
+			ProposalID:            p.id("proposal_id"),
+			CommentText:           p.str("text"),
+			UserCommentedID:       p.id("user_id"),
+			UserCommentedUsername: p.str("username"),
+			UpVotes:               p.number("upvotes"),
+			CreatedAt:             p.timestamp("created_at"),
+			LastUpdated:           p.timestamp("last_updated"),
+		}
+	default:
+		p.fail("record_type", "must be proposal or comment")
+	}
+
+	rec.errs = p.errs
+	return rec, nil
+}
+
+// fieldParser reads the typed fields of a CSV row, collecting the fields it
+// cannot read instead of stopping at the first.
+type fieldParser struct {
+	columns map[string]int
+	fields  []string
+	errs    validation.Errors
+}
+
+func (p *fieldParser) str(name string) string {
+	i, ok := p.columns[name]
+	if !ok || i >= len(p.fields) {
+		return ""
+	}
+	return p.fields[i]
+}
+
+func (p *fieldParser) id(name string) uuid.UUID {
+	value := p.str(name)
+	if value == "" {
+		return uuid.Nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		p.fail(name, "must be a valid UUID")
+	}
+	return id
+}
+
+func (p *fieldParser) number(name string) int {
+	value := p.str(name)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		p.fail(name, "must be a whole number of at least 0")
+	}
+	return n
+}
+
+func (p *fieldParser) timestamp(name string) time.Time {
+	value := p.str(name)
+	if value == "" {
+		return time.Time{}
+	}
+	t, _, err := daterange.ParseTime(value, time.UTC)
+	if err != nil {
+		p.fail(name, err.Error())
+	}
+	return t
+}
+
+func (p *fieldParser) fail(name, message string) {
+	p.errs = append(p.errs, validation.FieldError{Field: name, Code: validation.CodeType, Message: message})
+}
+
+// ndjsonReader reads the layout written by export: one proposal per line with
+// its comments embedded. The comments are returned as records of their own
+// following the proposal, sharing its line number.
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	row     int
+	pending []record
+}
+
+// maxLine bounds a single NDJSON line, a proposal with all its comments.
+const maxLine = 64 << 20
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (n *ndjsonReader) next() (record, error) {
+	if len(n.pending) > 0 {
+		rec := n.pending[0]
+		n.pending = n.pending[1:]
+		return rec, nil
+	}
+
+	for n.scanner.Scan() {
+		n.row++
+		text := strings.TrimSpace(n.scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var line export.Record
+		if err := json.Unmarshal([]byte(text), &line); err != nil {
+			return record{row: n.row, proposal: &entity.Proposal{}, errs: validation.Errors{{
+				Code:    validation.CodeType,
+				Message: "is not a valid JSON object: " + err.Error(),
+			}}}, nil
+		}
+
+		proposal := line.Proposal
+		for i := range line.Comments {
+			comment := line.Comments[i]
+			if comment.ProposalID == uuid.Nil {
+				comment.ProposalID = proposal.ID
+			}
+			n.pending = append(n.pending, record{row: n.row, comment: &comment})
+		}
+
+		return record{row: n.row, proposal: &proposal}, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return record{}, err
+	}
+	return record{}, io.EOF
+}
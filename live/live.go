@@ -0,0 +1,364 @@
+// Package live pushes the comments and vote counts of a proposal to the
+// clients watching it. The hub keeps the latest messages of every proposal,
+// so a client that reconnects gets what it missed.
+package live
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/events"
+)
+
+// Messages pushed to the clients.
+const (
+	CommentCreated  = "comment.created"
+	CommentUpdated  = "comment.updated"
+	CommentDeleted  = "comment.deleted"
+	CommentsCleared = "comments.cleared"
+	VotesChanged    = "votes"
+	ProposalDeleted = "proposal.deleted"
+	// Reset tells a client that messages it asked for are no longer kept, it
+	// has to load the proposal again.
+	Reset = "reset"
+)
+
+var ErrTooManyListeners = errors.New("too many clients are watching proposals, try again later")
+
+// Message is pushed to the clients watching a proposal. Comment is set for
+// the comment messages carrying a comment, Data for the others.
+type Message struct {
+	ID      string
+	Event   string
+	Comment *entity.Comment
+	Data    interface{}
+}
+
+// ProposalRef names a deleted proposal, or one whose comments were deleted.
+type ProposalRef struct {
+	ProposalID uuid.UUID `json:"proposal_id"`
+}
+
+// CommentRef names a removed comment.
+type CommentRef struct {
+	ProposalID uuid.UUID `json:"proposal_id"`
+	CommentID  uuid.UUID `json:"id"`
+}
+
+// Votes are the vote counts of a proposal or, with CommentID set, a comment.
+type Votes struct {
+	ProposalID uuid.UUID `json:"proposal_id"`
+	CommentID  uuid.UUID `json:"comment_id,omitempty"`
+	UpVotes    int       `json:"upvotes"`
+	DownVotes  int       `json:"downvotes"`
+}
+
+// Config tunes the hub.
+type Config struct {
+	// Buffer is how many messages are kept per proposal for reconnecting
+	// clients.
+	Buffer int
+	// ListenerBuffer is how many messages may wait for a client. A client
+	// falling further behind is disconnected and resumes when it reconnects.
+	ListenerBuffer int
+	// MaxListeners caps the clients watching proposals at the same time.
+	MaxListeners int
+	// IdleTopic is how long the messages of a proposal nobody watches are
+	// kept.
+	IdleTopic time.Duration
+}
+
+// Default is the configuration controllers build their hub from.
+var Default = Config{
+	Buffer:         100,
+	ListenerBuffer: 64,
+	MaxListeners:   10000,
+	IdleTopic:      10 * time.Minute,
+}
+
+// Hub hands the messages of every proposal to the clients watching it.
+type Hub struct {
+	config Config
+	// epoch tells message ids of this process from those of an earlier one
+	epoch string
+
+	mu        sync.Mutex
+	seq       uint64
+	topics    map[uuid.UUID]*topic
+	listeners int
+	swept     time.Time
+
+	start        sync.Once
+	subscription *events.Subscription
+}
+
+// topic holds the latest messages of a proposal in a ring buffer.
+type topic struct {
+	ring  []Message
+	seqs  []uint64
+	next  int
+	count int
+	// evicted is the highest sequence number no longer kept
+	evicted   uint64
+	listeners map[chan Message]bool
+	active    time.Time
+}
+
+// New returns an empty hub.
+func New(config Config) *Hub {
+	return &Hub{
+		config: config,
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		topics: map[uuid.UUID]*topic{},
+	}
+}
+
+// Start pushes the published events from now on. Starting again returns the
+// running subscription.
+func (h *Hub) Start() *events.Subscription {
+	h.start.Do(func() {
+		h.subscription = events.Subscribe("live", events.DefaultBuffer, h.Handle)
+	})
+
+	return h.subscription
+}
+
+// Handle pushes the messages of one event.
+func (h *Hub) Handle(event events.Event) {
+	if event.TargetType == entity.AuditTargetComment {
+		h.handleComment(event)
+		return
+	}
+
+	switch event.Action {
+	case entity.AuditUpvote, entity.AuditDownvote:
+		if proposal, ok := event.After.(entity.Proposal); ok {
+			h.Publish(proposal.ID, VotesChanged, nil, Votes{ProposalID: proposal.ID, UpVotes: proposal.UpVotes, DownVotes: proposal.DownVotes})
+		}
+	case entity.AuditDelete, entity.AuditHide:
+		h.Forget(event.ProposalID, uuid.Nil)
+		h.Publish(event.ProposalID, ProposalDeleted, nil, ProposalRef{ProposalID: event.ProposalID})
+	case entity.AuditDeleteAll:
+		for _, proposalID := range h.watched() {
+			h.Forget(proposalID, uuid.Nil)
+			h.Publish(proposalID, ProposalDeleted, nil, ProposalRef{ProposalID: proposalID})
+		}
+	}
+}
+
+func (h *Hub) handleComment(event events.Event) {
+	after, _ := event.After.(entity.Comment)
+	ref := CommentRef{ProposalID: event.ProposalID, CommentID: event.CommentID}
+
+	switch event.Action {
+	case entity.AuditCreate, entity.AuditImport, entity.AuditUnhide:
+		if !after.Hidden {
+			h.Publish(event.ProposalID, CommentCreated, &after, nil)
+		}
+	case entity.AuditUpdate:
+		if !after.Hidden {
+			h.Publish(event.ProposalID, CommentUpdated, &after, nil)
+			return
+		}
+		// an edit held for review takes the comment down until it is
+		// approved
+		if before, ok := event.Before.(entity.Comment); ok && !before.Hidden {
+			h.Forget(event.ProposalID, event.CommentID)
+			h.Publish(event.ProposalID, CommentDeleted, nil, ref)
+		}
+	case entity.AuditUpvote:
+		h.Publish(event.ProposalID, VotesChanged, nil, Votes{ProposalID: after.ProposalID, CommentID: after.CommentID, UpVotes: after.UpVotes})
+	case entity.AuditDelete, entity.AuditHide:
+		h.Forget(event.ProposalID, event.CommentID)
+		if event.CommentID == uuid.Nil {
+			h.Publish(event.ProposalID, CommentsCleared, nil, ProposalRef{ProposalID: event.ProposalID})
+			return
+		}
+		h.Publish(event.ProposalID, CommentDeleted, nil, ref)
+	case entity.AuditDeleteAll:
+		for _, proposalID := range h.watched() {
+			h.Forget(proposalID, uuid.Nil)
+			h.Publish(proposalID, CommentsCleared, nil, ProposalRef{ProposalID: proposalID})
+		}
+	}
+}
+
+// Publish keeps a message for the proposal and pushes it to the clients
+// watching it.
+func (h *Hub) Publish(proposalID uuid.UUID, event string, comment *entity.Comment, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(proposalID)
+
+	h.seq++
+	message := Message{ID: h.id(h.seq), Event: event, Comment: comment, Data: data}
+
+	if t.count == len(t.ring) {
+		t.evicted = t.seqs[t.next]
+	} else {
+		t.count++
+	}
+	t.ring[t.next] = message
+	t.seqs[t.next] = h.seq
+	t.next = (t.next + 1) % len(t.ring)
+	t.active = time.Now()
+
+	for listener := range t.listeners {
+		select {
+		case listener <- message:
+		default:
+			// too far behind, the client resumes from its last message
+			delete(t.listeners, listener)
+			close(listener)
+			h.listeners--
+		}
+	}
+}
+
+// Forget drops the kept messages carrying the comment, or with commentID nil
+// every comment of the proposal, so reconnecting clients are not sent a
+// comment that was removed.
+func (h *Hub) Forget(proposalID, commentID uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[proposalID]
+	if !ok || t.count == 0 {
+		return
+	}
+
+	ring := make([]Message, len(t.ring))
+	seqs := make([]uint64, len(t.seqs))
+	kept := 0
+	for i := 0; i < t.count; i++ {
+		j := (t.next - t.count + i + len(t.ring)) % len(t.ring)
+		if comment := t.ring[j].Comment; comment != nil && (commentID == uuid.Nil || comment.CommentID == commentID) {
+			continue
+		}
+		ring[kept] = t.ring[j]
+		seqs[kept] = t.seqs[j]
+		kept++
+	}
+
+	t.ring = ring
+	t.seqs = seqs
+	t.count = kept
+	t.next = kept % len(ring)
+}
+
+// Listen registers a client watching the proposal. With lastEventID set the
+// messages after it are returned as well. complete is false if some of them
+// are no longer kept, the client should then load the proposal again. The
+// channel is closed when the client falls behind or stop is called.
+func (h *Hub) Listen(proposalID uuid.UUID, lastEventID string) (missed []Message, complete bool, messages <-chan Message, stop func(), err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.listeners >= h.config.MaxListeners {
+		return nil, false, nil, nil, ErrTooManyListeners
+	}
+
+	t := h.topic(proposalID)
+	complete = true
+	if lastEventID != "" {
+		last, ok := h.parseID(lastEventID)
+		// a client missing messages loads the proposal again, the kept ones
+		// would only repeat what it loads
+		complete = ok && last >= t.evicted
+		for i := 0; complete && i < t.count; i++ {
+			j := (t.next - t.count + i + len(t.ring)) % len(t.ring)
+			if t.seqs[j] > last {
+				missed = append(missed, t.ring[j])
+			}
+		}
+	}
+
+	listener := make(chan Message, h.config.ListenerBuffer)
+	t.listeners[listener] = true
+	t.active = time.Now()
+	h.listeners++
+
+	stop = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if t.listeners[listener] {
+			delete(t.listeners, listener)
+			close(listener)
+			h.listeners--
+		}
+		t.active = time.Now()
+	}
+
+	return missed, complete, listener, stop, nil
+}
+
+// topic returns the topic of the proposal, creating it if needed. h.mu must
+// be held.
+func (h *Hub) topic(proposalID uuid.UUID) *topic {
+	if t, ok := h.topics[proposalID]; ok {
+		return t
+	}
+
+	h.sweep()
+	t := &topic{
+		ring: make([]Message, h.config.Buffer),
+		seqs: make([]uint64, h.config.Buffer),
+		// the proposal's messages from before may have been swept
+		evicted:   h.seq,
+		listeners: map[chan Message]bool{},
+		active:    time.Now(),
+	}
+	h.topics[proposalID] = t
+
+	return t
+}
+
+// sweep drops the topics nobody watched for a while, at most once a minute.
+// h.mu must be held.
+func (h *Hub) sweep() {
+	now := time.Now()
+	if now.Sub(h.swept) < time.Minute {
+		return
+	}
+	h.swept = now
+
+	for proposalID, t := range h.topics {
+		if len(t.listeners) == 0 && now.Sub(t.active) > h.config.IdleTopic {
+			delete(h.topics, proposalID)
+		}
+	}
+}
+
+func (h *Hub) watched() []uuid.UUID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var proposalIDs []uuid.UUID
+	for proposalID := range h.topics {
+		proposalIDs = append(proposalIDs, proposalID)
+	}
+
+	return proposalIDs
+}
+
+func (h *Hub) id(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// parseID returns the sequence number of a message id of this process.
+func (h *Hub) parseID(id string) (uint64, bool) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 || id[:i] != h.epoch {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	return seq, err == nil
+}
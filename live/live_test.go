@@ -0,0 +1,50 @@
+package live
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/events"
+)
+
+func TestHeldCommentsAreNotPushed(t *testing.T) {
+	h := New(Default)
+	proposalID := uuid.New()
+	comment := entity.Comment{ProposalID: proposalID, CommentID: uuid.New(), Hidden: true}
+
+	h.Handle(events.Event{TargetType: entity.AuditTargetComment, Action: entity.AuditCreate, ProposalID: proposalID, CommentID: comment.CommentID, After: comment})
+
+	missed, complete, _, stop, err := h.Listen(proposalID, h.id(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if !complete || len(missed) != 0 {
+		t.Errorf("Listen() = %v, %v, want no messages", missed, complete)
+	}
+}
+
+func TestRemovedCommentsAreNotReplayed(t *testing.T) {
+	h := New(Default)
+	proposalID := uuid.New()
+	kept := entity.Comment{ProposalID: proposalID, CommentID: uuid.New()}
+	removed := entity.Comment{ProposalID: proposalID, CommentID: uuid.New()}
+
+	for _, comment := range []entity.Comment{kept, removed} {
+		h.Handle(events.Event{TargetType: entity.AuditTargetComment, Action: entity.AuditCreate, ProposalID: proposalID, CommentID: comment.CommentID, After: comment})
+	}
+	h.Handle(events.Event{TargetType: entity.AuditTargetComment, Action: entity.AuditHide, ProposalID: proposalID, CommentID: removed.CommentID})
+
+	missed, complete, _, stop, err := h.Listen(proposalID, h.id(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if !complete || len(missed) != 2 {
+		t.Fatalf("Listen() = %v, %v, want the kept comment and the deletion", missed, complete)
+	}
+	if missed[0].Comment == nil || missed[0].Comment.CommentID != kept.CommentID || missed[1].Event != CommentDeleted {
+		t.Errorf("Listen() = %+v", missed)
+	}
+}
@@ -0,0 +1,347 @@
+// Package markup renders the Markdown of proposals and comments to HTML that
+// is safe to insert into a page, and to plain text.
+//
+// Only a subset of Markdown is supported: paragraphs, headings, emphasis,
+// inline code and code blocks, block quotes, lists, horizontal rules and
+// links. The source is escaped before it is rendered, so HTML written in it
+// is shown as text, and links only keep http, https and mailto URLs. Line
+// breaks inside a paragraph are kept.
+package markup
+
+import (
+	"errors"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formats the text of a proposal or comment can be read in.
+const (
+	// FormatMarkdown is the source as it was written.
+	FormatMarkdown = "markdown"
+	// FormatHTML is the sanitized HTML rendering.
+	FormatHTML = "html"
+	// FormatPlain is the text without markup.
+	FormatPlain = "plain"
+)
+
+var ErrUnknownFormat = errors.New("format must be markdown, html or plain")
+
+// ParseFormat validates a format query parameter. An empty one means
+// FormatMarkdown.
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return FormatMarkdown, nil
+	case FormatMarkdown, FormatHTML, FormatPlain:
+		return format, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Sanitize normalizes text before it is stored: line endings become \n,
+// control characters and the bidi overrides and isolates that can make text
+// display differently from how it reads are dropped, and surrounding
+// whitespace is trimmed. Other formatting characters stay, e.g. the zero
+// width joiners of emoji sequences and Persian or Indic text.
+func Sanitize(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	text = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || bidiControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	return strings.TrimSpace(text)
+}
+
+// bidiControl reports whether r is one of the bidi embeddings, overrides
+// (U+202A-U+202E) or isolates (U+2066-U+2069).
+func bidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// MaxQuoteDepth is how deep block quotes nest. Further > markers are kept
+// as text, so deeply nested quotes cannot make rendering slow.
+const MaxQuoteDepth = 8
+
+// HTML renders the Markdown source to sanitized HTML.
+func HTML(source string) string {
+	return renderBlocks(strings.Split(Sanitize(source), "\n"), 0)
+}
+
+// Render renders the Markdown source to sanitized HTML and to plain text,
+// for storing both next to the source.
+func Render(source string) (rendered, plain string) {
+	rendered = HTML(source)
+	return rendered, PlainFromHTML(rendered)
+}
+
+// Escape returns the source as escaped HTML without rendering its markup,
+// for text whose rendering is not stored yet.
+func Escape(source string) string {
+	return "<p>" + strings.ReplaceAll(html.EscapeString(source), "\n", "<br>\n") + "</p>"
+}
+
+var tag = regexp.MustCompile(`<[^>]*>`)
+
+// Plain renders the Markdown source to text without markup, a line per
+// line of text or list item. Links are reduced to their text.
+func Plain(source string) string {
+	return PlainFromHTML(HTML(source))
+}
+
+// PlainFromHTML reduces HTML rendered by HTML to the text Plain returns.
+func PlainFromHTML(rendered string) string {
+	text := tag.ReplaceAllString(rendered, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+
+	return strings.Join(kept, "\n")
+}
+
+var (
+	heading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+	rule        = regexp.MustCompile(`^(?:-\s*){3,}$|^(?:\*\s*){3,}$|^(?:_\s*){3,}$`)
+	bullet      = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	numbered    = regexp.MustCompile(`^\s*(\d{1,9})[.)]\s+(.*)$`)
+	quote       = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	fence       = regexp.MustCompile("^\\s*```")
+	indentation = regexp.MustCompile(`^\s*`)
+)
+
+// renderBlocks renders lines of Markdown, one HTML block per line of output.
+// depth is the number of block quotes the lines are in.
+func renderBlocks(lines []string, depth int) string {
+	var blocks []string
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, "<p>"+renderInlines(strings.Join(paragraph, "\n"))+"</p>")
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+
+		case fence.MatchString(line):
+			flush()
+			var code []string
+			for i++; i < len(lines) && !fence.MatchString(lines[i]); i++ {
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, "<pre><code>"+html.EscapeString(strings.Join(code, "\n"))+"</code></pre>")
+
+		case heading.MatchString(line):
+			flush()
+			match := heading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(match[1]))
+			blocks = append(blocks, "<h"+level+">"+renderInlines(match[2])+"</h"+level+">")
+
+		case rule.MatchString(strings.TrimSpace(line)):
+			flush()
+			blocks = append(blocks, "<hr>")
+
+		case depth < MaxQuoteDepth && quote.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && quote.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quote.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			blocks = append(blocks, "<blockquote>"+renderBlocks(quoted, depth+1)+"</blockquote>")
+
+		case bullet.MatchString(line), numbered.MatchString(line):
+			flush()
+			ordered := numbered.MatchString(line)
+			var items []string
+			for ; i < len(lines); i++ {
+				if match := listItem(lines[i], ordered); match != "" {
+					items = append(items, match)
+				} else if len(items) > 0 && strings.TrimSpace(lines[i]) != "" && len(indentation.FindString(lines[i])) > 0 {
+					// an indented line continues the item above
+					items[len(items)-1] += "\n" + strings.TrimSpace(lines[i])
+				} else {
+					break
+				}
+			}
+			i--
+			blocks = append(blocks, renderList(items, ordered, line))
+
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+
+	return strings.Join(blocks, "\n")
+}
+
+// listItem returns the text of line if it is an item of the kind of list,
+// or "".
+func listItem(line string, ordered bool) string {
+	if ordered {
+		if match := numbered.FindStringSubmatch(line); match != nil {
+			return match[2]
+		}
+		return ""
+	}
+	if match := bullet.FindStringSubmatch(line); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+func renderList(items []string, ordered bool, first string) string {
+	open, closing := "<ul>", "</ul>"
+	if ordered {
+		open, closing = "<ol>", "</ol>"
+		// a list keeps the number it starts at
+		if start, _ := strconv.Atoi(numbered.FindStringSubmatch(first)[1]); start != 1 {
+			open = `<ol start="` + strconv.Itoa(start) + `">`
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(open + "\n")
+	for _, item := range items {
+		b.WriteString("<li>" + renderInlines(item) + "</li>\n")
+	}
+	b.WriteString(closing)
+
+	return b.String()
+}
+
+var (
+	codeSpan = regexp.MustCompile("`([^`]+)`")
+	link     = regexp.MustCompile(`\[([^\]]+)\]\(\s*([^)\s]+)\s*\)`)
+	strong   = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	emphasis = regexp.MustCompile(`\*([^*\n]+)\*`)
+	// placeholder marks where a finished code span or link goes back in,
+	// Sanitize has removed every control character from the source
+	placeholder = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// renderInlines renders the emphasis, code spans and links of text.
+func renderInlines(text string) string {
+	var done []string
+	hold := func(rendered string) string {
+		done = append(done, rendered)
+		return "\x00" + strconv.Itoa(len(done)-1) + "\x00"
+	}
+
+	// code spans are taken out first, nothing inside them is markup
+	text = codeSpan.ReplaceAllStringFunc(text, func(span string) string {
+		return hold("<code>" + html.EscapeString(codeSpan.FindStringSubmatch(span)[1]) + "</code>")
+	})
+
+	text = html.EscapeString(text)
+
+	text = link.ReplaceAllStringFunc(text, func(match string) string {
+		parts := link.FindStringSubmatch(match)
+		label := renderEmphasis(parts[1])
+		href, ok := safeURL(html.UnescapeString(parts[2]))
+		if !ok {
+			return hold(label)
+		}
+		return hold(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer">` + label + `</a>`)
+	})
+
+	text = renderEmphasis(text)
+	text = strings.ReplaceAll(text, "\n", "<br>\n")
+
+	// a placeholder can contain another one, e.g. a code span in a link
+	for placeholder.MatchString(text) {
+		text = placeholder.ReplaceAllStringFunc(text, func(match string) string {
+			i, _ := strconv.Atoi(placeholder.FindStringSubmatch(match)[1])
+			return done[i]
+		})
+	}
+
+	return text
+}
+
+func renderEmphasis(text string) string {
+	text = strong.ReplaceAllStringFunc(text, func(match string) string {
+		parts := strong.FindStringSubmatch(match)
+		return "<strong>" + parts[1] + parts[2] + "</strong>"
+	})
+	return emphasis.ReplaceAllString(text, "<em>$1</em>")
+}
+
+// safeURL returns the link target if it is an absolute http, https or
+// mailto URL.
+func safeURL(target string) (string, bool) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+	default:
+		return "", false
+	}
+
+	return u.String(), true
+}
+
+// mention is an @username at the start of the text or after a character
+// that cannot be part of an email address.
+var mention = regexp.MustCompile(`(?:^|[^\w@.+-])@([A-Za-z0-9_](?:[A-Za-z0-9_.-]*[A-Za-z0-9_])?)`)
+
+// Mentions returns the usernames mentioned with @username in the Markdown
+// source, each once and in the order they first appear. Mentions inside code
+// are not counted.
+func Mentions(source string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+
+	inFence := false
+	for _, line := range strings.Split(Sanitize(source), "\n") {
+		if fence.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		line = codeSpan.ReplaceAllString(line, " ")
+		for _, match := range mention.FindAllStringSubmatch(line, -1) {
+			key := strings.ToLower(match[1])
+			if !seen[key] {
+				seen[key] = true
+				usernames = append(usernames, match[1])
+			}
+		}
+	}
+
+	return usernames
+}
@@ -0,0 +1,145 @@
+package markup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"paragraph", "hello\nworld", "<p>hello<br>\nworld</p>"},
+		{"emphasis", "**bold** and *em*", "<p><strong>bold</strong> and <em>em</em></p>"},
+		{"raw script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"script in heading", "# t <i>", "<h1>t &lt;i&gt;</h1>"},
+		{"script in code block", "```\n<script>\n```", "<pre><code>&lt;script&gt;</code></pre>"},
+		{"javascript link", "[x](javascript:alert(1))", "<p>x)</p>"},
+		{"javascript link mixed case", "[x](JaVaScRiPt:alert(1))", "<p>x)</p>"},
+		{"data link", "[x](data:text/html,hi)", "<p>x</p>"},
+		{"protocol relative link", "[x](//evil.com)", "<p>x</p>"},
+		{"https link", "[a](https://a.b)", `<p><a href="https://a.b" rel="nofollow noopener noreferrer">a</a></p>`},
+		{"mailto link", "[x](mailto:a@b.c)", `<p><a href="mailto:a@b.c" rel="nofollow noopener noreferrer">x</a></p>`},
+		{"double quote in href", `[x](http://a.b/"onmouseover=alert(1))`, `<p><a href="http://a.b/%22onmouseover=alert%281" rel="nofollow noopener noreferrer">x</a>)</p>`},
+		{"single quote in href", "[x](http://a.b/?q='a')", `<p><a href="http://a.b/?q=&#39;a&#39;" rel="nofollow noopener noreferrer">x</a></p>`},
+		{"code span in link", "[`<b>`](https://a.b)", `<p><a href="https://a.b" rel="nofollow noopener noreferrer"><code>&lt;b&gt;</code></a></p>`},
+		{"code span keeps markup", "`**a** [b](https://c.d)`", "<p><code>**a** [b](https://c.d)</code></p>"},
+		{"placeholder in text", "a \x001\x00 b", "<p>a 1 b</p>"},
+		{"placeholder in code span", "`\x000\x00`", "<p><code>0</code></p>"},
+		{"nested quote", "> a\n>> b", "<blockquote><p>a</p>\n<blockquote><p>b</p></blockquote></blockquote>"},
+		{"quote depth", strings.Repeat(">", MaxQuoteDepth+2) + " x", strings.Repeat("<blockquote>", MaxQuoteDepth) + "<p>&gt;&gt; x</p>" + strings.Repeat("</blockquote>", MaxQuoteDepth)},
+		{"ordered list start", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTML(tt.source); got != tt.want {
+				t.Errorf("HTML(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLDeepQuotes(t *testing.T) {
+	source := strings.Repeat(">", 10000)
+
+	start := time.Now()
+	HTML(source)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("rendering 10000 quote markers took %v", elapsed)
+	}
+}
+
+func TestPlain(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"markup removed", "# Title\n\n**bold** [link](https://a.b)", "Title\nbold link"},
+		{"entities unescaped", "a < b & c", "a < b & c"},
+		{"list items", "- a\n- b", "a\nb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Plain(tt.source); got != tt.want {
+				t.Errorf("Plain(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+			if rendered, plain := Render(tt.source); rendered != HTML(tt.source) || plain != tt.want {
+				t.Errorf("Render(%q) = %q, %q", tt.source, rendered, plain)
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"line endings", "a\r\nb\rc", "a\nb\nc"},
+		{"bidi override", "a\u202eb", "ab"},
+		{"bidi isolate", "a\u2066b\u2069", "ab"},
+		{"zero width joiner", "\U0001f469\u200d\U0001f4bb", "\U0001f469\u200d\U0001f4bb"},
+		{"zero width non-joiner", "\u0645\u06cc\u200c\u062e\u0648\u0627\u0647\u0645", "\u0645\u06cc\u200c\u062e\u0648\u0627\u0647\u0645"},
+		{"nul", "a\x00b", "ab"},
+		{"trimmed", "  a \n", "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.source); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"mentions", "hi @bob and @carol", []string{"bob", "carol"}},
+		{"once regardless of case", "@Bob @bob", []string{"Bob"}},
+		{"email address", "mail x@y.z", nil},
+		{"code span", "`@alice` @bob", []string{"bob"}},
+		{"code block", "```\n@alice\n```\n@bob", []string{"bob"}},
+		{"trailing dot", "thanks @bob.", []string{"bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Mentions(tt.source); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mentions(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{"", FormatMarkdown, false},
+		{"html", FormatHTML, false},
+		{"plain", FormatPlain, false},
+		{"pdf", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.format)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseFormat(%q) = %q, %v", tt.format, got, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	tokenSessionRepository "github.com/windswept321/smartest-city-roadmap-go/module/tokensession/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"gorm.io/gorm"
+)
+
+// MentionTable indexes comments by the users mentioned in them.
+const MentionTable = "comments_by_mentioned_user"
+
+// UsernameTTL is how long users_by_username remembers a username no user
+// posted with since, so the names of renamed users do not resolve forever.
+const UsernameTTL = 30 * 24 * time.Hour
+
+// RememberUser records the id behind a username, so mentions of it can be
+// resolved. Usernames are matched regardless of case. A user remembered under
+// another name before was renamed, and the old name is forgotten.
+func RememberUser(session *gocql.Session, userID uuid.UUID, username string) error {
+	key := strings.ToLower(username)
+
+	var previous string
+	err := session.Query(`SELECT username_key FROM usernames_by_user WHERE user_id=?;`, gocql.UUID(userID)).Scan(&previous)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	if previous != "" && previous != key {
+		err = session.Query(`DELETE FROM users_by_username WHERE username_key=?;`, previous).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	ttl := int(UsernameTTL.Seconds())
+	err = session.Query(`INSERT INTO users_by_username(username_key, username, user_id) VALUES (?, ?, ?) USING TTL ?;`,
+		key, username, gocql.UUID(userID), ttl).Exec()
+	if err != nil {
+		return err
+	}
+	forgetMiss(key)
+
+	return session.Query(`INSERT INTO usernames_by_user(user_id, username_key) VALUES (?, ?) USING TTL ?;`,
+		gocql.UUID(userID), key, ttl).Exec()
+}
+
+// UserLookup finds a user in the user store by username. found is false for
+// unknown users.
+type UserLookup func(username string) (id uuid.UUID, found bool, err error)
+
+// userLookup is consulted for the usernames missing from users_by_username.
+var userLookup UserLookup
+
+// SetUserLookup makes ResolveUsernames find the users that are not in
+// users_by_username yet with lookup. Modules set it once at startup.
+func SetUserLookup(lookup UserLookup) {
+	userLookup = lookup
+}
+
+// TokenSessionUserLookup finds users through the sessions of the user module.
+func TokenSessionUserLookup(tokenSessions tokenSessionRepository.TokenSessionRepository) UserLookup {
+	return func(username string) (uuid.UUID, bool, error) {
+		tokenSession, err := tokenSessions.GetOneFlexible("username", username)
+		if err == gorm.ErrRecordNotFound || (err == nil && (tokenSession == nil || tokenSession.UserID == uuid.Nil)) {
+			return uuid.Nil, false, nil
+		}
+		if err != nil {
+			return uuid.Nil, false, err
+		}
+
+		return tokenSession.UserID, true, nil
+	}
+}
+
+// MissTTL is how long a username the user store does not know is not looked
+// up again, so repeated mentions of it do not each query the store.
+const MissTTL = 10 * time.Minute
+
+var (
+	missesMu sync.Mutex
+	misses   = map[string]time.Time{}
+)
+
+// missed reports whether the user store did not know the username within
+// MissTTL.
+func missed(key string) bool {
+	missesMu.Lock()
+	defer missesMu.Unlock()
+
+	expires, ok := misses[key]
+	if ok && time.Now().After(expires) {
+		delete(misses, key)
+		return false
+	}
+	return ok
+}
+
+func rememberMiss(key string) {
+	missesMu.Lock()
+	misses[key] = time.Now().Add(MissTTL)
+	missesMu.Unlock()
+}
+
+// forgetMiss drops the cached miss of a username a user now goes by.
+func forgetMiss(key string) {
+	missesMu.Lock()
+	delete(misses, key)
+	missesMu.Unlock()
+}
+
+// ResolveUsernames returns the ids of the usernames that are known, in the
+// order of usernames. Unknown usernames are left out. Usernames missing from
+// users_by_username are looked up in the user store and remembered.
+func ResolveUsernames(session *gocql.Session, usernames []string) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+
+	for _, username := range usernames {
+		key := strings.ToLower(username)
+
+		var id gocql.UUID
+		err := session.Query(`SELECT user_id FROM users_by_username WHERE username_key=?;`, key).Scan(&id)
+		if err == nil {
+			ids = append(ids, uuid.UUID(id))
+			continue
+		}
+		if err != gocql.ErrNotFound {
+			return ids, err
+		}
+		if userLookup == nil || missed(key) {
+			continue
+		}
+
+		userID, found, err := userLookup(username)
+		if err != nil {
+			return ids, err
+		}
+		if !found {
+			rememberMiss(key)
+			continue
+		}
+		// the cache is best-effort, the next mention looks the user up again
+		_ = RememberUser(session, userID, username)
+		ids = append(ids, userID)
+	}
+
+	return ids, nil
+}
+
+// AddMention indexes the comment under the mentioned user.
+func AddMention(session *gocql.Session, userID uuid.UUID, comment entity.Comment) error {
+	return session.Query(`INSERT INTO comments_by_mentioned_user(user_id, created_at, comment_id, proposal_id,
+							author_id, author_username) VALUES (?, ?, ?, ?, ?, ?);`, gocql.UUID(userID), comment.CreatedAt,
+		gocql.UUID(comment.CommentID), gocql.UUID(comment.ProposalID), gocql.UUID(comment.UserCommentedID),
+		comment.UserCommentedUsername).Exec()
+}
+
+// RemoveMention removes the comment from the index of the user.
+func RemoveMention(session *gocql.Session, userID uuid.UUID, comment entity.Comment) error {
+	return session.Query(`DELETE FROM comments_by_mentioned_user WHERE user_id=? AND created_at=? AND comment_id=?;`,
+		gocql.UUID(userID), comment.CreatedAt, gocql.UUID(comment.CommentID)).Exec()
+}
+
+// GetMentions returns up to limit index entries of the user, newest first.
+// With before set only comments created before it are returned.
+func GetMentions(session *gocql.Session, userID uuid.UUID, before time.Time, limit int) ([]entity.Mention, error) {
+	var mentions []entity.Mention
+	var m = map[string]interface{}{}
+
+	query := session.Query(`SELECT * FROM comments_by_mentioned_user WHERE user_id=? LIMIT ?;`, gocql.UUID(userID), limit)
+	if !before.IsZero() {
+		query = session.Query(`SELECT * FROM comments_by_mentioned_user WHERE user_id=? AND created_at<? LIMIT ?;`,
+			gocql.UUID(userID), before, limit)
+	}
+	iter := query.Iter()
+
+	for iter.MapScan(m) {
+		mentions = append(mentions, entity.Mention{
+			UserID:         uuid.UUID(m["user_id"].(gocql.UUID)),
+			ProposalID:     uuid.UUID(m["proposal_id"].(gocql.UUID)),
+			CommentID:      uuid.UUID(m["comment_id"].(gocql.UUID)),
+			AuthorID:       uuid.UUID(m["author_id"].(gocql.UUID)),
+			AuthorUsername: m["author_username"].(string),
+			CreatedAt:      m["created_at"].(time.Time),
+		})
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return mentions, err
+}
+
+// DeleteAllMentions empties the mention index.
+func DeleteAllMentions(session *gocql.Session) error {
+	return session.Query(`TRUNCATE TABLE user_proposals_and_comments.comments_by_mentioned_user`).Exec()
+}
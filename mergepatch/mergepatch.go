@@ -0,0 +1,86 @@
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+)
+
+// ContentType is the media type of JSON Merge Patch documents (RFC 7396).
+const ContentType = "application/merge-patch+json"
+
+var (
+	ErrUnsupportedMediaType = errors.New("patch must be sent as " + ContentType + " or application/json")
+	ErrNotObject            = errors.New("patch must be a JSON object")
+)
+
+// Patch maps the members of a merge patch to their raw JSON values. A member
+// set to null asks for the field to be removed, an absent member leaves the
+// field unchanged.
+type Patch map[string]json.RawMessage
+
+// Decode reads a merge patch object from the request body.
+func Decode(r *http.Request) (Patch, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != ContentType && mediaType != "application/json") {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	var patch Patch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		return nil, ErrNotObject
+	}
+
+	return patch, nil
+}
+
+// Unknown returns the members of the patch that are not in allowed, sorted.
+func (p Patch) Unknown(allowed ...string) []string {
+	known := map[string]bool{}
+	for _, field := range allowed {
+		known[field] = true
+	}
+
+	var unknown []string
+	for field := range p {
+		if !known[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// String returns the string value of field. present is false when the patch
+// does not mention field, value is nil when the patch sets it to null.
+func (p Patch) String(field string) (value *string, present bool, err error) {
+	raw, ok := p[field]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, true, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, true, err
+	}
+
+	return &s, true, nil
+}
+
+// Unmarshal decodes the value of field into v if the patch mentions it.
+func (p Patch) Unmarshal(field string, v interface{}) (present bool, err error) {
+	raw, ok := p[field]
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, v)
+}
@@ -0,0 +1,89 @@
+package mergepatch
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     error
+	}{
+		{"merge patch", ContentType, `{"title":"a"}`, nil},
+		{"json", "application/json; charset=utf-8", `{"title":"a"}`, nil},
+		{"other media type", "text/plain", `{"title":"a"}`, ErrUnsupportedMediaType},
+		{"no media type", "", `{"title":"a"}`, ErrUnsupportedMediaType},
+		{"array", ContentType, `["title"]`, ErrNotObject},
+		{"null", ContentType, `null`, ErrNotObject},
+		{"broken", ContentType, `{"title":`, ErrNotObject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			_, err := Decode(r)
+			if err != tt.wantErr {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	patch := Patch{"title": nil, "votes": nil, "id": nil}
+
+	if got, want := patch.Unknown("title", "proposal_text"), []string{"id", "votes"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unknown() = %v, want %v", got, want)
+	}
+	if got := patch.Unknown("title", "votes", "id"); got != nil {
+		t.Errorf("Unknown() = %v, want none", got)
+	}
+}
+
+func TestString(t *testing.T) {
+	patch := Patch{
+		"title":  []byte(`"a"`),
+		"status": []byte(` null `),
+		"votes":  []byte(`3`),
+	}
+
+	value, present, err := patch.String("title")
+	if err != nil || !present || value == nil || *value != "a" {
+		t.Errorf("String(title) = %v, %v, %v", value, present, err)
+	}
+
+	value, present, err = patch.String("status")
+	if err != nil || !present || value != nil {
+		t.Errorf("String(status) = %v, %v, %v, want null", value, present, err)
+	}
+
+	value, present, err = patch.String("proposal_text")
+	if err != nil || present || value != nil {
+		t.Errorf("String(proposal_text) = %v, %v, %v, want absent", value, present, err)
+	}
+
+	if _, present, err = patch.String("votes"); err == nil || !present {
+		t.Errorf("String(votes) = %v, %v, want an error", present, err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	patch := Patch{"votes": []byte(`3`)}
+
+	var votes int
+	if present, err := patch.Unmarshal("votes", &votes); err != nil || !present || votes != 3 {
+		t.Errorf("Unmarshal(votes) = %v, %v, %d", present, err, votes)
+	}
+	if present, err := patch.Unmarshal("title", &votes); err != nil || present {
+		t.Errorf("Unmarshal(title) = %v, %v, want absent", present, err)
+	}
+}
@@ -0,0 +1,229 @@
+package moderation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/cascade"
+	commentsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/moderation/repository"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+var (
+	ErrAlreadyReported = errors.New("you already reported this")
+	ErrNotReported     = errors.New("nothing was reported about this")
+	ErrNotPending      = errors.New("the reports about this were already resolved")
+)
+
+// Report files the report about content written by authorID and queues the
+// content for moderation. Content a moderator hid or removed stays resolved
+// and only has the report counted, dismissed content is queued again.
+func Report(session *gocql.Session, report entity.Report, authorID uuid.UUID) (entity.ModerationItem, error) {
+	// Cassandra stores timestamps with millisecond precision, the queue is
+	// keyed by them
+	report.CreatedAt = time.Now().Truncate(time.Millisecond)
+
+	stored, err := repository.AddReport(session, report)
+	if err != nil {
+		return entity.ModerationItem{}, err
+	}
+	if !stored {
+		return entity.ModerationItem{}, ErrAlreadyReported
+	}
+
+	return enqueue(session, report, authorID)
+}
+
+// enqueue recounts the reports about the target of report and ranks it in
+// the queue by them.
+func enqueue(session *gocql.Session, report entity.Report, authorID uuid.UUID) (entity.ModerationItem, error) {
+	target := report.ProposalID
+	if report.CommentID != uuid.Nil {
+		target = report.CommentID
+	}
+
+	previous, err := repository.GetItem(session, target)
+	if err != nil {
+		return entity.ModerationItem{}, err
+	}
+
+	item := entity.ModerationItem{
+		TargetID:   target,
+		TargetType: report.TargetType,
+		ProposalID: report.ProposalID,
+		CommentID:  report.CommentID,
+		AuthorID:   authorID,
+		Status:     entity.ModerationPending,
+	}
+	if previous != nil {
+		item = *previous
+	}
+
+	// count the stored reports instead of incrementing, so concurrent
+	// reports cannot lose one
+	reports, err := repository.GetReports(session, target)
+	if err != nil {
+		return item, err
+	}
+	item.ReportCount = len(reports)
+	item.Reasons = make(map[string]int)
+	for _, r := range reports {
+		item.Reasons[r.Reason]++
+	}
+	item.LastReportedAt = report.CreatedAt
+
+	if item.Status == entity.ModerationResolved && (item.Action == entity.ModerationHide || item.Action == entity.ModerationRemove) {
+		return item, repository.SaveItem(session, item)
+	}
+	item.Status = entity.ModerationPending
+
+	// queue the new rank before removing the old one, so the item is never
+	// missing from the queue
+	if err := repository.Enqueue(session, item); err != nil {
+		return item, err
+	}
+	if previous != nil && previous.Status == entity.ModerationPending {
+		if err := repository.Dequeue(session, *previous); err != nil {
+			return item, err
+		}
+	}
+
+	return item, repository.SaveItem(session, item)
+}
+
+// Queue returns up to limit pending items, most reported first. Queue entries
+// left behind by a failed update are dropped as they are found.
+func Queue(session *gocql.Session, limit int) ([]entity.ModerationItem, error) {
+	entries, err := repository.GetQueue(session, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]entity.ModerationItem, 0, len(entries))
+	for _, entry := range entries {
+		item, err := repository.GetItem(session, entry.TargetID)
+		if err != nil {
+			return items, err
+		}
+
+		if item == nil || item.Status != entity.ModerationPending || item.ReportCount != entry.ReportCount || !item.LastReportedAt.Equal(entry.LastReportedAt) {
+			if err := repository.Dequeue(session, entry); err != nil {
+				return items, err
+			}
+			continue
+		}
+
+		items = append(items, *item)
+	}
+
+	return items, nil
+}
+
+// FilterActor is who content held by the content filter is hidden and
+// reported as.
+var FilterActor = entity.SystemActor("content-filter")
+
+// Hold queues content the content filter held for moderation, reported by
+// FilterActor with the filter's findings as details. The content must have
+// been stored hidden, dismissing the report shows it.
+func Hold(session *gocql.Session, targetType string, proposalID, commentID, authorID uuid.UUID, details string) (entity.ModerationItem, error) {
+	report := entity.Report{
+		TargetType:       targetType,
+		ProposalID:       proposalID,
+		CommentID:        commentID,
+		ReporterID:       FilterActor.UserID,
+		ReporterUsername: FilterActor.Username,
+		Reason:           entity.ReportContentFilter,
+		Details:          details,
+		CreatedAt:        time.Now().Truncate(time.Millisecond),
+	}
+
+	// content held again after an edit keeps its first report, but is
+	// queued again if that one was dismissed
+	if _, err := repository.AddReport(session, report); err != nil {
+		return entity.ModerationItem{}, err
+	}
+
+	return enqueue(session, report, authorID)
+}
+
+// Resolve applies the moderator's action to the pending content and takes it
+// off the queue. Hiding and removing content that is already gone still
+// resolves its reports.
+func Resolve(session *gocql.Session, moderator entity.Actor, targetID uuid.UUID, action, note string) (entity.ModerationItem, error) {
+	item, err := repository.GetItem(session, targetID)
+	if err != nil {
+		return entity.ModerationItem{}, err
+	}
+	if item == nil {
+		return entity.ModerationItem{}, ErrNotReported
+	}
+	if item.Status != entity.ModerationPending {
+		return *item, ErrNotPending
+	}
+
+	if err := apply(session, moderator, *item, action, note); err != nil {
+		return *item, err
+	}
+
+	if err := repository.Dequeue(session, *item); err != nil {
+		return *item, err
+	}
+
+	resolved := *item
+	resolved.Status = entity.ModerationResolved
+	resolved.Action = action
+	resolved.ModeratorID = moderator.UserID
+	resolved.ModeratorUsername = moderator.Username
+	resolved.Note = note
+	resolved.ResolvedAt = time.Now().Truncate(time.Millisecond)
+
+	return resolved, repository.SaveItem(session, resolved)
+}
+
+func apply(session *gocql.Session, moderator entity.Actor, item entity.ModerationItem, action, note string) error {
+	var err error
+
+	switch action {
+	case entity.ModerationHide, entity.ModerationDismiss:
+		// dismissing shows content again that the content filter held
+		hidden := action == entity.ModerationHide
+		if item.CommentID != uuid.Nil {
+			_, err = commentsRepository.SetCommentHidden(session, moderator, item.ProposalID, item.CommentID, hidden)
+		} else {
+			_, err = proposalRepository.SetProposalHidden(session, moderator, item.ProposalID, hidden)
+		}
+
+	case entity.ModerationRemove:
+		if item.CommentID != uuid.Nil {
+			err = commentsRepository.DeleteCommentByID(session, moderator, item.ProposalID, item.CommentID)
+			if err == nil {
+				// the reconciliation corrects a count that failed to update
+				_ = proposalRepository.SubtractFromNumberOfComments(session, item.ProposalID)
+			}
+		} else {
+			_, err = cascade.DeleteProposal(session, moderator, item.ProposalID)
+		}
+
+	case entity.ModerationWarn:
+		err = repository.AddWarning(session, entity.Warning{
+			UserID:            item.AuthorID,
+			ID:                uuid.UUID(gocql.TimeUUID()),
+			ModeratorID:       moderator.UserID,
+			ModeratorUsername: moderator.Username,
+			Reason:            note,
+			TargetType:        item.TargetType,
+			ProposalID:        item.ProposalID,
+			CommentID:         item.CommentID,
+		})
+	}
+
+	if err == proposalRepository.ErrProposalNotFound || err == commentsRepository.ErrCommentNotFound {
+		return nil
+	}
+	return err
+}
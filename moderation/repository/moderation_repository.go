@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+// targetID is the comment a report is about, or the proposal if it is about
+// a proposal.
+func targetID(proposalID, commentID uuid.UUID) uuid.UUID {
+	if commentID != uuid.Nil {
+		return commentID
+	}
+	return proposalID
+}
+
+// AddReport stores the report unless the reporter already reported the same
+// target, and returns whether it was stored.
+func AddReport(session *gocql.Session, report entity.Report) (bool, error) {
+	return session.Query(`INSERT INTO content_reports(target_id, reporter_id, reporter_username, target_type,
+							proposal_id, comment_id, reason, details, created_at) VALUES
+							(?, ?, ?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS;`, gocql.UUID(targetID(report.ProposalID, report.CommentID)),
+		gocql.UUID(report.ReporterID), report.ReporterUsername, report.TargetType, gocql.UUID(report.ProposalID),
+		gocql.UUID(report.CommentID), report.Reason, report.Details, report.CreatedAt).MapScanCAS(map[string]interface{}{})
+}
+
+// GetReports returns every report about the target.
+func GetReports(session *gocql.Session, targetID uuid.UUID) ([]entity.Report, error) {
+	var reports []entity.Report
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM content_reports WHERE target_id=?;`, gocql.UUID(targetID)).Iter()
+
+	for iter.MapScan(m) {
+		reports = append(reports, entity.Report{
+			TargetType:       m["target_type"].(string),
+			ProposalID:       uuid.UUID(m["proposal_id"].(gocql.UUID)),
+			CommentID:        uuid.UUID(m["comment_id"].(gocql.UUID)),
+			ReporterID:       uuid.UUID(m["reporter_id"].(gocql.UUID)),
+			ReporterUsername: m["reporter_username"].(string),
+			Reason:           m["reason"].(string),
+			Details:          m["details"].(string),
+			CreatedAt:        m["created_at"].(time.Time),
+		})
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return reports, err
+}
+
+// GetItem returns the moderation state of the target, or nil if it was
+// never reported.
+func GetItem(session *gocql.Session, targetID uuid.UUID) (*entity.ModerationItem, error) {
+	var item *entity.ModerationItem
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM moderation_by_target WHERE target_id=?;`, gocql.UUID(targetID)).Iter()
+
+	for iter.MapScan(m) {
+		scanned := itemFromMap(m)
+		item = &scanned
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return item, err
+}
+
+func itemFromMap(m map[string]interface{}) entity.ModerationItem {
+	reasons, _ := m["reasons"].(map[string]int)
+
+	return entity.ModerationItem{
+		TargetID:          uuid.UUID(m["target_id"].(gocql.UUID)),
+		TargetType:        m["target_type"].(string),
+		ProposalID:        uuid.UUID(m["proposal_id"].(gocql.UUID)),
+		CommentID:         uuid.UUID(m["comment_id"].(gocql.UUID)),
+		AuthorID:          uuid.UUID(m["author_id"].(gocql.UUID)),
+		Status:            m["status"].(string),
+		ReportCount:       m["report_count"].(int),
+		Reasons:           reasons,
+		LastReportedAt:    m["last_reported_at"].(time.Time),
+		Action:            m["action"].(string),
+		ModeratorID:       uuid.UUID(m["moderator_id"].(gocql.UUID)),
+		ModeratorUsername: m["moderator_username"].(string),
+		Note:              m["note"].(string),
+		ResolvedAt:        m["resolved_at"].(time.Time),
+	}
+}
+
+// SaveItem overwrites the moderation state of the item's target.
+func SaveItem(session *gocql.Session, item entity.ModerationItem) error {
+	return session.Query(`INSERT INTO moderation_by_target(target_id, target_type, proposal_id, comment_id, author_id,
+							status, report_count, reasons, last_reported_at, action, moderator_id, moderator_username,
+							note, resolved_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		gocql.UUID(item.TargetID), item.TargetType, gocql.UUID(item.ProposalID), gocql.UUID(item.CommentID),
+		gocql.UUID(item.AuthorID), item.Status, item.ReportCount, item.Reasons, item.LastReportedAt, item.Action,
+		gocql.UUID(item.ModeratorID), item.ModeratorUsername, item.Note, item.ResolvedAt).Exec()
+}
+
+// DeleteItem removes the moderation state of the item's target together with
+// its queue entry.
+func DeleteItem(session *gocql.Session, item entity.ModerationItem) error {
+	if err := Dequeue(session, item); err != nil {
+		return err
+	}
+
+	return session.Query(`DELETE FROM moderation_by_target WHERE target_id=?;`, gocql.UUID(item.TargetID)).Exec()
+}
+
+// DeleteReports removes every report about the target.
+func DeleteReports(session *gocql.Session, targetID uuid.UUID) error {
+	return session.Query(`DELETE FROM content_reports WHERE target_id=?;`, gocql.UUID(targetID)).Exec()
+}
+
+// Enqueue adds the item to the moderation queue, ranked by its report count
+// and last report.
+func Enqueue(session *gocql.Session, item entity.ModerationItem) error {
+	return session.Query(`INSERT INTO moderation_queue(status, report_count, last_reported_at, target_id,
+							target_type, proposal_id, comment_id) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		entity.ModerationPending, item.ReportCount, item.LastReportedAt, gocql.UUID(item.TargetID),
+		item.TargetType, gocql.UUID(item.ProposalID), gocql.UUID(item.CommentID)).Exec()
+}
+
+// Dequeue removes the queue entry of the item as it was ranked, i.e. with
+// the report count and last report it was enqueued with.
+func Dequeue(session *gocql.Session, item entity.ModerationItem) error {
+	return session.Query(`DELETE FROM moderation_queue
+							WHERE status=? AND report_count=? AND last_reported_at=? AND target_id=?;`,
+		entity.ModerationPending, item.ReportCount, item.LastReportedAt, gocql.UUID(item.TargetID)).Exec()
+}
+
+// GetQueue returns up to limit queue entries, most reported first and, with
+// equal counts, most recently reported first. Only the fields of the queue
+// are set, the target's moderation_by_target row holds the rest.
+func GetQueue(session *gocql.Session, limit int) ([]entity.ModerationItem, error) {
+	var items []entity.ModerationItem
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM moderation_queue WHERE status=? LIMIT ?;`, entity.ModerationPending, limit).Iter()
+
+	for iter.MapScan(m) {
+		items = append(items, entity.ModerationItem{
+			TargetID:       uuid.UUID(m["target_id"].(gocql.UUID)),
+			TargetType:     m["target_type"].(string),
+			ProposalID:     uuid.UUID(m["proposal_id"].(gocql.UUID)),
+			CommentID:      uuid.UUID(m["comment_id"].(gocql.UUID)),
+			Status:         entity.ModerationPending,
+			ReportCount:    m["report_count"].(int),
+			LastReportedAt: m["last_reported_at"].(time.Time),
+		})
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return items, err
+}
+
+// AddWarning stores a warning to a user.
+func AddWarning(session *gocql.Session, warning entity.Warning) error {
+	return session.Query(`INSERT INTO user_warnings(user_id, id, moderator_id, moderator_username, reason,
+							target_type, proposal_id, comment_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`,
+		gocql.UUID(warning.UserID), gocql.UUID(warning.ID), gocql.UUID(warning.ModeratorID), warning.ModeratorUsername,
+		warning.Reason, warning.TargetType, gocql.UUID(warning.ProposalID), gocql.UUID(warning.CommentID)).Exec()
+}
+
+// GetWarnings returns the warnings of the user, newest first.
+func GetWarnings(session *gocql.Session, userID uuid.UUID) ([]entity.Warning, error) {
+	var warnings []entity.Warning
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM user_warnings WHERE user_id=?;`, gocql.UUID(userID)).Iter()
+
+	for iter.MapScan(m) {
+		id := m["id"].(gocql.UUID)
+		warnings = append(warnings, entity.Warning{
+			UserID:            uuid.UUID(m["user_id"].(gocql.UUID)),
+			ID:                uuid.UUID(id),
+			ModeratorID:       uuid.UUID(m["moderator_id"].(gocql.UUID)),
+			ModeratorUsername: m["moderator_username"].(string),
+			Reason:            m["reason"].(string),
+			TargetType:        m["target_type"].(string),
+			ProposalID:        uuid.UUID(m["proposal_id"].(gocql.UUID)),
+			CommentID:         uuid.UUID(m["comment_id"].(gocql.UUID)),
+			CreatedAt:         id.Time(),
+		})
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return warnings, err
+}
@@ -0,0 +1,237 @@
+// Package notifications keeps track of who follows a proposal and notifies the
+// followers about its activity. Authors follow their proposals, commenters
+// the proposals they commented on, until they unfollow them.
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	commentsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/events"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/notifications/repository"
+	proposalRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+// Milestones are the upvote counts the author is notified about. Past the
+// last one every multiple of 1000 is a milestone.
+var Milestones = []int{10, 25, 50, 100, 250, 500, 1000}
+
+var (
+	start        sync.Once
+	subscription *events.Subscription
+)
+
+// Start notifies about the published events from now on. Errors are passed
+// to onError, which may be nil. Starting again returns the running
+// subscription.
+func Start(session *gocql.Session, onError func(error)) *events.Subscription {
+	start.Do(func() {
+		subscription = events.Subscribe("notifications", events.DefaultBuffer, func(event events.Event) {
+			err := Handle(session, event)
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		})
+	})
+
+	return subscription
+}
+
+// Handle updates the followers and stores the notifications of one event.
+func Handle(session *gocql.Session, event events.Event) error {
+	if event.TargetType == entity.AuditTargetComment {
+		if event.Action == entity.AuditCreate {
+			return commented(session, event)
+		}
+		return nil
+	}
+
+	switch event.Action {
+	case entity.AuditCreate, entity.AuditImport:
+		proposal, ok := event.After.(entity.Proposal)
+		if !ok {
+			return nil
+		}
+		return repository.FollowIfNew(session, proposal.ID, proposal.UserID, entity.FollowAuthor)
+	case entity.AuditStatusChange:
+		return statusChanged(session, event)
+	case entity.AuditUpvote:
+		return upvoted(session, event)
+	case entity.AuditDelete:
+		return repository.DeleteFollowers(session, event.ProposalID)
+	case entity.AuditDeleteAll:
+		return repository.DeleteAllFollowers(session)
+	}
+
+	return nil
+}
+
+// IsMilestone reports whether upvotes is one of the Milestones.
+func IsMilestone(upvotes int) bool {
+	for _, milestone := range Milestones {
+		if upvotes == milestone {
+			return true
+		}
+	}
+
+	last := Milestones[len(Milestones)-1]
+	return upvotes > last && upvotes%1000 == 0
+}
+
+func commented(session *gocql.Session, event events.Event) error {
+	comment, ok := event.After.(entity.Comment)
+	if !ok {
+		return nil
+	}
+
+	// the comment may have been held for moderation or deleted since
+	stored, err := commentsRepository.GetCommentByIDAndProposalID(session, comment.ProposalID, comment.CommentID)
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.Hidden {
+		return nil
+	}
+
+	proposal, err := proposalRepository.GetLatestProposal(session, comment.ProposalID)
+	if err != nil {
+		return err
+	}
+	if len(proposal) == 0 {
+		return nil
+	}
+
+	followers, err := recipients(session, proposal[0])
+	if err != nil {
+		return err
+	}
+
+	for _, follower := range followers {
+		if follower.UserID == comment.UserCommentedID {
+			continue
+		}
+
+		notification := entity.Notification{
+			UserID:        follower.UserID,
+			Type:          entity.NotificationComment,
+			ProposalID:    comment.ProposalID,
+			ProposalTitle: proposal[0].Title,
+			CommentID:     comment.CommentID,
+			ActorID:       comment.UserCommentedID,
+			ActorUsername: comment.UserCommentedUsername,
+			Message:       fmt.Sprintf("%s commented on %q", comment.UserCommentedUsername, proposal[0].Title),
+		}
+		if follower.Reason == entity.FollowCommented {
+			notification.Type = entity.NotificationReply
+			notification.Message = fmt.Sprintf("%s replied in the discussion of %q", comment.UserCommentedUsername, proposal[0].Title)
+		}
+
+		_, err = repository.AddNotification(session, notification)
+		if err != nil {
+			return err
+		}
+	}
+
+	if comment.UserCommentedID == proposal[0].UserID {
+		return nil
+	}
+	return repository.FollowIfNew(session, comment.ProposalID, comment.UserCommentedID, entity.FollowCommented)
+}
+
+func statusChanged(session *gocql.Session, event events.Event) error {
+	before, ok := event.Before.(entity.Proposal)
+	if !ok {
+		return nil
+	}
+	after, ok := event.After.(entity.Proposal)
+	if !ok || after.Status == before.Status {
+		return nil
+	}
+
+	followers, err := recipients(session, after)
+	if err != nil {
+		return err
+	}
+
+	status := strings.ReplaceAll(after.Status, "_", " ")
+	for _, follower := range followers {
+		if follower.UserID == event.Actor.UserID {
+			continue
+		}
+
+		_, err = repository.AddNotification(session, entity.Notification{
+			UserID:        follower.UserID,
+			Type:          entity.NotificationStatusChange,
+			ProposalID:    after.ID,
+			ProposalTitle: after.Title,
+			ActorID:       event.Actor.UserID,
+			ActorUsername: event.Actor.Username,
+			Message:       fmt.Sprintf("%q is now %s", after.Title, status),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func upvoted(session *gocql.Session, event events.Event) error {
+	proposal, ok := event.After.(entity.Proposal)
+	if !ok || !IsMilestone(proposal.UpVotes) {
+		return nil
+	}
+
+	followers, err := recipients(session, proposal)
+	if err != nil {
+		return err
+	}
+
+	for _, follower := range followers {
+		if follower.UserID != proposal.UserID {
+			continue
+		}
+
+		_, err = repository.AddNotification(session, entity.Notification{
+			UserID:        proposal.UserID,
+			Type:          entity.NotificationVoteMilestone,
+			ProposalID:    proposal.ID,
+			ProposalTitle: proposal.Title,
+			Message:       fmt.Sprintf("%q reached %d upvotes", proposal.Title, proposal.UpVotes),
+		})
+		return err
+	}
+
+	return nil
+}
+
+// recipients returns the users following the proposal. Authors of proposals
+// created before follows were recorded follow them as well.
+func recipients(session *gocql.Session, proposal entity.Proposal) ([]entity.Follower, error) {
+	followers, err := repository.GetFollowers(session, proposal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var following []entity.Follower
+	author := false
+	for _, follower := range followers {
+		if follower.UserID == proposal.UserID {
+			author = true
+		}
+		if follower.Following {
+			following = append(following, follower)
+		}
+	}
+
+	if !author && proposal.UserID != uuid.Nil {
+		following = append(following, entity.Follower{ProposalID: proposal.ID, UserID: proposal.UserID, Reason: entity.FollowAuthor, Following: true})
+	}
+
+	return following, nil
+}
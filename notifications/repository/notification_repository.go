@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+// NotificationTable holds the notifications of every user.
+const NotificationTable = "notifications_by_user"
+
+// FollowerTable holds the followers of every proposal.
+const FollowerTable = "proposal_followers"
+
+// Follow makes the user follow the proposal, also after unfollowing it.
+func Follow(session *gocql.Session, proposalID, userID uuid.UUID, reason string) error {
+	return session.Query(`INSERT INTO proposal_followers(proposal_id, user_id, reason, following, followed_at)
+							VALUES (?, ?, ?, ?, ?);`, gocql.UUID(proposalID), gocql.UUID(userID), reason, true, time.Now()).Exec()
+}
+
+// FollowIfNew makes the user follow the proposal unless the user followed or
+// unfollowed it before.
+func FollowIfNew(session *gocql.Session, proposalID, userID uuid.UUID, reason string) error {
+	_, err := session.Query(`INSERT INTO proposal_followers(proposal_id, user_id, reason, following, followed_at)
+							VALUES (?, ?, ?, ?, ?) IF NOT EXISTS;`, gocql.UUID(proposalID), gocql.UUID(userID), reason, true, time.Now()).
+		MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+// Unfollow stops the user following the proposal. The row is kept, so the
+// user is not made to follow it again by commenting.
+func Unfollow(session *gocql.Session, proposalID, userID uuid.UUID) error {
+	return session.Query(`UPDATE proposal_followers SET following=? WHERE proposal_id=? AND user_id=?;`,
+		false, gocql.UUID(proposalID), gocql.UUID(userID)).Exec()
+}
+
+// GetFollowers returns every follower row of the proposal, including the
+// users that unfollowed it.
+func GetFollowers(session *gocql.Session, proposalID uuid.UUID) ([]entity.Follower, error) {
+	var followers []entity.Follower
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM proposal_followers WHERE proposal_id=?;`, gocql.UUID(proposalID)).Iter()
+
+	for iter.MapScan(m) {
+		followers = append(followers, entity.Follower{
+			ProposalID: uuid.UUID(m["proposal_id"].(gocql.UUID)),
+			UserID:     uuid.UUID(m["user_id"].(gocql.UUID)),
+			Reason:     m["reason"].(string),
+			Following:  m["following"].(bool),
+			FollowedAt: m["followed_at"].(time.Time),
+		})
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return followers, err
+}
+
+// DeleteFollowers removes every follower of the proposal.
+func DeleteFollowers(session *gocql.Session, proposalID uuid.UUID) error {
+	return session.Query(`DELETE FROM proposal_followers WHERE proposal_id=?;`, gocql.UUID(proposalID)).Exec()
+}
+
+// DeleteAllFollowers empties the follower table.
+func DeleteAllFollowers(session *gocql.Session) error {
+	return session.Query(`TRUNCATE TABLE user_proposals_and_comments.proposal_followers`).Exec()
+}
+
+// AddNotification stores an unread notification for notification.UserID and
+// returns it with its id and creation time set.
+func AddNotification(session *gocql.Session, notification entity.Notification) (entity.Notification, error) {
+	id := gocql.TimeUUID()
+
+	err := session.Query(`INSERT INTO notifications_by_user(user_id, id, type, proposal_id, proposal_title, comment_id,
+							actor_id, actor_username, message, read) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		gocql.UUID(notification.UserID), id, notification.Type, gocql.UUID(notification.ProposalID), notification.ProposalTitle,
+		gocql.UUID(notification.CommentID), gocql.UUID(notification.ActorID), notification.ActorUsername,
+		notification.Message, false).Exec()
+	if err != nil {
+		return entity.Notification{}, err
+	}
+
+	notification.ID = uuid.UUID(id)
+	notification.Read = false
+	notification.CreatedAt = id.Time()
+
+	return notification, nil
+}
+
+// GetNotifications returns up to limit notifications of the user, newest
+// first. With before set only notifications older than the one with that id
+// are returned, with unreadOnly only the unread ones.
+func GetNotifications(session *gocql.Session, userID, before uuid.UUID, limit int, unreadOnly bool) ([]entity.Notification, error) {
+	var notifications []entity.Notification
+	var m = map[string]interface{}{}
+
+	query := session.Query(`SELECT * FROM notifications_by_user WHERE user_id=?;`, gocql.UUID(userID))
+	if before != uuid.Nil {
+		query = session.Query(`SELECT * FROM notifications_by_user WHERE user_id=? AND id<?;`,
+			gocql.UUID(userID), gocql.UUID(before))
+	}
+	iter := query.Iter()
+
+	for len(notifications) < limit && iter.MapScan(m) {
+		notification := notificationFromMap(m)
+		m = map[string]interface{}{}
+
+		if unreadOnly && notification.Read {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+
+	err := iter.Close()
+
+	return notifications, err
+}
+
+// CountUnread returns how many notifications of the user are unread.
+func CountUnread(session *gocql.Session, userID uuid.UUID) (int, error) {
+	var read bool
+	count := 0
+
+	iter := session.Query(`SELECT read FROM notifications_by_user WHERE user_id=?;`, gocql.UUID(userID)).Iter()
+	for iter.Scan(&read) {
+		if !read {
+			count++
+		}
+	}
+
+	err := iter.Close()
+
+	return count, err
+}
+
+// MarkRead marks the notifications of the user with the ids as read and
+// returns how many were found.
+func MarkRead(session *gocql.Session, userID uuid.UUID, ids []uuid.UUID) (int, error) {
+	marked := 0
+
+	for _, id := range ids {
+		// IF EXISTS keeps unknown or expired ids from creating empty rows
+		applied, err := session.Query(`UPDATE notifications_by_user SET read=? WHERE user_id=? AND id=? IF EXISTS;`,
+			true, gocql.UUID(userID), gocql.UUID(id)).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return marked, err
+		}
+		if applied {
+			marked++
+		}
+	}
+
+	return marked, nil
+}
+
+// MarkAllRead marks every unread notification of the user as read and
+// returns how many there were.
+func MarkAllRead(session *gocql.Session, userID uuid.UUID) (int, error) {
+	var id gocql.UUID
+	var read bool
+	var unread []uuid.UUID
+
+	iter := session.Query(`SELECT id, read FROM notifications_by_user WHERE user_id=?;`, gocql.UUID(userID)).Iter()
+	for iter.Scan(&id, &read) {
+		if !read {
+			unread = append(unread, uuid.UUID(id))
+		}
+	}
+
+	err := iter.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range unread {
+		err = session.Query(`UPDATE notifications_by_user SET read=? WHERE user_id=? AND id=?;`,
+			true, gocql.UUID(userID), gocql.UUID(id)).Exec()
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(unread), nil
+}
+
+// DeleteAllNotifications empties the notification table.
+func DeleteAllNotifications(session *gocql.Session) error {
+	return session.Query(`TRUNCATE TABLE user_proposals_and_comments.notifications_by_user`).Exec()
+}
+
+func notificationFromMap(m map[string]interface{}) entity.Notification {
+	id := m["id"].(gocql.UUID)
+
+	notification := entity.Notification{
+		UserID:        uuid.UUID(m["user_id"].(gocql.UUID)),
+		ID:            uuid.UUID(id),
+		Type:          m["type"].(string),
+		ProposalID:    uuid.UUID(m["proposal_id"].(gocql.UUID)),
+		ProposalTitle: m["proposal_title"].(string),
+		ActorUsername: m["actor_username"].(string),
+		Message:       m["message"].(string),
+		Read:          m["read"].(bool),
+		CreatedAt:     id.Time(),
+	}
+	if commentID, ok := m["comment_id"].(gocql.UUID); ok {
+		notification.CommentID = uuid.UUID(commentID)
+	}
+	if actorID, ok := m["actor_id"].(gocql.UUID); ok {
+		notification.ActorID = uuid.UUID(actorID)
+	}
+
+	return notification
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+// Cache holds proposals by id. Implementations backed by a shared store let
+// several API instances see each other's invalidations.
+type Cache interface {
+	Get(id uuid.UUID) (entity.Proposal, bool)
+	Set(id uuid.UUID, proposal entity.Proposal)
+	Delete(id uuid.UUID)
+	Purge()
+}
+
+type Stats struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// Metrics counts cache hits and misses. The zero value is ready to use.
+type Metrics struct {
+	hits   uint64
+	misses uint64
+}
+
+func (m *Metrics) Hit() {
+	atomic.AddUint64(&m.hits, 1)
+}
+
+func (m *Metrics) Miss() {
+	atomic.AddUint64(&m.misses, 1)
+}
+
+func (m *Metrics) Stats() Stats {
+	stats := Stats{
+		Hits:   atomic.LoadUint64(&m.hits),
+		Misses: atomic.LoadUint64(&m.misses),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+type lruEntry struct {
+	id       uuid.UUID
+	proposal entity.Proposal
+	expires  time.Time
+}
+
+// LRU is an in-process Cache that evicts the least recently used proposal once
+// it holds capacity entries and expires entries ttl after they were set.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[uuid.UUID]*list.Element
+	order    *list.List
+}
+
+func NewLRU(capacity int, ttl time.Duration) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    map[uuid.UUID]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+func (l *LRU) Get(id uuid.UUID) (entity.Proposal, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.items[id]
+	if !ok {
+		return entity.Proposal{}, false
+	}
+
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		l.remove(element)
+		return entity.Proposal{}, false
+	}
+
+	l.order.MoveToFront(element)
+	return entry.proposal, true
+}
+
+func (l *LRU) Set(id uuid.UUID, proposal entity.Proposal) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expires := time.Now().Add(l.ttl)
+	if element, ok := l.items[id]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.proposal = proposal
+		entry.expires = expires
+		l.order.MoveToFront(element)
+		return
+	}
+
+	l.items[id] = l.order.PushFront(&lruEntry{id: id, proposal: proposal, expires: expires})
+	for l.order.Len() > l.capacity {
+		l.remove(l.order.Back())
+	}
+}
+
+func (l *LRU) Delete(id uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.items[id]; ok {
+		l.remove(element)
+	}
+}
+
+func (l *LRU) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.items = map[uuid.UUID]*list.Element{}
+	l.order.Init()
+}
+
+func (l *LRU) remove(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.items, element.Value.(*lruEntry).id)
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	auditRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/audit/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/daterange"
+)
+
+// GetAuditLog
+// @Summary Audit trail of proposals and comments
+// @Description Who created, edited, voted on or deleted proposals and comments, newest first - for only admin. Without a range, the changes of a target are listed since it was created and all others over the last day.
+// @Tags proposal audit
+// @Accept plain
+// @Produce json
+// @Param user-id query string false "only changes made by this user"
+// @Param target-id query string false "only changes of this proposal or comment"
+// @Param date-from query string false "changed at or after, see /proposal/get/time for the formats"
+// @Param date-to query string false "changed at or before"
+// @Param last query string false "changed within e.g. 7d, cannot be combined with date-from and date-to"
+// @Param tz query string false "time zone of dates without an offset"
+// @Param limit query int false "at most this many entries, up to 1000"
+// @Success 200 {object} response.Response{Data=[]entity.AuditEntry}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/audit [get]
+// @Security JWTToken
+func (p *ProposalController) GetAuditLog(c echo.Context) error {
+	var filter auditRepository.Filter
+
+	for param, id := range map[string]*uuid.UUID{"user-id": &filter.ActorID, "target-id": &filter.TargetID} {
+		value := c.QueryParam(param)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := uuid.Parse(value)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "Please check the " + param + " in your request again for errors",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		*id = parsed
+	}
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "limit must be a positive number",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		filter.Limit = n
+	}
+
+	q := daterange.Query{
+		From: c.QueryParam("date-from"),
+		To:   c.QueryParam("date-to"),
+		Last: c.QueryParam("last"),
+		TZ:   c.QueryParam("tz"),
+	}
+
+	// the changes of a target share a partition, so any range is cheap
+	window := daterange.MaxWindow
+	if filter.TargetID != uuid.Nil {
+		window = 0
+	}
+
+	now := time.Now()
+	if q.From == "" && q.To == "" && q.Last == "" {
+		filter.To = now
+		if filter.TargetID == uuid.Nil {
+			filter.From = now.Add(-24 * time.Hour)
+		}
+	} else {
+		dateRange, err := daterange.ParseWindow(q, now, window)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   err.Error(),
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		filter.From, filter.To = dateRange.From, dateRange.To
+	}
+
+	entries, err := auditRepository.GetEntries(p.Session, filter)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, entries)
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/bulk"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/cascade"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+)
+
+type BulkProposal struct {
+	Title        string `json:"title" validate:"required,max=200"`
+	ProposalText string `json:"proposal_text" validate:"required,max=10000"`
+	Status       string `json:"status" validate:"oneof=open under_review accepted rejected implemented"`
+	// The author defaults to the admin sending the request. A username is
+	// required when a user_id is given.
+	UserID    string `json:"user_id" validate:"uuid"`
+	Username  string `json:"username" validate:"max=100"`
+	FirstName string `json:"firstname" validate:"max=100"`
+	LastName  string `json:"lastname" validate:"max=100"`
+}
+
+// Items are validated one by one so a bad row only fails itself.
+type BulkCreateProposalsRequest struct {
+	Items []BulkProposal `json:"items" validate:"-"`
+}
+
+type BulkDeleteProposalsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type BulkStatusChange struct {
+	ID     string `json:"id" validate:"required,uuid"`
+	Status string `json:"status" validate:"required,oneof=open under_review accepted rejected implemented"`
+}
+
+type BulkStatusRequest struct {
+	Items []BulkStatusChange `json:"items" validate:"-"`
+}
+
+var errUsernameRequired = errors.New("username is required when user_id is given")
+
+// BulkCreateProposals
+// @Summary Create many proposals
+// @Description Create up to 500 proposals at once, e.g. when migrating from the old roadmap - for only admin. The proposals are not run through the content filter, they are stored as sent
+// @Tags proposal bulk
+// @Accept json
+// @Produce json
+// @Param bulk_create_proposals_request body BulkCreateProposalsRequest true "the proposals to create"
+// @Success 200 {object} response.Response{Data=bulk.Response}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/bulk/create [post]
+// @Security JWTToken
+func (p *ProposalController) BulkCreateProposals(c echo.Context) error {
+	var req BulkCreateProposalsRequest
+
+	if err := c.Bind(&req); err != nil || len(req.Items) == 0 || len(req.Items) > bulk.MaxItems {
+		return p.WriteBulkSizeError(c)
+	}
+
+	token := c.Request().Header.Get("Authorization")
+	tokenSession, err := p.TokenSessionRepository.GetOneFlexible("token", token)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	actor := entity.Actor{UserID: tokenSession.UserID, Username: tokenSession.User.Username, IP: c.RealIP()}
+
+	validator := validation.New()
+	result := bulk.Run(len(req.Items), bulk.Concurrency, func(i int) (string, error) {
+		item := req.Items[i]
+		if err := validator.Validate(&item); err != nil {
+			return "", err
+		}
+
+		userID := tokenSession.UserID
+		username, firstname, lastname := tokenSession.User.Username, tokenSession.User.FirstName, tokenSession.User.LastName
+		if item.UserID != "" {
+			if item.Username == "" {
+				return "", errUsernameRequired
+			}
+			var err error
+			if userID, err = uuid.Parse(item.UserID); err != nil {
+				return "", err
+			}
+			username, firstname, lastname = item.Username, item.FirstName, item.LastName
+		}
+
+		// the status is written with the proposal, a failure cannot leave
+		// a stored proposal behind that the result does not name
+		proposal, err := repository.StoreProposalWithID(p.Session, actor, gocql.TimeUUID(), item.Title, item.ProposalText, userID, username, firstname, lastname, item.Status, false)
+		if err != nil {
+			return "", err
+		}
+
+		return proposal.ID.String(), nil
+	})
+
+	return p.WriteSuccess(c, result)
+}
+
+// BulkDeleteProposals
+// @Summary Delete many proposals
+// @Description Delete up to 500 proposals by id, together with their comments - for only admin
+// @Tags proposal bulk
+// @Accept json
+// @Produce json
+// @Param bulk_delete_proposals_request body BulkDeleteProposalsRequest true "ids of the proposals to delete"
+// @Success 200 {object} response.Response{Data=bulk.Response}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/bulk/delete [post]
+// @Security JWTToken
+func (p *ProposalController) BulkDeleteProposals(c echo.Context) error {
+	var req BulkDeleteProposalsRequest
+
+	if err := c.Bind(&req); err != nil || len(req.IDs) == 0 || len(req.IDs) > bulk.MaxItems {
+		return p.WriteBulkSizeError(c)
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	result := bulk.Run(len(req.IDs), bulk.Concurrency, func(i int) (string, error) {
+		proposalID, err := uuid.Parse(req.IDs[i])
+		if err != nil {
+			return "", err
+		}
+
+		_, err = cascade.DeleteProposal(p.Session, actor, proposalID)
+		return proposalID.String(), err
+	})
+
+	return p.WriteSuccess(c, result)
+}
+
+// BulkChangeProposalStatus
+// @Summary Change the status of many proposals
+// @Description Move up to 500 proposals to a new status - for only admin
+// @Tags proposal bulk
+// @Accept json
+// @Produce json
+// @Param bulk_status_request body BulkStatusRequest true "proposal ids with their new status"
+// @Success 200 {object} response.Response{Data=bulk.Response}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/bulk/status [post]
+// @Security JWTToken
+func (p *ProposalController) BulkChangeProposalStatus(c echo.Context) error {
+	var req BulkStatusRequest
+
+	if err := c.Bind(&req); err != nil || len(req.Items) == 0 || len(req.Items) > bulk.MaxItems {
+		return p.WriteBulkSizeError(c)
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	validator := validation.New()
+	result := bulk.Run(len(req.Items), bulk.Concurrency, func(i int) (string, error) {
+		item := req.Items[i]
+		if err := validator.Validate(&item); err != nil {
+			return item.ID, err
+		}
+
+		proposalID, err := uuid.Parse(item.ID)
+		if err != nil {
+			return item.ID, err
+		}
+
+		_, err = repository.UpdateProposalStatus(p.Session, actor, proposalID, item.Status)
+		return item.ID, err
+	})
+
+	return p.WriteSuccess(c, result)
+}
+
+// WriteBulkSizeError answers 400 for a bulk request without items or with
+// more than bulk.MaxItems.
+func (p *ProposalController) WriteBulkSizeError(c echo.Context) error {
+	resp := response.ErrorResponse{
+		ErrorCode: 400,
+		Message:   fmt.Sprintf("Please send between 1 and %d items", bulk.MaxItems),
+	}
+	message := "false"
+	return p.WriteBadRequest(c, message, resp)
+}
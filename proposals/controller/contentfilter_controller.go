@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/contentfilter"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/moderation"
+)
+
+// ScreenContent runs a submission through the content filter before it is
+// stored. If it must not be stored the request is answered, ok is false and
+// err is what the handler returns.
+func (p *ProposalController) ScreenContent(c echo.Context, s contentfilter.Submission) (result contentfilter.Result, ok bool, err error) {
+	result, err = p.ContentFilter.Check(s)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return result, false, p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	if result.Verdict == contentfilter.Reject {
+		return result, false, p.WriteContentRejected(c, result)
+	}
+
+	return result, true, nil
+}
+
+// StoredContent queues a stored submission the content filter held for
+// moderation, with the proposal or, with commentID set, the comment already
+// stored hidden. Once that succeeded the submission is remembered for
+// duplicate detection, so a failed request can be retried.
+func (p *ProposalController) StoredContent(s contentfilter.Submission, result contentfilter.Result, proposalID, commentID uuid.UUID) error {
+	if result.Verdict == contentfilter.Hold {
+		targetType := entity.AuditTargetProposal
+		if commentID != uuid.Nil {
+			targetType = entity.AuditTargetComment
+		}
+
+		_, err := moderation.Hold(p.Session, targetType, proposalID, commentID, s.UserID, result.Reasons())
+		if err != nil {
+			return err
+		}
+	}
+
+	// a post missing from the recent posts only escapes duplicate detection
+	_ = p.ContentFilter.Remember(s)
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/daterange"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/export"
+)
+
+// ExportProposals
+// @Summary Export proposals with their comments
+// @Description Download every proposal, optionally filtered, with its comments as CSV or NDJSON - for only admin
+// @Tags proposal export
+// @Accept plain
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "csv (default) or ndjson"
+// @Param date-from query string false "created at or after, see /proposal/get/time for the formats"
+// @Param date-to query string false "created at or before"
+// @Param last query string false "created within e.g. 7d, cannot be combined with date-from and date-to"
+// @Param tz query string false "time zone of dates without an offset"
+// @Param user-id query string false "only proposals of this user"
+// @Param status query string false "only proposals with this status"
+// @Param comments query bool false "include comments, defaults to true"
+// @Success 200 {file} file
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/export [get]
+// @Security JWTToken
+func (p *ProposalController) ExportProposals(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if format != export.FormatCSV && format != export.FormatNDJSON {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   export.ErrUnknownFormat.Error(),
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	now := time.Now()
+	filter, err := export.ParseFilter(daterange.Query{
+		From: c.QueryParam("date-from"),
+		To:   c.QueryParam("date-to"),
+		Last: c.QueryParam("last"),
+		TZ:   c.QueryParam("tz"),
+	}, c.QueryParam("user-id"), c.QueryParam("status"), now)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   err.Error(),
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	filename := fmt.Sprintf("proposals-%s.%s", now.UTC().Format("20060102-150405"), format)
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, export.ContentType(format))
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// The status is already sent, an error from here on can only cut the
+	// download short.
+	_, err = export.Write(p.Session, c.Response(), export.Options{
+		Format:   format,
+		Filter:   filter,
+		Comments: c.QueryParam("comments") != "false",
+	})
+
+	return err
+}
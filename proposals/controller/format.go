@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"html"
+
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/markup"
+)
+
+// TextFormat reads the format query parameter of a read endpoint. For an
+// unknown format the request is answered, ok is false and err is what the
+// handler returns.
+func (p *ProposalController) TextFormat(c echo.Context) (format string, ok bool, err error) {
+	format, err = markup.ParseFormat(c.QueryParam("format"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   err.Error(),
+		}
+		message := "false"
+		return "", false, p.WriteBadRequest(c, message, resp)
+	}
+
+	return format, true, nil
+}
+
+// FormatProposals returns copies of the proposals with their text in format.
+// In FormatHTML the title is escaped as well, so every text field is safe to
+// insert into a page.
+func FormatProposals(proposals []entity.Proposal, format string) []entity.Proposal {
+	formatted := make([]entity.Proposal, len(proposals))
+	for i, proposal := range proposals {
+		switch format {
+		case markup.FormatHTML:
+			proposal.Title = html.EscapeString(proposal.Title)
+			// rows the markup backfill has not reached are shown as escaped
+			// source, rendering on every read would be too costly
+			if proposal.ProposalHTML != "" {
+				proposal.ProposalText = proposal.ProposalHTML
+			} else {
+				proposal.ProposalText = markup.Escape(proposal.ProposalText)
+			}
+		case markup.FormatPlain:
+			if proposal.ProposalPlain != "" {
+				proposal.ProposalText = proposal.ProposalPlain
+			}
+		}
+		formatted[i] = proposal
+	}
+
+	return formatted
+}
+
+// FormatComments returns copies of the comments with their text in format.
+func FormatComments(comments []entity.Comment, format string) []entity.Comment {
+	formatted := make([]entity.Comment, len(comments))
+	for i, comment := range comments {
+		switch format {
+		case markup.FormatHTML:
+			if comment.CommentHTML != "" {
+				comment.CommentText = comment.CommentHTML
+			} else {
+				comment.CommentText = markup.Escape(comment.CommentText)
+			}
+		case markup.FormatPlain:
+			if comment.CommentPlain != "" {
+				comment.CommentText = comment.CommentPlain
+			}
+		}
+		formatted[i] = comment
+	}
+
+	return formatted
+}
@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/moderation"
+	moderationRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/moderation/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+// MaxQueueLimit caps the items a moderation queue request returns.
+const MaxQueueLimit = 500
+
+// ModerationQueuePath is the route whose policy decides who is a moderator.
+const ModerationQueuePath = "/api/v1/user/proposal/moderation/queue"
+
+// ModeratorCheck returns a check of whether the caller of a request may read
+// the moderation queue, decided by casbinMdw as if the request was sent
+// there. Requests without a token are no moderators.
+func ModeratorCheck(e *echo.Echo, casbinMdw echo.MiddlewareFunc) func(c echo.Context) bool {
+	return func(c echo.Context) bool {
+		if c.Request().Header.Get("Authorization") == "" {
+			return false
+		}
+
+		req := c.Request().Clone(c.Request().Context())
+		req.Method = http.MethodGet
+		req.URL.Path = ModerationQueuePath
+		probe := e.NewContext(req, discardResponse{header: http.Header{}})
+		probe.SetPath(ModerationQueuePath)
+
+		// casbinMdw may answer a refused request itself, so only reaching
+		// the handler counts
+		allowed := false
+		_ = casbinMdw(func(echo.Context) error {
+			allowed = true
+			return nil
+		})(probe)
+		return allowed
+	}
+}
+
+// discardResponse takes the response casbinMdw writes to a refused probe.
+type discardResponse struct {
+	header http.Header
+}
+
+func (d discardResponse) Header() http.Header         { return d.header }
+func (d discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponse) WriteHeader(int)             {}
+
+// CanSee tells whether the caller may read content written by authorID.
+// Hidden content, removed by a moderator or held by the content filter, is
+// only shown to moderators and its author.
+func (p *ProposalController) CanSee(c echo.Context, hidden bool, authorID uuid.UUID) bool {
+	if !hidden {
+		return true
+	}
+	if p.Moderator != nil && p.Moderator(c) {
+		return true
+	}
+
+	userID := p.requestUserID(c)
+	return userID != uuid.Nil && userID == authorID
+}
+
+type ReportRequest struct {
+	Reason  string `json:"reason" form:"reason" validate:"required,oneof=spam abuse harassment off_topic misinformation other"`
+	Details string `json:"details" form:"details" validate:"max=1000"`
+}
+
+type ResolveReportRequest struct {
+	TargetID string `json:"target_id" form:"target_id" validate:"required,uuid"`
+	Action   string `json:"action" form:"action" validate:"required,oneof=hide remove dismiss warn"`
+	// Note is kept with the decision and sent to the author as the reason of
+	// a warning.
+	Note string `json:"note" form:"note" validate:"max=1000"`
+}
+
+// ReportProposal
+// @Summary Report a proposal
+// @Description Report a proposal as spam, abusive or otherwise inappropriate. Each user can report a proposal once.
+// @Tags proposal moderation
+// @Accept json
+// @Produce json
+// @Param id path string true "unique proposal id"
+// @Param report_request body ReportRequest true "why the proposal is reported"
+// @Success 200 {object} response.Response{Data=entity.Report}
+// @Failure 400 {object} response.Response{Data=FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=ConflictResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/report/:id [post]
+// @Security JWTToken
+func (p *ProposalController) ReportProposal(c echo.Context) error {
+	proposalID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	proposal, err := repository.GetLatestProposal(p.Session, proposalID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if len(proposal) == 0 {
+		return p.WriteNotFound(c, "Proposal not found")
+	}
+
+	return p.SubmitReport(c, entity.Report{TargetType: entity.AuditTargetProposal, ProposalID: proposalID}, proposal[0].UserID)
+}
+
+// SubmitReport reads the reason of report from the request, files it as the
+// requesting user's and answers with it. authorID wrote the reported content.
+func (p *ProposalController) SubmitReport(c echo.Context, report entity.Report, authorID uuid.UUID) error {
+	var req ReportRequest
+
+	if err := c.Bind(&req); err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return p.WriteValidationError(c, err)
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	report.ReporterID = actor.UserID
+	report.ReporterUsername = actor.Username
+	report.Reason = req.Reason
+	report.Details = req.Details
+
+	item, err := moderation.Report(p.Session, report, authorID)
+	if err == moderation.ErrAlreadyReported {
+		return p.WriteConflict(c, err.Error(), nil)
+	}
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	report.CreatedAt = item.LastReportedAt
+	return p.WriteSuccess(c, report)
+}
+
+// GetModerationQueue
+// @Summary Moderation queue
+// @Description Reported proposals and comments waiting for a moderator, most reported first - for only moderators
+// @Tags proposal moderation
+// @Accept plain
+// @Produce json
+// @Param limit query int false "at most this many items, default 50, up to 500"
+// @Success 200 {object} response.Response{Data=[]entity.ModerationItem}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/moderation/queue [get]
+// @Security JWTToken
+func (p *ProposalController) GetModerationQueue(c echo.Context) error {
+	limit := 50
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > MaxQueueLimit {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "limit must be a number between 1 and " + strconv.Itoa(MaxQueueLimit),
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		limit = n
+	}
+
+	items, err := moderation.Queue(p.Session, limit)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, items)
+}
+
+// ResolveReport
+// @Summary Resolve the reports about a proposal or comment
+// @Description Hide or remove the reported content, dismiss the reports or warn the author - for only moderators
+// @Tags proposal moderation
+// @Accept json
+// @Produce json
+// @Param resolve_report_request body ResolveReportRequest true "the reported proposal or comment and the action taken"
+// @Success 200 {object} response.Response{Data=entity.ModerationItem}
+// @Failure 400 {object} response.Response{Data=FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=ConflictResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/moderation/resolve [post]
+// @Security JWTToken
+func (p *ProposalController) ResolveReport(c echo.Context) error {
+	var req ResolveReportRequest
+
+	if err := c.Bind(&req); err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return p.WriteValidationError(c, err)
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	targetID, err := uuid.Parse(req.TargetID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	item, err := moderation.Resolve(p.Session, actor, targetID, req.Action, req.Note)
+	switch err {
+	case nil:
+	case moderation.ErrNotReported:
+		return p.WriteNotFound(c, err.Error())
+	case moderation.ErrNotPending:
+		return p.WriteConflict(c, err.Error(), item)
+	default:
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, item)
+}
+
+// GetUserWarnings
+// @Summary Warnings of a user
+// @Description The warnings moderators gave a user, newest first - for only moderators
+// @Tags proposal moderation
+// @Accept plain
+// @Produce json
+// @Param user-id path string true "unique user id"
+// @Success 200 {object} response.Response{Data=[]entity.Warning}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/moderation/warnings/:user-id [get]
+// @Security JWTToken
+func (p *ProposalController) GetUserWarnings(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("user-id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	warnings, err := moderationRepository.GetWarnings(p.Session, userID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, warnings)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	TokenSessionsRepository "github.com/windswept321/smartest-city-roadmap-go/module/tokensession/repository"
+)
+
+// tokenSessions resolves the tokens of the test users.
+type tokenSessions struct {
+	TokenSessionsRepository.TokenSessionRepository
+	users map[string]uuid.UUID
+}
+
+func (t tokenSessions) GetOneFlexible(field string, value interface{}) (*TokenSessionsRepository.TokenSession, error) {
+	userID, ok := t.users[value.(string)]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+
+	return &TokenSessionsRepository.TokenSession{UserID: userID}, nil
+}
+
+// casbin lets only the moderator token read the moderation queue and
+// answers everyone else itself, like the real middleware.
+func casbin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.Request().Header.Get("Authorization") != "moderator" || c.Request().URL.Path != ModerationQueuePath {
+			return c.NoContent(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
+
+func TestHeldPostOnlyShownToAuthorAndModerators(t *testing.T) {
+	e := echo.New()
+	author := uuid.New()
+	p := &ProposalController{
+		TokenSessionRepository: tokenSessions{users: map[string]uuid.UUID{"author": author, "other": uuid.New(), "moderator": uuid.New()}},
+		Moderator:              ModeratorCheck(e, casbin),
+	}
+
+	tests := []struct {
+		token  string
+		hidden bool
+		want   bool
+	}{
+		{"", true, false},
+		{"other", true, false},
+		{"unknown", true, false},
+		{"author", true, true},
+		{"moderator", true, true},
+		{"", false, true},
+		{"other", false, true},
+	}
+
+	for _, tt := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/user/proposal/get/"+author.String(), nil)
+		if tt.token != "" {
+			request.Header.Set("Authorization", tt.token)
+		}
+		recorder := httptest.NewRecorder()
+
+		if got := p.CanSee(e.NewContext(request, recorder), tt.hidden, author); got != tt.want {
+			t.Errorf("CanSee(token %q, hidden %v) = %v, want %v", tt.token, tt.hidden, got, tt.want)
+		}
+		// the moderator check must not answer the request it was asked about
+		if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+			t.Errorf("token %q: the moderator check wrote %d %q", tt.token, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	notificationsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/notifications/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+)
+
+// MaxNotificationsLimit caps the notifications a request returns.
+const MaxNotificationsLimit = 100
+
+// MaxMarkRead caps the notification ids of a mark read request.
+const MaxMarkRead = 500
+
+// NotificationsResponse lists notifications of the user, newest first.
+type NotificationsResponse struct {
+	Notifications []entity.Notification `json:"notifications"`
+	// Unread counts all unread notifications of the user.
+	Unread int `json:"unread"`
+	// Next is the before parameter of the next page, empty on the last one.
+	Next *uuid.UUID `json:"next,omitempty"`
+}
+
+type MarkNotificationsReadRequest struct {
+	IDs []string `json:"ids" form:"ids"`
+	// All marks every notification as read, ids are ignored.
+	All bool `json:"all" form:"all"`
+}
+
+type MarkNotificationsReadResponse struct {
+	Marked int `json:"marked"`
+}
+
+// FollowProposal
+// @Summary Follow a proposal
+// @Description Get notified about new comments and status changes of the proposal. Authors follow their proposals and commenters the proposals they commented on unless they unfollowed them.
+// @Tags proposal notification
+// @Produce json
+// @Param id path string true "unique proposal id"
+// @Success 200 {object} response.Response{Data=entity.Follower}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/follow/:id [post]
+// @Security JWTToken
+func (p *ProposalController) FollowProposal(c echo.Context) error {
+	return p.setFollowing(c, true)
+}
+
+// UnfollowProposal
+// @Summary Unfollow a proposal
+// @Description Stop getting notified about the proposal, also after commenting on it again
+// @Tags proposal notification
+// @Produce json
+// @Param id path string true "unique proposal id"
+// @Success 200 {object} response.Response{Data=entity.Follower}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/follow/:id [delete]
+// @Security JWTToken
+func (p *ProposalController) UnfollowProposal(c echo.Context) error {
+	return p.setFollowing(c, false)
+}
+
+func (p *ProposalController) setFollowing(c echo.Context, following bool) error {
+	proposalID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	proposal, err := repository.GetLatestProposal(p.Session, proposalID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if len(proposal) == 0 || proposal[0].Hidden {
+		return p.WriteNotFound(c, "Proposal not found")
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	reason := entity.FollowManual
+	if actor.UserID == proposal[0].UserID {
+		reason = entity.FollowAuthor
+	}
+
+	if following {
+		err = notificationsRepository.Follow(p.Session, proposalID, actor.UserID, reason)
+	} else {
+		err = notificationsRepository.Unfollow(p.Session, proposalID, actor.UserID)
+	}
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, entity.Follower{ProposalID: proposalID, UserID: actor.UserID, Reason: reason, Following: following})
+}
+
+// GetNotifications
+// @Summary My notifications
+// @Description The notifications of the requesting user, newest first, together with the number of unread ones. Notifications are kept for 90 days.
+// @Tags proposal notification
+// @Produce json
+// @Param limit query int false "at most this many notifications, default 20, up to 100"
+// @Param before query string false "notification id, only older notifications, the next of the previous page"
+// @Param unread query bool false "only unread notifications"
+// @Success 200 {object} response.Response{Data=NotificationsResponse}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/notifications [get]
+// @Security JWTToken
+func (p *ProposalController) GetNotifications(c echo.Context) error {
+	limit := 20
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > MaxNotificationsLimit {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "limit must be a number between 1 and " + strconv.Itoa(MaxNotificationsLimit),
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		limit = n
+	}
+
+	var before uuid.UUID
+	if value := c.QueryParam("before"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "before must be a notification id",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		before = id
+	}
+
+	unreadOnly := false
+	if value := c.QueryParam("unread"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "unread must be true or false",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		unreadOnly = b
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	notifications, err := notificationsRepository.GetNotifications(p.Session, actor.UserID, before, limit, unreadOnly)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	unread, err := notificationsRepository.CountUnread(p.Session, actor.UserID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	result := NotificationsResponse{Notifications: notifications, Unread: unread}
+	if result.Notifications == nil {
+		result.Notifications = []entity.Notification{}
+	}
+	if len(notifications) == limit {
+		next := notifications[len(notifications)-1].ID
+		result.Next = &next
+	}
+
+	return p.WriteSuccess(c, result)
+}
+
+// MarkNotificationsRead
+// @Summary Mark notifications read
+// @Description Mark up to 500 notifications of the requesting user as read by id, or all of them
+// @Tags proposal notification
+// @Accept json
+// @Produce json
+// @Param mark_notifications_read_request body MarkNotificationsReadRequest true "ids of the notifications, or all"
+// @Success 200 {object} response.Response{Data=MarkNotificationsReadResponse}
+// @Failure 400 {object} response.Response{Data=FieldErrorsResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/notifications/read [post]
+// @Security JWTToken
+func (p *ProposalController) MarkNotificationsRead(c echo.Context) error {
+	var req MarkNotificationsReadRequest
+
+	if err := c.Bind(&req); err != nil || (!req.All && len(req.IDs) == 0) || len(req.IDs) > MaxMarkRead {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   fmt.Sprintf("Please send all or between 1 and %d ids", MaxMarkRead),
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	var ids []uuid.UUID
+	var fields []validation.FieldError
+	if !req.All {
+		for i, value := range req.IDs {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				fields = append(fields, validation.FieldError{
+					Field:   fmt.Sprintf("ids[%d]", i),
+					Code:    validation.CodeUUID,
+					Message: "must be a notification id",
+				})
+				continue
+			}
+			ids = append(ids, id)
+		}
+	}
+	if len(fields) > 0 {
+		return p.WriteFieldErrors(c, fields)
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	var marked int
+	if req.All {
+		marked, err = notificationsRepository.MarkAllRead(p.Session, actor.UserID)
+	} else {
+		marked, err = notificationsRepository.MarkRead(p.Session, actor.UserID, ids)
+	}
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, MarkNotificationsReadResponse{Marked: marked})
+}
@@ -1,7 +1,7 @@
 package controller
 
 import (
-	"strings"
+	"net/http"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -10,26 +10,61 @@ import (
 	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/controller"
 	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
 	TokenSessionsRepository "github.com/windswept321/smartest-city-roadmap-go/module/tokensession/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/cascade"
 	commentsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/comments/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/contentfilter"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/daterange"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/httpcache"
+	idempotencyRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/idempotency/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/live"
+	mentionsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/mentions/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/mergepatch"
+	notificationsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/notifications/repository"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/safeguard"
+	safeguardRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/safeguard/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/webhooks"
 )
 
 type ProposalController struct {
 	TokenSessionRepository TokenSessionsRepository.TokenSessionRepository
 	controller.BaseController
 	*gocql.Session
+	// Safeguard guards DeleteAllProposals.
+	Safeguard safeguard.Config
+	// ContentFilter screens proposals and comments before they are stored.
+	ContentFilter *contentfilter.Pipeline
+	// Webhooks sends the changes of proposals and comments to the webhooks.
+	Webhooks *webhooks.Dispatcher
+	// Live pushes new comments and votes to the clients watching a proposal.
+	Live *live.Hub
+	// Moderator tells whether the caller of a request is a moderator, who
+	// may read hidden content. Without it only the authors may.
+	Moderator func(c echo.Context) bool
 }
 
 func NewProposalController(tokenSessionRepository TokenSessionsRepository.TokenSessionRepository, session *gocql.Session) *ProposalController {
+	filterConfig, err := contentfilter.ConfigFromEnv()
+	if err != nil {
+		// refuse to start rather than accept posts unscreened
+		panic("content filter: " + err.Error())
+	}
+
 	return &ProposalController{
 		TokenSessionRepository: tokenSessionRepository,
 		Session:                session,
+		Safeguard:              safeguard.ConfigFromEnv(),
+		ContentFilter:          contentfilter.New(session, filterConfig),
+		Webhooks:               webhooks.New(session, webhooks.Default),
+		Live:                   live.New(live.Default),
 	}
 }
 
 type WriteProposalRequest struct {
-	Title        string `json:"title" form:"title"`
-	ProposalText string `json:"proposal_text" form:"proposal_text"`
+	Title        string `json:"title" form:"title" validate:"required,max=200"`
+	ProposalText string `json:"proposal_text" form:"proposal_text" validate:"required,max=10000"`
 }
 
 // WriteProposal
@@ -37,10 +72,12 @@ type WriteProposalRequest struct {
 // @Tags proposal
 // @Accept json
 // @Produce json
-// @Description API create new proposal
+// @Description API create new proposal. A proposal the content filter holds is stored hidden until a moderator reviews it
 // @Param write_proposal_request body WriteProposalRequest true "req with title and proposal"
-// @Success 200 {object} response.Response{Data=string}
-// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Param Idempotency-Key header string false "unique key per proposal, retries with the same key do not create duplicates"
+// @Success 201 {object} response.Response{Data=entity.Proposal}
+// @Failure 400 {object} response.Response{Data=FieldErrorsResponse}
+// @Failure 422 {object} response.Response{Data=ContentRejectedResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/create [post]
 // @Security JWTToken
@@ -56,13 +93,8 @@ func (p *ProposalController) WriteProposal(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	if req.ProposalText == "" || req.Title == "" {
-		resp := response.ErrorResponse{
-			ErrorCode: 400,
-			Message:   "No proposal or title found, A proposal is required",
-		}
-		message := "false"
-		return p.WriteBadRequest(c, message, resp)
+	if err := c.Validate(&req); err != nil {
+		return p.WriteValidationError(c, err)
 	}
 
 	token := c.Request().Header.Get("Authorization")
@@ -76,7 +108,66 @@ func (p *ProposalController) WriteProposal(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	err = repository.StoreProposal(p.Session, req.Title, req.ProposalText, tokenSession.UserID, tokenSession.User.Username, tokenSession.User.FirstName, tokenSession.User.LastName)
+	proposalID := gocql.TimeUUID()
+
+	idempotencyKey := c.Request().Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		if len(idempotencyKey) > idempotencyRepository.MaxKeyLength {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "Idempotency-Key is too long",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+
+		fingerprint := idempotencyRepository.Fingerprint(req.Title, req.ProposalText)
+		record, replayed, err := idempotencyRepository.Reserve(p.Session, "proposal", tokenSession.UserID, idempotencyKey, fingerprint, idempotencyRepository.Record{ResourceID: uuid.UUID(proposalID)})
+		if err == idempotencyRepository.ErrKeyReused {
+			return p.WriteUnprocessableEntity(c, "This Idempotency-Key was already used for a different proposal")
+		}
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 500,
+				Message:   "Something went wrong",
+			}
+			message := "false"
+			return p.WriteInternalServerError(c, message, resp, "")
+		}
+
+		if replayed {
+			return p.replayProposal(c, record.ResourceID)
+		}
+	}
+
+	// screened after the replay check, a retry would repeat the first post
+	submission := contentfilter.Submission{Kind: contentfilter.KindProposal, UserID: tokenSession.UserID, Title: req.Title, Text: req.ProposalText}
+	screened, ok, err := p.ScreenContent(c, submission)
+	if !ok {
+		if idempotencyKey != "" {
+			_ = idempotencyRepository.Release(p.Session, "proposal", tokenSession.UserID, idempotencyKey)
+		}
+		return err
+	}
+
+	actor := entity.Actor{UserID: tokenSession.UserID, Username: tokenSession.User.Username, IP: c.RealIP()}
+	held := screened.Verdict == contentfilter.Hold
+	proposal, err := repository.StoreProposalWithID(p.Session, actor, proposalID, req.Title, req.ProposalText, tokenSession.UserID, tokenSession.User.Username, tokenSession.User.FirstName, tokenSession.User.LastName, entity.ProposalStatusOpen, held)
+	if err != nil {
+		if idempotencyKey != "" {
+			// let the client retry with the same key
+			_ = idempotencyRepository.Release(p.Session, "proposal", tokenSession.UserID, idempotencyKey)
+		}
+
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	err = p.StoredContent(submission, screened, proposal.ID, uuid.Nil)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -86,7 +177,33 @@ func (p *ProposalController) WriteProposal(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, "inserted proposal")
+	return p.WriteCreated(c, ProposalLocation(proposal.ID), proposal)
+}
+
+// replayProposal answers a retried create with the proposal the first
+// request created.
+func (p *ProposalController) replayProposal(c echo.Context, proposalID uuid.UUID) error {
+	proposal, err := repository.GetProposalByProposalID(p.Session, proposalID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	if len(proposal) == 0 {
+		return p.WriteConflict(c, "A request with this Idempotency-Key is still being processed", nil)
+	}
+
+	c.Response().Header().Set("Idempotent-Replayed", "true")
+	return p.WriteCreated(c, ProposalLocation(proposalID), proposal[0])
+}
+
+// ProposalLocation is the URL a proposal can be fetched from.
+func ProposalLocation(proposalID uuid.UUID) string {
+	return "/api/v1/user/proposal/get/" + proposalID.String()
 }
 
 // GetAllProposals
@@ -94,13 +211,21 @@ func (p *ProposalController) WriteProposal(c echo.Context) error {
 // @Description API Get all proposals ordered by time
 // @Tags proposal
 // @Produce json
+// @Param If-None-Match header string false "ETag of a previous response"
+// @Param format query string false "text format: markdown (default), html or plain"
 // @Success 200 {object} response.Response{Data=entity.Proposal}
+// @Success 304 "not modified"
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/getAll [get]
 // @Security JWTToken
 // @Security APIKey
 func (p *ProposalController) GetAllProposals(c echo.Context) error {
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
+	}
+
 	proposals, err := repository.GetAllProposals(p.Session)
 	if err != nil {
 		resp := response.ErrorResponse{
@@ -111,7 +236,11 @@ func (p *ProposalController) GetAllProposals(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, proposals)
+	if httpcache.NotModified(c, httpcache.ForProposals(proposals).WithFormat(format)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return p.WriteSuccess(c, FormatProposals(proposals, format))
 }
 
 // GetProposalsByUserID
@@ -121,6 +250,7 @@ func (p *ProposalController) GetAllProposals(c echo.Context) error {
 // @Accept plain
 // @Produce json
 // @Param user_id path string true "path string with id"
+// @Param format query string false "text format: markdown (default), html or plain"
 // @Success 200 {object} response.Response{Data=[]entity.Proposal}
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
@@ -128,6 +258,11 @@ func (p *ProposalController) GetAllProposals(c echo.Context) error {
 // @Security JWTToken
 // @Security APIKey
 func (p *ProposalController) GetProposalsByUserID(c echo.Context) error {
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
+	}
+
 	userIDString := c.Param("id")
 	if userIDString == "" {
 		resp := response.ErrorResponse{
@@ -158,17 +293,20 @@ func (p *ProposalController) GetProposalsByUserID(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, proposals)
+	return p.WriteSuccess(c, FormatProposals(proposals, format))
 }
 
 // GetProposalsByTimeCreated
 // @Summary Get proposals
-// @Description Get all proposals created within a duration
+// @Description Get all proposals created within a duration. Either bound may be left out, or both replaced by last
 // @Tags proposal
 // @Accept plain
 // @Produce json
-// @Param date-from query string true "format: 2022-06-23-14:00"
-// @Param date-to query string true "format: 2022-06-23-14:00"
+// @Param date-from query string false "RFC 3339, 2022-06-23, 2022-06-23T14:00, a Unix timestamp or the old 2022-06-23-14:00"
+// @Param date-to query string false "same formats as date-from, a date includes the whole day"
+// @Param last query string false "range ending now, e.g. 12h, 7d or 2w"
+// @Param tz query string false "time zone of bounds without an offset, e.g. Europe/Berlin or +02:00, defaults to UTC"
+// @Param format query string false "text format: markdown (default), html or plain"
 // @Success 200 {object} response.Response{Data=[]entity.Proposal}
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
@@ -176,48 +314,27 @@ func (p *ProposalController) GetProposalsByUserID(c echo.Context) error {
 // @Security JWTToken
 // @Security APIKey
 func (p *ProposalController) GetProposalByTimeCreated(c echo.Context) error {
-	dateFromString := c.QueryParam("date-from")
-	dateToString := c.QueryParam("date-to")
-
-	dateFromSlice := strings.Split(dateFromString, "-")
-	dateToSlice := strings.Split(dateToString, "-")
-
-	if len(dateFromSlice) != 4 || len(dateToSlice) != 4 {
-		resp := response.ErrorResponse{
-			ErrorCode: 400,
-			Message:   "Time format error",
-		}
-		message := "false"
-		return p.WriteBadRequest(c, message, resp)
-
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
 	}
 
-	dateFromString = dateFromSlice[0] + "-" + dateFromSlice[1] + "-" + dateFromSlice[2] + "T" + dateFromSlice[3] + ":00Z"
-	dateToString = dateToSlice[0] + "-" + dateToSlice[1] + "-" + dateToSlice[2] + "T" + dateToSlice[3] + ":00Z"
-
-	const timeForm = time.RFC3339
-
-	dateFrom, err := time.Parse(timeForm, dateFromString)
+	dateRange, err := daterange.Parse(daterange.Query{
+		From: c.QueryParam("date-from"),
+		To:   c.QueryParam("date-to"),
+		Last: c.QueryParam("last"),
+		TZ:   c.QueryParam("tz"),
+	}, time.Now())
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 400,
-			Message:   "Time format error",
+			Message:   "Time format error: " + err.Error(),
 		}
 		message := "false"
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	dateTo, err := time.Parse(timeForm, dateToString)
-	if err != nil {
-		resp := response.ErrorResponse{
-			ErrorCode: 400,
-			Message:   "Time format error",
-		}
-		message := "false"
-		return p.WriteBadRequest(c, message, resp)
-	}
-
-	proposals, err := repository.GetProposalsByTimeCreated(p.Session, dateFrom, dateTo)
+	proposals, err := repository.GetProposalsByTimeCreated(p.Session, dateRange.From, dateRange.To)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -227,7 +344,7 @@ func (p *ProposalController) GetProposalByTimeCreated(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, proposals)
+	return p.WriteSuccess(c, FormatProposals(proposals, format))
 }
 
 // GetProposalsByProposalID
@@ -237,13 +354,22 @@ func (p *ProposalController) GetProposalByTimeCreated(c echo.Context) error {
 // @Accept plain
 // @Produce json
 // @Param proposal_id path string true "unique proposal id"
+// @Param If-None-Match header string false "ETag of a previous response"
+// @Param format query string false "text format: markdown (default), html or plain"
 // @Success 200 {object} response.Response{Data=[]entity.Proposal}
+// @Success 304 "not modified"
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/get/:id [get]
 // @Security JWTToken
 // @Security APIKey
 func (p *ProposalController) GetProposalByProposalID(c echo.Context) error {
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
+	}
+
 	proposalIDString := c.Param("id")
 	if proposalIDString == "" {
 		resp := response.ErrorResponse{
@@ -274,24 +400,44 @@ func (p *ProposalController) GetProposalByProposalID(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, proposal)
+	// hidden proposals are answered as missing, so neither they nor their
+	// ETag give away that they exist
+	if len(proposal) == 0 || !p.CanSee(c, proposal[0].Hidden, proposal[0].UserID) {
+		return p.WriteNotFound(c, "Proposal not found")
+	}
+
+	if httpcache.NotModified(c, httpcache.ForProposals(proposal).WithFormat(format)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return p.WriteSuccess(c, FormatProposals(proposal, format))
 }
 
 type UpdateProposalRequest struct {
-	ID           string `json:"id" form:"id"`
-	Title        string `json:"title" form:"title"`
-	ProposalText string `json:"proposal" form:"proposal"`
+	ID           string `json:"id" form:"id" validate:"required,uuid"`
+	Title        string `json:"title" form:"title" validate:"required,max=200"`
+	ProposalText string `json:"proposal_text" form:"proposal_text" validate:"required,max=10000"`
+	// Deprecated: LegacyProposalText is the old name of proposal_text.
+	LegacyProposalText string `json:"proposal" form:"proposal" validate:"-"`
+	// LastUpdated is the version the edit is based on. It can be left out
+	// when the request carries an If-Match header instead.
+	LastUpdated time.Time `json:"last_updated" form:"last_updated"`
 }
 
 // UpdateProposal
 // @Summary Update an existing proposal
-// @Description Update an existing proposal
+// @Description Update an existing proposal. The edit is only applied if the proposal has not changed since the version given by last_updated or If-Match
 // @Tags proposal
 // @Accept json
 // @Produce json
-// @Param update_proposal_request body UpdateProposalRequest true "json req with ID, updated title, and text "
-// @Success 200 {object} response.Response{Data=string}
-// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Param update_proposal_request body UpdateProposalRequest true "json req with ID, updated title, text and the last_updated being edited"
+// @Param If-Match header string false "ETag of the proposal being edited"
+// @Success 200 {object} response.Response{Data=entity.Proposal}
+// @Failure 400 {object} response.Response{Data=FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=ConflictResponse}
+// @Failure 428 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 422 {object} response.Response{Data=ContentRejectedResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/update [put]
 // @Security JWTToken
@@ -307,7 +453,51 @@ func (p *ProposalController) UpdateProposal(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
+	if req.ProposalText == "" {
+		req.ProposalText = req.LegacyProposalText
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return p.WriteValidationError(c, err)
+	}
+
 	proposalID, err := uuid.Parse(req.ID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	return p.updateProposal(c, proposalID, req.LastUpdated, func(proposal *entity.Proposal) {
+		proposal.Title = req.Title
+		proposal.ProposalText = req.ProposalText
+	})
+}
+
+// PatchProposal
+// @Summary Partially update a proposal
+// @Description Change the title, the text or both with a JSON Merge Patch. The edit is only applied if the proposal has not changed since the version given by If-Match or last_updated
+// @Tags proposal
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path string true "unique proposal id"
+// @Param patch body PatchProposalRequest true "the fields to change"
+// @Param If-Match header string false "ETag of the proposal being edited"
+// @Success 200 {object} response.Response{Data=entity.Proposal}
+// @Failure 400 {object} response.Response{Data=FieldErrorsResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 409 {object} response.Response{Data=ConflictResponse}
+// @Failure 415 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 428 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 422 {object} response.Response{Data=ContentRejectedResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/update/:id [patch]
+// @Security JWTToken
+func (p *ProposalController) PatchProposal(c echo.Context) error {
+	proposalID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 400,
@@ -317,17 +507,151 @@ func (p *ProposalController) UpdateProposal(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	if req.ProposalText == "" || req.Title == "" {
+	patch, err := mergepatch.Decode(c.Request())
+	if err == mergepatch.ErrUnsupportedMediaType {
+		return p.WriteUnsupportedMediaType(c, err.Error())
+	}
+	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 400,
-			Message:   "No proposal title or text found",
+			Message:   err.Error(),
 		}
 		message := "false"
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	err = repository.UpdateProposal(p.Session, proposalID, req.Title, req.ProposalText)
+	var fieldErrors validation.Errors
+	for _, field := range patch.Unknown("title", "proposal_text", "last_updated") {
+		fieldErrors = append(fieldErrors, validation.FieldError{Field: field, Code: validation.CodeUnknown, Message: "is not a field of a proposal"})
+	}
+
+	title, titlePresent := PatchText(patch, "title", "max=200", &fieldErrors)
+	proposalText, textPresent := PatchText(patch, "proposal_text", "max=10000", &fieldErrors)
+
+	var lastUpdated time.Time
+	if _, err := patch.Unmarshal("last_updated", &lastUpdated); err != nil {
+		fieldErrors = append(fieldErrors, validation.FieldError{Field: "last_updated", Code: validation.CodeType, Message: "must be an RFC 3339 timestamp"})
+	}
+
+	if len(fieldErrors) == 0 && !titlePresent && !textPresent {
+		fieldErrors = append(fieldErrors, validation.FieldError{Code: validation.CodeRequired, Message: "patch must change title or proposal_text"})
+	}
+
+	if len(fieldErrors) > 0 {
+		return p.WriteFieldErrors(c, fieldErrors)
+	}
+
+	return p.updateProposal(c, proposalID, lastUpdated, func(proposal *entity.Proposal) {
+		if titlePresent {
+			proposal.Title = title
+		}
+		if textPresent {
+			proposal.ProposalText = proposalText
+		}
+	})
+}
+
+// PatchProposalRequest documents the members PatchProposal accepts. Members
+// left out are not changed.
+type PatchProposalRequest struct {
+	Title        string    `json:"title,omitempty"`
+	ProposalText string    `json:"proposal_text,omitempty"`
+	LastUpdated  time.Time `json:"last_updated,omitempty"`
+}
+
+// PatchText reads a text member of patch that must not be removed or left
+// empty and has to satisfy rules. Problems are appended to fieldErrors.
+func PatchText(patch mergepatch.Patch, field, rules string, fieldErrors *validation.Errors) (string, bool) {
+	value, present, err := patch.String(field)
+	switch {
+	case !present:
+		return "", false
+	case err != nil:
+		*fieldErrors = append(*fieldErrors, validation.FieldError{Field: field, Code: validation.CodeType, Message: "must be a string"})
+		return "", false
+	case value == nil:
+		*fieldErrors = append(*fieldErrors, validation.FieldError{Field: field, Code: validation.CodeRequired, Message: "is required and cannot be removed"})
+		return "", false
+	}
+
+	if errs := validation.Value(field, *value, "required,"+rules); len(errs) > 0 {
+		*fieldErrors = append(*fieldErrors, errs...)
+		return "", false
+	}
+
+	return *value, true
+}
+
+// updateProposal applies edit to the latest version of the proposal, provided
+// that is the version named by the If-Match header or, without one, by
+// lastUpdated.
+func (p *ProposalController) updateProposal(c echo.Context, proposalID uuid.UUID, lastUpdated time.Time, edit func(proposal *entity.Proposal)) error {
+	current, err := repository.GetLatestProposal(p.Session, proposalID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	if len(current) == 0 {
+		return p.WriteNotFound(c, "Proposal not found")
+	}
+
+	etag := httpcache.ForProposals(current).ETag
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" {
+		if !httpcache.MatchVersion(ifMatch, etag) {
+			c.Response().Header().Set("ETag", etag)
+			return p.WriteConflict(c, "The proposal was changed by someone else, please review the current version", current[0])
+		}
+
+		lastUpdated = current[0].LastUpdated
+	}
+
+	if lastUpdated.IsZero() {
+		return p.WritePreconditionRequired(c, "Send the last_updated of the proposal you are editing or an If-Match header")
+	}
+
+	if !lastUpdated.Equal(current[0].LastUpdated) {
+		c.Response().Header().Set("ETag", etag)
+		return p.WriteConflict(c, "The proposal was changed by someone else, please review the current version", current[0])
+	}
+
+	edited := current[0]
+	edit(&edited)
+
+	actor, err := p.RequestActor(c)
 	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	// an edit that changes nothing would be taken for a duplicate of itself
+	changed := edited.Title != current[0].Title || edited.ProposalText != current[0].ProposalText
+	submission := contentfilter.Submission{Kind: contentfilter.KindProposal, UserID: current[0].UserID, Title: edited.Title, Text: edited.ProposalText}
+	screened := contentfilter.Result{Verdict: contentfilter.Allow}
+	if changed {
+		var ok bool
+		if screened, ok, err = p.ScreenContent(c, submission); !ok {
+			return err
+		}
+	}
+
+	proposal, err := repository.UpdateProposal(p.Session, actor, proposalID, edited.Title, edited.ProposalText, lastUpdated, screened.Verdict == contentfilter.Hold)
+	switch err {
+	case nil:
+	case repository.ErrProposalNotFound:
+		return p.WriteNotFound(c, "Proposal not found")
+	case repository.ErrProposalConflict:
+		c.Response().Header().Set("ETag", httpcache.ForProposals([]entity.Proposal{proposal}).ETag)
+		return p.WriteConflict(c, "The proposal was changed by someone else, please review the current version", proposal)
+	default:
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
 			Message:   "Something went wrong",
@@ -336,18 +660,32 @@ func (p *ProposalController) UpdateProposal(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, "updated")
+	if changed {
+		err = p.StoredContent(submission, screened, proposalID, uuid.Nil)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 500,
+				Message:   "Something went wrong",
+			}
+			message := "false"
+			return p.WriteInternalServerError(c, message, resp, "")
+		}
+	}
+
+	c.Response().Header().Set("ETag", httpcache.ForProposals([]entity.Proposal{proposal}).ETag)
+	return p.WriteSuccess(c, proposal)
 }
 
 // DeleteProposal
 // @Summary Delete a single proposal
-// @Description Delete a proposal using its unique id
+// @Description Delete a proposal using its unique id, together with its comments and votes
 // @Tags proposal
 // @Accept plain
 // @Produce json
 // @Param proposal_id path string true "unique proposal id"
-// @Success 200 {object} response.Response{Data=string}
+// @Success 200 {object} response.Response{Data=cascade.Report}
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/delete/:id [delete]
 // @Security JWTToken
@@ -363,7 +701,20 @@ func (p *ProposalController) DeleteProposal(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	err = repository.DeleteProposal(p.Session, proposalID)
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	report, err := cascade.DeleteProposal(p.Session, actor, proposalID)
+	if err == repository.ErrProposalNotFound {
+		return p.WriteNotFound(c, "Proposal not found")
+	}
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -373,32 +724,102 @@ func (p *ProposalController) DeleteProposal(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, "deleted")
+	return p.WriteSuccess(c, report)
+}
+
+// deleteAllOperation names DeleteAllProposals in confirmation tokens and
+// operation records.
+const deleteAllOperation = "delete-all-proposals"
+
+// deleteAllTables are the tables DeleteAllProposals truncates.
+func deleteAllTables() []string {
+	tables := append(append([]string{}, repository.ProposalTables...), commentsRepository.CommentTables...)
+	return append(tables, mentionsRepository.MentionTable, notificationsRepository.FollowerTable)
+}
+
+// DeleteAllProposalsDryRun
+// @Summary Prepare deleting all proposals
+// @Description Count the rows deleting all proposals would remove and get the confirmation token DELETE /proposal/deleteAll requires - for only admin. Fails while the configured backup directory cannot take the backup
+// @Tags proposal
+// @Produce json
+// @Success 200 {object} response.Response{Data=safeguard.DryRun}
+// @Failure 403 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/deleteAll/dry-run [post]
+// @Security JWTToken
+func (p *ProposalController) DeleteAllProposalsDryRun(c echo.Context) error {
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	dryRun, err := p.Safeguard.DryRun(p.Session, deleteAllOperation, actor, deleteAllTables())
+	if err == safeguard.ErrEnvironmentNotAllowed {
+		return p.WriteForbidden(c, err.Error())
+	}
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   err.Error(),
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, dryRun)
 }
 
 // DeleteAllProposals
 // @Summary Delete all proposals
-// @Description Delete all proposal - for only admin
+// @Description Delete all proposals and comments - for only admin. Only allowed in the environments configured for it, and only with the confirmation token of a preceding dry run. The deletion is recorded and, if configured, preceded by a backup.
 // @Tags proposal
 // @Produce json
-// @Success 200 {object} response.Response{Data=string}
+// @Param X-Confirmation-Token header string true "token from POST /proposal/deleteAll/dry-run"
+// @Success 200 {object} response.Response{Data=safeguardRepository.Operation}
 // @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 403 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 428 {object} response.Response{Data=response.ErrorResponse}
 // @Failure 500 {object} response.Response{Data=response.ErrorResponse}
 // @Router /proposal/deleteAll [delete]
 // @Security JWTToken
 func (p *ProposalController) DeleteAllProposals(c echo.Context) error {
-	err := repository.DeleteAllProposals(p.Session)
-	if err != nil && err != gocql.ErrTimeoutNoResponse {
+	token := c.Request().Header.Get("X-Confirmation-Token")
+	if token == "" {
+		return p.WritePreconditionRequired(c, "Confirm the deletion with the X-Confirmation-Token header, get a token from POST /proposal/deleteAll/dry-run")
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
-			Message:   err.Error(),
+			Message:   "Something went wrong.",
 		}
 		message := "false"
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	err = commentsRepository.DeleteAllComments(p.Session)
-	if err != nil && err != gocql.ErrTimeoutNoResponse {
+	operation, err := p.Safeguard.Run(p.Session, deleteAllOperation, token, actor, deleteAllTables(), func() error {
+		err := repository.DeleteAllProposals(p.Session, actor)
+		if err != nil && err != gocql.ErrTimeoutNoResponse {
+			return err
+		}
+
+		err = commentsRepository.DeleteAllComments(p.Session, actor)
+		if err != nil && err != gocql.ErrTimeoutNoResponse {
+			return err
+		}
+
+		return nil
+	})
+	if err == safeguard.ErrEnvironmentNotAllowed || err == safeguardRepository.ErrTokenInvalid {
+		return p.WriteForbidden(c, err.Error())
+	}
+	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
 			Message:   err.Error(),
@@ -407,7 +828,47 @@ func (p *ProposalController) DeleteAllProposals(c echo.Context) error {
 		return p.WriteInternalServerError(c, message, resp, "")
 	}
 
-	return p.WriteSuccess(c, "deleted all proposals")
+	return p.WriteSuccess(c, operation)
+}
+
+// RequestActor is the user sending the request and the client IP it came
+// from, recorded in the audit log with every change the request makes.
+func (p *ProposalController) RequestActor(c echo.Context) (entity.Actor, error) {
+	token := c.Request().Header.Get("Authorization")
+	tokenSession, err := p.TokenSessionRepository.GetOneFlexible("token", token)
+	if err != nil {
+		return entity.Actor{}, err
+	}
+
+	return entity.Actor{UserID: tokenSession.UserID, Username: tokenSession.User.Username, IP: c.RealIP()}, nil
+}
+
+// RateLimitUser returns the id of the user the request's token belongs to,
+// for the per user rate limits. Requests without a valid token are only
+// limited per IP.
+func (p *ProposalController) RateLimitUser(c echo.Context) string {
+	userID := p.requestUserID(c)
+	if userID == uuid.Nil {
+		return ""
+	}
+
+	return userID.String()
+}
+
+// requestUserID returns the id of the user the request's token belongs to,
+// uuid.Nil for requests without a valid token.
+func (p *ProposalController) requestUserID(c echo.Context) uuid.UUID {
+	token := c.Request().Header.Get("Authorization")
+	if token == "" {
+		return uuid.Nil
+	}
+
+	tokenSession, err := p.TokenSessionRepository.GetOneFlexible("token", token)
+	if err != nil || tokenSession == nil {
+		return uuid.Nil
+	}
+
+	return tokenSession.UserID
 }
 
 // UpvoteProposal
@@ -434,7 +895,17 @@ func (p *ProposalController) UpvoteProposal(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	err = repository.UpvoteProposal(p.Session, proposalID)
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	err = repository.UpvoteProposal(p.Session, actor, proposalID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -471,7 +942,17 @@ func (p *ProposalController) DownvoteProposal(c echo.Context) error {
 		return p.WriteBadRequest(c, message, resp)
 	}
 
-	err = repository.DownvoteProposal(p.Session, proposalID)
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	err = repository.DownvoteProposal(p.Session, actor, proposalID)
 	if err != nil {
 		resp := response.ErrorResponse{
 			ErrorCode: 500,
@@ -483,3 +964,15 @@ func (p *ProposalController) DownvoteProposal(c echo.Context) error {
 
 	return p.WriteSuccess(c, "downvoted")
 }
+
+// GetCacheStats
+// @Summary Proposal cache metrics
+// @Description Hit and miss counts of the proposal read cache - for only admin
+// @Tags proposal
+// @Produce json
+// @Success 200 {object} response.Response{Data=cache.Stats}
+// @Router /proposal/cache/stats [get]
+// @Security JWTToken
+func (p *ProposalController) GetCacheStats(c echo.Context) error {
+	return p.WriteSuccess(c, repository.CacheStats())
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/contentfilter"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+)
+
+// ConflictResponse is returned with 409 when an edit was based on an outdated
+// version, carrying the current version so the client can merge and retry.
+type ConflictResponse struct {
+	ErrorCode int         `json:"error_code"`
+	Message   string      `json:"message"`
+	Current   interface{} `json:"current"`
+}
+
+// WriteCreated answers 201 with the created resource and its URL in the
+// Location header.
+func (p *ProposalController) WriteCreated(c echo.Context, location string, data interface{}) error {
+	c.Response().Header().Set(echo.HeaderLocation, location)
+	return c.JSON(http.StatusCreated, response.Response{Data: data})
+}
+
+// FieldErrorsResponse lists every field of a request that was rejected.
+type FieldErrorsResponse struct {
+	ErrorCode int                     `json:"error_code"`
+	Message   string                  `json:"message"`
+	Fields    []validation.FieldError `json:"fields"`
+}
+
+func (p *ProposalController) WriteFieldErrors(c echo.Context, fields []validation.FieldError) error {
+	resp := FieldErrorsResponse{
+		ErrorCode: http.StatusBadRequest,
+		Message:   "Please check the listed fields for errors",
+		Fields:    fields,
+	}
+	return c.JSON(http.StatusBadRequest, response.Response{Data: resp})
+}
+
+// WriteValidationError answers 400 for an error returned by c.Validate,
+// listing the invalid fields when the validator reported them.
+func (p *ProposalController) WriteValidationError(c echo.Context, err error) error {
+	if fields, ok := err.(validation.Errors); ok {
+		return p.WriteFieldErrors(c, fields)
+	}
+
+	resp := response.ErrorResponse{
+		ErrorCode: 400,
+		Message:   "Please check your request again for errors",
+	}
+	message := "false"
+	return p.WriteBadRequest(c, message, resp)
+}
+
+func (p *ProposalController) WriteUnsupportedMediaType(c echo.Context, message string) error {
+	resp := response.ErrorResponse{
+		ErrorCode: http.StatusUnsupportedMediaType,
+		Message:   message,
+	}
+	return c.JSON(http.StatusUnsupportedMediaType, response.Response{Data: resp})
+}
+
+func (p *ProposalController) WriteForbidden(c echo.Context, message string) error {
+	resp := response.ErrorResponse{
+		ErrorCode: http.StatusForbidden,
+		Message:   message,
+	}
+	return c.JSON(http.StatusForbidden, response.Response{Data: resp})
+}
+
+func (p *ProposalController) WriteNotFound(c echo.Context, message string) error {
+	resp := response.ErrorResponse{
+		ErrorCode: http.StatusNotFound,
+		Message:   message,
+	}
+	return c.JSON(http.StatusNotFound, response.Response{Data: resp})
+}
+
+func (p *ProposalController) WriteConflict(c echo.Context, message string, current interface{}) error {
+	resp := ConflictResponse{
+		ErrorCode: http.StatusConflict,
+		Message:   message,
+		Current:   current,
+	}
+	return c.JSON(http.StatusConflict, response.Response{Data: resp})
+}
+
+func (p *ProposalController) WritePreconditionRequired(c echo.Context, message string) error {
+	resp := response.ErrorResponse{
+		ErrorCode: http.StatusPreconditionRequired,
+		Message:   message,
+	}
+	return c.JSON(http.StatusPreconditionRequired, response.Response{Data: resp})
+}
+
+func (p *ProposalController) WriteUnprocessableEntity(c echo.Context, message string) error {
+	resp := response.ErrorResponse{
+		ErrorCode: http.StatusUnprocessableEntity,
+		Message:   message,
+	}
+	return c.JSON(http.StatusUnprocessableEntity, response.Response{Data: resp})
+}
+
+func (p *ProposalController) WriteServiceUnavailable(c echo.Context, message string) error {
+	resp := response.ErrorResponse{
+		ErrorCode: http.StatusServiceUnavailable,
+		Message:   message,
+	}
+	return c.JSON(http.StatusServiceUnavailable, response.Response{Data: resp})
+}
+
+// ContentRejectedResponse is returned with 422 when the content filter
+// rejected a proposal or comment, listing what the filters found.
+type ContentRejectedResponse struct {
+	ErrorCode int                      `json:"error_code"`
+	Message   string                   `json:"message"`
+	Decisions []contentfilter.Decision `json:"decisions"`
+}
+
+func (p *ProposalController) WriteContentRejected(c echo.Context, result contentfilter.Result) error {
+	resp := ContentRejectedResponse{
+		ErrorCode: http.StatusUnprocessableEntity,
+		Message:   "The content was rejected by the content filter",
+		Decisions: result.Decisions,
+	}
+	return c.JSON(http.StatusUnprocessableEntity, response.Response{Data: resp})
+}
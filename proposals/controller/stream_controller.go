@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/live"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/repository"
+)
+
+// StreamHeartbeat is how often an idle stream sends a comment line, so
+// proxies keep the connection open.
+var StreamHeartbeat = 15 * time.Second
+
+// StreamProposal
+// @Summary Live comments and votes of a proposal
+// @Description A Server-Sent Events stream of the proposal's comment.created, comment.updated, comment.deleted, comments.cleared, votes and proposal.deleted events. A reconnecting client sends the id of the last event it got in the Last-Event-ID header, or the last_event_id parameter, and gets what it missed. If that is no longer kept the stream starts with a reset event and the client should load the proposal again.
+// @Tags proposal
+// @Produce text/event-stream
+// @Param id path string true "unique proposal id"
+// @Param Last-Event-ID header string false "id of the last event the client got"
+// @Param last_event_id query string false "id of the last event the client got, for clients that cannot set headers"
+// @Param format query string false "text format of the comments: markdown (default), html or plain"
+// @Success 200 {string} string "event stream"
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 503 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/stream/:id [get]
+func (p *ProposalController) StreamProposal(c echo.Context) error {
+	format, ok, err := p.TextFormat(c)
+	if !ok {
+		return err
+	}
+
+	proposalID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	proposal, err := repository.GetLatestProposal(p.Session, proposalID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if len(proposal) == 0 || proposal[0].Hidden {
+		return p.WriteNotFound(c, "Proposal not found")
+	}
+
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("last_event_id")
+	}
+
+	missed, complete, messages, stop, err := p.Live.Listen(proposalID, lastEventID)
+	if err == live.ErrTooManyListeners {
+		return p.WriteServiceUnavailable(c, err.Error())
+	}
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	defer stop()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	// keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		if err := writeStreamEvent(w, live.Message{Event: live.Reset, Data: struct{}{}}, format); err != nil {
+			return nil
+		}
+	}
+	for _, message := range missed {
+		if err := writeStreamEvent(w, message, format); err != nil {
+			return nil
+		}
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case message, open := <-messages:
+			if !open {
+				// the client fell behind, it resumes when it reconnects
+				return nil
+			}
+			if err := writeStreamEvent(w, message, format); err != nil {
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
+
+// writeStreamEvent writes one message in the Server-Sent Events format, with
+// the comment it carries in format.
+func writeStreamEvent(w *echo.Response, message live.Message, format string) error {
+	data := message.Data
+	if message.Comment != nil {
+		data = FormatComments([]entity.Comment{*message.Comment}, format)[0]
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if message.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", message.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Event, body)
+	return err
+}
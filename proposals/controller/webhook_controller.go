@@ -0,0 +1,463 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
+	webhooksRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/webhooks/repository"
+)
+
+// MaxDeliveriesLimit caps the delivery log entries a request returns.
+const MaxDeliveriesLimit = 200
+
+type CreateWebhookRequest struct {
+	URL string `json:"url" form:"url" validate:"required,max=2000"`
+	// Events are the event names from entity.WebhookEvents, or "*" for all.
+	Events []string `json:"events" form:"events"`
+}
+
+// DeliveriesResponse lists attempts to send events to a webhook, newest
+// first.
+type DeliveriesResponse struct {
+	Deliveries []entity.WebhookDelivery `json:"deliveries"`
+	// Next is the before parameter of the next page, empty on the last one.
+	Next *uuid.UUID `json:"next,omitempty"`
+}
+
+// CreateWebhook
+// @Summary Create a webhook
+// @Description Send proposal and comment events to a URL - for only admin. Every delivery is a JSON POST signed in the X-Webhook-Signature header as sha256= and the hex HMAC-SHA256 of the X-Webhook-Timestamp header, a dot and the body, keyed with the secret. The secret is only returned here.
+// @Tags proposal webhook
+// @Accept json
+// @Produce json
+// @Param create_webhook_request body CreateWebhookRequest true "URL and events of the webhook"
+// @Success 201 {object} response.Response{Data=entity.Webhook}
+// @Failure 400 {object} response.Response{Data=FieldErrorsResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks [post]
+// @Security JWTToken
+func (p *ProposalController) CreateWebhook(c echo.Context) error {
+	var req CreateWebhookRequest
+
+	if err := c.Bind(&req); err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return p.WriteValidationError(c, err)
+	}
+
+	var fields []validation.FieldError
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		fields = append(fields, validation.FieldError{
+			Field:   "url",
+			Code:    validation.CodeURL,
+			Message: "must be an absolute http or https URL",
+		})
+	} else if err := p.Webhooks.CheckURL(req.URL); err != nil {
+		fields = append(fields, validation.FieldError{
+			Field:   "url",
+			Code:    validation.CodeURL,
+			Message: "must resolve to a public address",
+		})
+	}
+	if len(req.Events) == 0 {
+		fields = append(fields, validation.FieldError{
+			Field:   "events",
+			Code:    validation.CodeRequired,
+			Message: "is required",
+		})
+	}
+	for i, event := range req.Events {
+		if !knownWebhookEvent(event) {
+			fields = append(fields, validation.FieldError{
+				Field:   fmt.Sprintf("events[%d]", i),
+				Code:    validation.CodeOneOf,
+				Message: "must be * or one of " + strings.Join(entity.WebhookEvents, ", "),
+			})
+		}
+	}
+	if len(fields) > 0 {
+		return p.WriteFieldErrors(c, fields)
+	}
+
+	actor, err := p.RequestActor(c)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong.",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	webhook := entity.Webhook{
+		ID:        uuid.New(),
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    hex.EncodeToString(secret),
+		Active:    true,
+		CreatedBy: actor.UserID,
+		// Cassandra stores timestamps with millisecond precision
+		CreatedAt: time.Now().Truncate(time.Millisecond),
+	}
+
+	err = webhooksRepository.AddWebhook(p.Session, webhook)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteCreated(c, "/api/v1/user/proposal/webhooks/"+webhook.ID.String()+"/deliveries", webhook)
+}
+
+func knownWebhookEvent(event string) bool {
+	if event == entity.WebhookAllEvents {
+		return true
+	}
+	for _, known := range entity.WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetWebhooks
+// @Summary All webhooks
+// @Description All webhooks without their secrets - for only admin
+// @Tags proposal webhook
+// @Produce json
+// @Success 200 {object} response.Response{Data=[]entity.Webhook}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks [get]
+// @Security JWTToken
+func (p *ProposalController) GetWebhooks(c echo.Context) error {
+	webhooks, err := webhooksRepository.GetWebhooks(p.Session)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	result := []entity.Webhook{}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+		result = append(result, webhook)
+	}
+
+	return p.WriteSuccess(c, result)
+}
+
+// PauseWebhook
+// @Summary Pause a webhook
+// @Description Stop sending events to the webhook until it is resumed - for only admin. Events whose retries are pending become dead letters.
+// @Tags proposal webhook
+// @Produce json
+// @Param id path string true "webhook id"
+// @Success 200 {object} response.Response{Data=bool}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks/:id/pause [post]
+// @Security JWTToken
+func (p *ProposalController) PauseWebhook(c echo.Context) error {
+	return p.setWebhookActive(c, false)
+}
+
+// ResumeWebhook
+// @Summary Resume a webhook
+// @Description Send events to a paused webhook again - for only admin. Events that happened in the meantime are not sent.
+// @Tags proposal webhook
+// @Produce json
+// @Param id path string true "webhook id"
+// @Success 200 {object} response.Response{Data=bool}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks/:id/resume [post]
+// @Security JWTToken
+func (p *ProposalController) ResumeWebhook(c echo.Context) error {
+	return p.setWebhookActive(c, true)
+}
+
+func (p *ProposalController) setWebhookActive(c echo.Context, active bool) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	found, err := webhooksRepository.SetWebhookActive(p.Session, id, active)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if !found {
+		return p.WriteNotFound(c, "Webhook not found")
+	}
+
+	return p.WriteSuccess(c, active)
+}
+
+// DeleteWebhook
+// @Summary Delete a webhook
+// @Description Delete the webhook with its delivery log and dead letters - for only admin
+// @Tags proposal webhook
+// @Produce json
+// @Param id path string true "webhook id"
+// @Success 200 {object} response.Response{Data=string}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks/:id [delete]
+// @Security JWTToken
+func (p *ProposalController) DeleteWebhook(c echo.Context) error {
+	webhook, ok, err := p.requestWebhook(c)
+	if !ok {
+		return err
+	}
+
+	err = webhooksRepository.DeleteWebhook(p.Session, webhook.ID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, "Webhook deleted successfully")
+}
+
+// GetWebhookDeliveries
+// @Summary Delivery log of a webhook
+// @Description Every attempt to send an event to the webhook in the last 30 days, newest first - for only admin
+// @Tags proposal webhook
+// @Produce json
+// @Param id path string true "webhook id"
+// @Param limit query int false "at most this many attempts, default 50, up to 200"
+// @Param before query string false "delivery id, only older attempts, the next of the previous page"
+// @Success 200 {object} response.Response{Data=DeliveriesResponse}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks/:id/deliveries [get]
+// @Security JWTToken
+func (p *ProposalController) GetWebhookDeliveries(c echo.Context) error {
+	limit := 50
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > MaxDeliveriesLimit {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "limit must be a number between 1 and " + strconv.Itoa(MaxDeliveriesLimit),
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		limit = n
+	}
+
+	var before uuid.UUID
+	if value := c.QueryParam("before"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			resp := response.ErrorResponse{
+				ErrorCode: 400,
+				Message:   "before must be a delivery id",
+			}
+			message := "false"
+			return p.WriteBadRequest(c, message, resp)
+		}
+		before = id
+	}
+
+	webhook, ok, err := p.requestWebhook(c)
+	if !ok {
+		return err
+	}
+
+	deliveries, err := webhooksRepository.GetDeliveries(p.Session, webhook.ID, before, limit)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	result := DeliveriesResponse{Deliveries: deliveries}
+	if result.Deliveries == nil {
+		result.Deliveries = []entity.WebhookDelivery{}
+	}
+	if len(deliveries) == limit {
+		next := deliveries[len(deliveries)-1].ID
+		result.Next = &next
+	}
+
+	return p.WriteSuccess(c, result)
+}
+
+// GetWebhookDeadLetters
+// @Summary Dead letters of a webhook
+// @Description The events that could not be sent to the webhook within the allowed attempts - for only admin
+// @Tags proposal webhook
+// @Produce json
+// @Param id path string true "webhook id"
+// @Success 200 {object} response.Response{Data=[]entity.WebhookDeadLetter}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks/:id/dead-letters [get]
+// @Security JWTToken
+func (p *ProposalController) GetWebhookDeadLetters(c echo.Context) error {
+	webhook, ok, err := p.requestWebhook(c)
+	if !ok {
+		return err
+	}
+
+	letters, err := webhooksRepository.GetDeadLetters(p.Session, webhook.ID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if letters == nil {
+		letters = []entity.WebhookDeadLetter{}
+	}
+
+	return p.WriteSuccess(c, letters)
+}
+
+// RedeliverWebhookEvent
+// @Summary Send a dead letter again
+// @Description Make one more attempt to send a dead letter to its webhook - for only admin. The dead letter is removed once the webhook accepts it.
+// @Tags proposal webhook
+// @Produce json
+// @Param id path string true "webhook id"
+// @Param event-id path string true "id of the event"
+// @Success 200 {object} response.Response{Data=entity.WebhookDelivery}
+// @Failure 400 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 404 {object} response.Response{Data=response.ErrorResponse}
+// @Failure 500 {object} response.Response{Data=response.ErrorResponse}
+// @Router /proposal/webhooks/:id/dead-letters/:event-id/redeliver [post]
+// @Security JWTToken
+func (p *ProposalController) RedeliverWebhookEvent(c echo.Context) error {
+	eventID, err := uuid.Parse(c.Param("event-id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return p.WriteBadRequest(c, message, resp)
+	}
+
+	webhook, ok, err := p.requestWebhook(c)
+	if !ok {
+		return err
+	}
+
+	letter, err := webhooksRepository.GetDeadLetter(p.Session, webhook.ID, eventID)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+	if letter == nil {
+		return p.WriteNotFound(c, "Dead letter not found")
+	}
+
+	delivery, err := p.Webhooks.Redeliver(*webhook, *letter)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return p.WriteInternalServerError(c, message, resp, "")
+	}
+
+	return p.WriteSuccess(c, delivery)
+}
+
+// requestWebhook reads the webhook named by the id path parameter. If it is
+// invalid or unknown the request is answered, ok is false and err is what
+// the handler returns.
+func (p *ProposalController) requestWebhook(c echo.Context) (webhook *entity.Webhook, ok bool, err error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 400,
+			Message:   "Please check your request again for errors",
+		}
+		message := "false"
+		return nil, false, p.WriteBadRequest(c, message, resp)
+	}
+
+	webhook, err = webhooksRepository.GetWebhook(p.Session, id)
+	if err != nil {
+		resp := response.ErrorResponse{
+			ErrorCode: 500,
+			Message:   "Something went wrong",
+		}
+		message := "false"
+		return nil, false, p.WriteInternalServerError(c, message, resp, "")
+	}
+	if webhook == nil {
+		return nil, false, p.WriteNotFound(c, "Webhook not found")
+	}
+
+	return webhook, true, nil
+}
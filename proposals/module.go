@@ -3,27 +3,93 @@ package user
 import (
 	"github.com/gocql/gocql"
 	tokenSessionRepository "github.com/windswept321/smartest-city-roadmap-go/module/tokensession/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/notifications"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/controller"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/ratelimit"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/validation"
 
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
+// Rate limits of the proposal routes, applied per user and per client IP.
+var (
+	CreateRateLimit = ratelimit.Config{Name: "proposal-create", UserRate: ratelimit.PerMinute(5), IPRate: ratelimit.PerMinute(20)}
+	UpdateRateLimit = ratelimit.Config{Name: "proposal-update", UserRate: ratelimit.PerMinute(20), IPRate: ratelimit.PerMinute(60)}
+	VoteRateLimit   = ratelimit.Config{Name: "proposal-vote", UserRate: ratelimit.PerMinute(30), IPRate: ratelimit.PerMinute(120)}
+	ReportRateLimit = ratelimit.Config{Name: "proposal-report", UserRate: ratelimit.PerMinute(10), IPRate: ratelimit.PerMinute(30)}
+)
+
 //
 //
 func Initialize(e *echo.Echo, db *gorm.DB, session *gocql.Session, casbinMdw echo.MiddlewareFunc, apiKeyMdw echo.MiddlewareFunc) {
 	tokenSessionRepository := tokenSessionRepository.NewTokenSessionRepository(db)
 	proposalController := controller.NewProposalController(tokenSessionRepository, session)
+	proposalController.Moderator = controller.ModeratorCheck(e, casbinMdw)
+
+	if e.Validator == nil {
+		e.Validator = validation.New()
+	}
+	if e.IPExtractor == nil {
+		extractor, err := ratelimit.IPExtractorFromEnv()
+		if err != nil {
+			// refuse to start rather than limit by spoofable addresses
+			panic("rate limit: " + err.Error())
+		}
+		e.IPExtractor = extractor
+	}
+
+	// the limits run after casbinMdw, so users are told apart by the user id
+	// of their token
+	createLimit := ratelimit.New(CreateRateLimit.WithUserKey(proposalController.RateLimitUser))
+	updateLimit := ratelimit.New(UpdateRateLimit.WithUserKey(proposalController.RateLimitUser))
+	voteLimit := ratelimit.New(VoteRateLimit.WithUserKey(proposalController.RateLimitUser))
+	reportLimit := ratelimit.New(ReportRateLimit.WithUserKey(proposalController.RateLimitUser))
 
 	proposal := e.Group("api/v1/user/proposal")
-	proposal.POST("/create", proposalController.WriteProposal, casbinMdw)
+	proposal.POST("/create", proposalController.WriteProposal, casbinMdw, createLimit)
 	proposal.GET("/getAll", proposalController.GetAllProposals, apiKeyMdw)
 	proposal.GET("/get/:id", proposalController.GetProposalByProposalID, apiKeyMdw)
 	proposal.GET("/get/time", proposalController.GetProposalByTimeCreated, apiKeyMdw)
 	proposal.GET("/get/user-id/:id", proposalController.GetProposalsByUserID, apiKeyMdw)
-	proposal.PUT("/update", proposalController.UpdateProposal, casbinMdw)
+	proposal.GET("/stream/:id", proposalController.StreamProposal, apiKeyMdw)
+	proposal.PUT("/update", proposalController.UpdateProposal, casbinMdw, updateLimit)
+	proposal.PATCH("/update/:id", proposalController.PatchProposal, casbinMdw, updateLimit)
 	proposal.DELETE("/delete/:id", proposalController.DeleteProposal, casbinMdw)
+	proposal.POST("/deleteAll/dry-run", proposalController.DeleteAllProposalsDryRun, casbinMdw)
 	proposal.DELETE("/deleteAll", proposalController.DeleteAllProposals, casbinMdw)
-	proposal.PUT("/upvote/:id", proposalController.UpvoteProposal, casbinMdw)
-	proposal.PUT("/downvote/:id", proposalController.DownvoteProposal, casbinMdw)
+	proposal.PUT("/upvote/:id", proposalController.UpvoteProposal, casbinMdw, voteLimit)
+	proposal.PUT("/downvote/:id", proposalController.DownvoteProposal, casbinMdw, voteLimit)
+	proposal.GET("/cache/stats", proposalController.GetCacheStats, casbinMdw)
+	proposal.POST("/bulk/create", proposalController.BulkCreateProposals, casbinMdw)
+	proposal.POST("/bulk/delete", proposalController.BulkDeleteProposals, casbinMdw)
+	proposal.POST("/bulk/status", proposalController.BulkChangeProposalStatus, casbinMdw)
+	proposal.GET("/export", proposalController.ExportProposals, casbinMdw)
+	proposal.GET("/audit", proposalController.GetAuditLog, casbinMdw)
+	proposal.POST("/report/:id", proposalController.ReportProposal, casbinMdw, reportLimit)
+	proposal.GET("/moderation/queue", proposalController.GetModerationQueue, casbinMdw)
+	proposal.POST("/moderation/resolve", proposalController.ResolveReport, casbinMdw)
+	proposal.GET("/moderation/warnings/:user-id", proposalController.GetUserWarnings, casbinMdw)
+	proposal.POST("/follow/:id", proposalController.FollowProposal, casbinMdw)
+	proposal.DELETE("/follow/:id", proposalController.UnfollowProposal, casbinMdw)
+	proposal.GET("/notifications", proposalController.GetNotifications, casbinMdw)
+	proposal.POST("/notifications/read", proposalController.MarkNotificationsRead, casbinMdw)
+	proposal.POST("/webhooks", proposalController.CreateWebhook, casbinMdw)
+	proposal.GET("/webhooks", proposalController.GetWebhooks, casbinMdw)
+	proposal.DELETE("/webhooks/:id", proposalController.DeleteWebhook, casbinMdw)
+	proposal.POST("/webhooks/:id/pause", proposalController.PauseWebhook, casbinMdw)
+	proposal.POST("/webhooks/:id/resume", proposalController.ResumeWebhook, casbinMdw)
+	proposal.GET("/webhooks/:id/deliveries", proposalController.GetWebhookDeliveries, casbinMdw)
+	proposal.GET("/webhooks/:id/dead-letters", proposalController.GetWebhookDeadLetters, casbinMdw)
+	proposal.POST("/webhooks/:id/dead-letters/:event-id/redeliver", proposalController.RedeliverWebhookEvent, casbinMdw)
+
+	notifications.Start(session, func(err error) {
+		e.Logger.Errorf("notifying: %v", err)
+	})
+	proposalController.Webhooks.Start(func(err error) {
+		e.Logger.Errorf("sending webhooks: %v", err)
+	})
+	// the deliveries left stay stored for the next start
+	e.Server.RegisterOnShutdown(proposalController.Webhooks.Stop)
+	proposalController.Live.Start()
 }
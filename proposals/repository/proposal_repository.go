@@ -1,39 +1,241 @@
 package repository
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/google/uuid"
+	auditRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/audit/repository"
 	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/events"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/markup"
+	mentionsRepository "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/mentions/repository"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/proposals/cache"
 )
 
-func StoreProposal(session *gocql.Session, title string, proposalText string, userID uuid.UUID, username, firstname, lastname string) error {
+var (
+	ErrProposalNotFound = errors.New("proposal not found")
+	// ErrProposalConflict means the proposal was changed after the caller read it.
+	ErrProposalConflict = errors.New("proposal was modified by another request")
+)
+
+// proposalCache sits in front of GetProposalByProposalID. Every function that
+// changes a proposal row invalidates the proposal's entry.
+var proposalCache cache.Cache = cache.NewLRU(10000, time.Minute)
+
+var cacheMetrics cache.Metrics
+
+// SetCache replaces the proposal cache, e.g. with one shared between instances.
+func SetCache(c cache.Cache) {
+	proposalCache = c
+}
+
+// CacheStats returns the hit and miss counts of the proposal cache.
+func CacheStats() cache.Stats {
+	return cacheMetrics.Stats()
+}
+
+// audit records a change of a proposal in the audit log and publishes it. The
+// change is stored by then, so a failed audit write does not fail it.
+func audit(session *gocql.Session, actor entity.Actor, action string, proposalID uuid.UUID, before, after interface{}) {
+	_ = auditRepository.Log(session, actor, action, entity.AuditTargetProposal, proposalID, uuid.Nil, before, after)
+	events.Publish(events.Event{Action: action, TargetType: entity.AuditTargetProposal, ProposalID: proposalID, Actor: actor, Before: before, After: after})
+}
+
+func StoreProposal(session *gocql.Session, actor entity.Actor, title string, proposalText string, userID uuid.UUID, username, firstname, lastname string, hidden bool) (entity.Proposal, error) {
+	return StoreProposalWithID(session, actor, gocql.TimeUUID(), title, proposalText, userID, username, firstname, lastname, entity.ProposalStatusOpen, hidden)
+}
 
-	updateTime := time.Now()
-	id := gocql.UUIDFromTime(time.Now())
+// StoreProposalWithID stores a proposal under an id generated by the caller
+// and returns it. The proposal's created_at is taken from the timeuuid, an
+// empty status is open. A proposal held for moderation is stored hidden, so
+// it is never listed before a moderator saw it.
+func StoreProposalWithID(session *gocql.Session, actor entity.Actor, id gocql.UUID, title string, proposalText string, userID uuid.UUID, username, firstname, lastname, status string, hidden bool) (entity.Proposal, error) {
+	if status == "" {
+		status = entity.ProposalStatusOpen
+	}
+
+	// Cassandra stores timestamps with millisecond precision
+	updateTime := id.Time().Truncate(time.Millisecond)
+
+	title = markup.Sanitize(title)
+	proposalText = markup.Sanitize(proposalText)
+	proposalHTML, proposalPlain := markup.Render(proposalText)
 
-	err := session.Query(`INSERT INTO proposals_by_id(user_id, id, username, title, proposal_text, created_at, last_updated, upvotes, downvotes, no_of_comments, firstname, lastname) VALUES 
-					(?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?);`, gocql.UUID(userID), id, username, title, proposalText, updateTime, updateTime, firstname, lastname).Exec()
+	err := session.Query(`INSERT INTO proposals_by_id(user_id, id, username, title, proposal_text, proposal_html, proposal_plain, created_at, last_updated, upvotes, downvotes, no_of_comments, firstname, lastname, status, hidden) VALUES 
+					(?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?, ?, ?);`, gocql.UUID(userID), id, username, title, proposalText, proposalHTML, proposalPlain, updateTime, updateTime, firstname, lastname, status, hidden).Exec()
 
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
 	}
 
-	err = session.Query(`INSERT INTO proposals_by_user_id(user_id, id, username, title, proposal_text, created_at, last_updated, upvotes, downvotes, no_of_comments, firstname, lastname) VALUES 
-					(?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?);`, gocql.UUID(userID), id, username, title, proposalText, updateTime, updateTime, firstname, lastname).Exec()
+	err = session.Query(`INSERT INTO proposals_by_user_id(user_id, id, username, title, proposal_text, proposal_html, proposal_plain, created_at, last_updated, upvotes, downvotes, no_of_comments, firstname, lastname, status, hidden) VALUES 
+					(?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?, ?, ?);`, gocql.UUID(userID), id, username, title, proposalText, proposalHTML, proposalPlain, updateTime, updateTime, firstname, lastname, status, hidden).Exec()
 
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
 	}
 
-	err = session.Query(`INSERT INTO proposals_by_created_at(user_id, id, username, title, proposal_text, created_at, last_updated, upvotes, downvotes, no_of_comments, firstname, lastname) VALUES 
-					(?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?);`, gocql.UUID(userID), id, username, title, proposalText, updateTime, updateTime, firstname, lastname).Exec()
+	err = session.Query(`INSERT INTO proposals_by_created_at(user_id, id, username, title, proposal_text, proposal_html, proposal_plain, created_at, last_updated, upvotes, downvotes, no_of_comments, firstname, lastname, status, hidden) VALUES 
+					(?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?, ?, ?);`, gocql.UUID(userID), id, username, title, proposalText, proposalHTML, proposalPlain, updateTime, updateTime, firstname, lastname, status, hidden).Exec()
 
-	return err
+	if err != nil {
+		return entity.Proposal{}, err
+	}
+
+	proposal := entity.Proposal{
+		ID:           uuid.UUID(id),
+		Title:        title,
+		ProposalText: proposalText,
+		ProposalHTML: proposalHTML,
+		UserID:       userID,
+		Username:     username,
+		FirstName:    firstname,
+		LastName:     lastname,
+		Status:       status,
+		Hidden:       hidden,
+		CreatedAt:    updateTime,
+		LastUpdated:  updateTime,
+
+		ProposalPlain: proposalPlain,
+	}
+	audit(session, actor, entity.AuditCreate, proposal.ID, nil, proposal)
+	// the author can be mentioned from now on
+	_ = mentionsRepository.RememberUser(session, userID, username)
+
+	return proposal, nil
+}
+
+// ProposalTables are the denormalized copies of every proposal. Each of them
+// has id, created_at, user_id and username in its primary key, so a row is
+// found in any of them by the same four columns. proposals_by_id is the
+// copy the others are repaired from.
+var ProposalTables = []string{"proposals_by_id", "proposals_by_user_id", "proposals_by_created_at"}
+
+// InsertProposal writes a complete proposal, counters and timestamps
+// included, to every proposal table. Importing existing data uses it, new
+// proposals go through StoreProposal. Writing the same proposal twice
+// overwrites the first copy.
+func InsertProposal(session *gocql.Session, actor entity.Actor, proposal entity.Proposal) error {
+	for _, table := range ProposalTables {
+		if err := WriteProposalRow(session, table, proposal); err != nil {
+			return err
+		}
+	}
+	audit(session, actor, entity.AuditImport, proposal.ID, nil, proposal)
+	_ = mentionsRepository.RememberUser(session, proposal.UserID, proposal.Username)
+
+	return nil
 }
 
-// GetAllProposals returns all stored proposals starting with the most recently created
+// The row functions below repair and remove single copies for the
+// consistency checker and the cascading delete. They are not audited, the
+// operations built on them record what they changed.
+
+// WriteProposalRow writes a complete proposal to one of ProposalTables.
+func WriteProposalRow(session *gocql.Session, table string, proposal entity.Proposal) error {
+	defer proposalCache.Delete(proposal.ID)
+
+	// imported and restored proposals carry only their source
+	if proposal.ProposalHTML == "" || proposal.ProposalPlain == "" {
+		proposal.ProposalHTML, proposal.ProposalPlain = markup.Render(proposal.ProposalText)
+	}
+
+	return session.Query(`INSERT INTO `+table+`(user_id, id, username, title, proposal_text, proposal_html, proposal_plain, created_at, last_updated, upvotes, downvotes, no_of_comments, firstname, lastname, status, hidden) VALUES 
+					(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, gocql.UUID(proposal.UserID), gocql.UUID(proposal.ID), proposal.Username, proposal.Title, proposal.ProposalText,
+		proposal.ProposalHTML, proposal.ProposalPlain, proposal.CreatedAt, proposal.LastUpdated, proposal.UpVotes, proposal.DownVotes, proposal.NoOfComments, proposal.FirstName, proposal.LastName, proposal.Status, proposal.Hidden).Exec()
+}
+
+// GetProposalRow reads the row of proposal from one of ProposalTables by its
+// primary key. It returns nil if the table has no such row.
+func GetProposalRow(session *gocql.Session, table string, proposal entity.Proposal) (*entity.Proposal, error) {
+	var row *entity.Proposal
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM `+table+`
+							WHERE id=? AND created_at=? AND user_id=? AND username=?;`,
+		gocql.UUID(proposal.ID), proposal.CreatedAt, gocql.UUID(proposal.UserID), proposal.Username).Iter()
+
+	for iter.MapScan(m) {
+		scanned := proposalFromMap(m)
+		row = &scanned
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return row, err
+}
+
+// DeleteProposalRow removes the row of proposal from one of ProposalTables only.
+func DeleteProposalRow(session *gocql.Session, table string, proposal entity.Proposal) error {
+	defer proposalCache.Delete(proposal.ID)
+
+	return session.Query(`DELETE FROM `+table+`
+							WHERE id=? AND created_at=? AND user_id=? AND username=?;`,
+		gocql.UUID(proposal.ID), proposal.CreatedAt, gocql.UUID(proposal.UserID), proposal.Username).Exec()
+}
+
+// IterateProposalRows calls fn for every row of one of ProposalTables, in
+// token order. It stops at the first error fn returns.
+func IterateProposalRows(session *gocql.Session, table string, fn func(entity.Proposal) error) error {
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM ` + table + `;`).Iter()
+
+	for iter.MapScan(m) {
+		if err := fn(proposalFromMap(m)); err != nil {
+			iter.Close()
+			return err
+		}
+		m = map[string]interface{}{}
+	}
+
+	return iter.Close()
+}
+
+// BackfillProposalMarkup stores the HTML and plain renderings of the rows of
+// ProposalTables written before they were stored, and returns how many rows
+// it updated. It only writes the two renderings, so it can run while the
+// proposals are in use.
+func BackfillProposalMarkup(session *gocql.Session) (int, error) {
+	updated := 0
+
+	for _, table := range ProposalTables {
+		err := IterateProposalRows(session, table, func(proposal entity.Proposal) error {
+			if proposal.ProposalHTML != "" && proposal.ProposalPlain != "" {
+				return nil
+			}
+
+			proposalHTML, proposalPlain := markup.Render(proposal.ProposalText)
+			// IF EXISTS keeps a row deleted meanwhile from coming back
+			applied, err := session.Query(`UPDATE `+table+` SET proposal_html=?, proposal_plain=?
+							WHERE id=? AND created_at=? AND user_id=? AND username=?
+							IF EXISTS`, proposalHTML, proposalPlain,
+				gocql.UUID(proposal.ID), proposal.CreatedAt, gocql.UUID(proposal.UserID), proposal.Username).MapScanCAS(map[string]interface{}{})
+			if err != nil {
+				return err
+			}
+
+			proposalCache.Delete(proposal.ID)
+			if applied {
+				updated++
+			}
+			return nil
+		})
+		if err != nil {
+			return updated, err
+		}
+	}
+
+	return updated, nil
+}
+
+// GetAllProposals returns all stored proposals starting with the most recently
+// created. Proposals hidden by a moderator are left out, as by the other
+// listings.
 func GetAllProposals(session *gocql.Session) ([]entity.Proposal, error) {
 	var proposals []entity.Proposal
 	var inversedProposals []entity.Proposal
@@ -43,20 +245,9 @@ func GetAllProposals(session *gocql.Session) ([]entity.Proposal, error) {
 	iter := session.Query(`SELECT * FROM proposals_by_created_at;`).Iter()
 
 	for iter.MapScan(m) {
-		proposals = append(proposals, entity.Proposal{
-			ID:           uuid.UUID(m["id"].(gocql.UUID)),
-			Title:        m["title"].(string),
-			ProposalText: m["proposal_text"].(string),
-			UserID:       uuid.UUID(m["user_id"].(gocql.UUID)),
-			Username:     m["username"].(string),
-			FirstName:    m["firstname"].(string),
-			LastName:     m["lastname"].(string),
-			UpVotes:      m["upvotes"].(int),
-			DownVotes:    m["downvotes"].(int),
-			NoOfComments: m["no_of_comments"].(int),
-			CreatedAt:    m["created_at"].(time.Time),
-			LastUpdated:  m["last_updated"].(time.Time),
-		})
+		if proposal := proposalFromMap(m); !proposal.Hidden {
+			proposals = append(proposals, proposal)
+		}
 		m = map[string]interface{}{}
 	}
 
@@ -81,20 +272,9 @@ func GetProposalsByUserID(session *gocql.Session, userID uuid.UUID) ([]entity.Pr
 							ORDER BY created_at DESC;`, gocql.UUID(userID)).Iter()
 
 	for iter.MapScan(m) {
-		proposals = append(proposals, entity.Proposal{
-			ID:           uuid.UUID(m["id"].(gocql.UUID)),
-			Title:        m["title"].(string),
-			ProposalText: m["proposal_text"].(string),
-			UserID:       uuid.UUID(m["user_id"].(gocql.UUID)),
-			Username:     m["username"].(string),
-			FirstName:    m["firstname"].(string),
-			LastName:     m["lastname"].(string),
-			UpVotes:      m["upvotes"].(int),
-			DownVotes:    m["downvotes"].(int),
-			NoOfComments: m["no_of_comments"].(int),
-			CreatedAt:    m["created_at"].(time.Time),
-			LastUpdated:  m["last_updated"].(time.Time),
-		})
+		if proposal := proposalFromMap(m); !proposal.Hidden {
+			proposals = append(proposals, proposal)
+		}
 		m = map[string]interface{}{}
 	}
 
@@ -114,20 +294,9 @@ func GetProposalsByTimeCreated(session *gocql.Session, dateFrom time.Time, dateT
 							ALLOW FILTERING;`, dateFrom, dateTo).Iter()
 
 	for iter.MapScan(m) {
-		proposals = append(proposals, entity.Proposal{
-			ID:           uuid.UUID(m["id"].(gocql.UUID)),
-			Title:        m["title"].(string),
-			ProposalText: m["proposal_text"].(string),
-			UserID:       uuid.UUID(m["user_id"].(gocql.UUID)),
-			Username:     m["username"].(string),
-			FirstName:    m["firstname"].(string),
-			LastName:     m["lastname"].(string),
-			UpVotes:      m["upvotes"].(int),
-			DownVotes:    m["downvotes"].(int),
-			NoOfComments: m["no_of_comments"].(int),
-			CreatedAt:    m["created_at"].(time.Time),
-			LastUpdated:  m["last_updated"].(time.Time),
-		})
+		if proposal := proposalFromMap(m); !proposal.Hidden {
+			proposals = append(proposals, proposal)
+		}
 		m = map[string]interface{}{}
 	}
 
@@ -143,27 +312,100 @@ func GetProposalsByTimeCreated(session *gocql.Session, dateFrom time.Time, dateT
 	return inversedProposals, err
 }
 
+// ProposalFilter narrows the proposals IterateProposals visits. Zero fields
+// match every proposal.
+type ProposalFilter struct {
+	From   time.Time
+	To     time.Time
+	UserID uuid.UUID
+	Status string
+}
+
+// IterateProposals calls fn for every proposal matching filter, reading the
+// rows page by page instead of loading the whole table. Proposals of a single
+// user come from proposals_by_user_id, all others from proposals_by_created_at.
+// It stops at the first error fn returns.
+func IterateProposals(session *gocql.Session, filter ProposalFilter, fn func(entity.Proposal) error) error {
+	var conditions []string
+	var values []interface{}
+
+	table := "proposals_by_created_at"
+	if filter.UserID != uuid.Nil {
+		table = "proposals_by_user_id"
+		conditions = append(conditions, "user_id=?")
+		values = append(values, gocql.UUID(filter.UserID))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_at>=?")
+		values = append(values, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_at<=?")
+		values = append(values, filter.To)
+	}
+
+	stmt := "SELECT * FROM " + table
+	if len(conditions) > 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+		if filter.UserID == uuid.Nil {
+			stmt += " ALLOW FILTERING"
+		}
+	}
+
+	var m = map[string]interface{}{}
+
+	iter := session.Query(stmt+";", values...).Iter()
+
+	for iter.MapScan(m) {
+		proposal := proposalFromMap(m)
+		m = map[string]interface{}{}
+
+		if filter.Status != "" && proposal.Status != filter.Status {
+			continue
+		}
+
+		if err := fn(proposal); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+// GetProposalByProposalID returns the proposal from the cache, reading it from
+// proposals_by_id on a miss.
 func GetProposalByProposalID(session *gocql.Session, proposalID uuid.UUID) ([]entity.Proposal, error) {
+	if proposal, ok := proposalCache.Get(proposalID); ok {
+		cacheMetrics.Hit()
+		return []entity.Proposal{proposal}, nil
+	}
+	cacheMetrics.Miss()
+
+	proposals, err := queryProposalByID(session, proposalID)
+	if err == nil && len(proposals) > 0 {
+		proposalCache.Set(proposalID, proposals[0])
+	}
+
+	return proposals, err
+}
+
+// GetLatestProposal reads the proposal from proposals_by_id, bypassing the
+// cache. Use it when the result is the base of an edit.
+func GetLatestProposal(session *gocql.Session, proposalID uuid.UUID) ([]entity.Proposal, error) {
+	return queryProposalByID(session, proposalID)
+}
+
+// queryProposalByID reads the proposal from proposals_by_id, bypassing the
+// cache. Read-modify-write updates use it so they never build on a stale copy.
+func queryProposalByID(session *gocql.Session, proposalID uuid.UUID) ([]entity.Proposal, error) {
 	var proposals []entity.Proposal
 	var m = map[string]interface{}{}
 
 	iter := session.Query(`SELECT * FROM proposals_by_id WHERE id=? LIMIT 1;`, gocql.UUID(proposalID)).Iter()
 
 	for iter.MapScan(m) {
-		proposals = append(proposals, entity.Proposal{
-			ID:           uuid.UUID(m["id"].(gocql.UUID)),
-			Title:        m["title"].(string),
-			ProposalText: m["proposal_text"].(string),
-			UserID:       uuid.UUID(m["user_id"].(gocql.UUID)),
-			Username:     m["username"].(string),
-			FirstName:    m["firstname"].(string),
-			LastName:     m["lastname"].(string),
-			UpVotes:      m["upvotes"].(int),
-			DownVotes:    m["downvotes"].(int),
-			NoOfComments: m["no_of_comments"].(int),
-			CreatedAt:    m["created_at"].(time.Time),
-			LastUpdated:  m["last_updated"].(time.Time),
-		})
+		proposals = append(proposals, proposalFromMap(m))
 		m = map[string]interface{}{}
 	}
 
@@ -172,61 +414,113 @@ func GetProposalByProposalID(session *gocql.Session, proposalID uuid.UUID) ([]en
 	return proposals, err
 }
 
-func UpdateProposal(session *gocql.Session, proposalID uuid.UUID, title, proposalText string) error {
-
-	proposal, err := GetProposalByProposalID(session, proposalID)
-	if err != nil {
-		return err
+// proposalFromMap converts a row scanned from one of the proposal tables.
+func proposalFromMap(m map[string]interface{}) entity.Proposal {
+	// rows written before proposals had a status are open
+	status, _ := m["status"].(string)
+	if status == "" {
+		status = entity.ProposalStatusOpen
+	}
+	hidden, _ := m["hidden"].(bool)
+	// empty on rows written before the renderings were stored, until
+	// BackfillProposalMarkup reached them
+	proposalHTML, _ := m["proposal_html"].(string)
+	proposalPlain, _ := m["proposal_plain"].(string)
+
+	return entity.Proposal{
+		ID:           uuid.UUID(m["id"].(gocql.UUID)),
+		Title:        m["title"].(string),
+		ProposalText: m["proposal_text"].(string),
+		ProposalHTML: proposalHTML,
+		UserID:       uuid.UUID(m["user_id"].(gocql.UUID)),
+		Username:     m["username"].(string),
+		FirstName:    m["firstname"].(string),
+		LastName:     m["lastname"].(string),
+		UpVotes:      m["upvotes"].(int),
+		DownVotes:    m["downvotes"].(int),
+		NoOfComments: m["no_of_comments"].(int),
+		Status:       status,
+		Hidden:       hidden,
+		CreatedAt:    m["created_at"].(time.Time),
+		LastUpdated:  m["last_updated"].(time.Time),
+
+		ProposalPlain: proposalPlain,
 	}
-	updateTime := time.Now()
+}
 
-	err = session.Query(`UPDATE proposals_by_id SET title=?, proposal_text=?, last_updated=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, title, proposalText, updateTime, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+// UpdateProposal changes the title and text of the proposal if its
+// last_updated still equals expectedLastUpdated, and returns the updated
+// proposal. On ErrProposalConflict the current version is returned instead.
+// With hold set the edit was held for moderation and the proposal is hidden
+// in the same write.
+func UpdateProposal(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, title, proposalText string, expectedLastUpdated time.Time, hold bool) (entity.Proposal, error) {
+	defer proposalCache.Delete(proposalID)
 
+	proposal, err := queryProposalByID(session, proposalID)
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
+	}
+	if len(proposal) == 0 {
+		return entity.Proposal{}, ErrProposalNotFound
 	}
 
-	err = session.Query(`UPDATE proposals_by_created_at SET title=?, proposal_text=?, last_updated=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, title, proposalText, updateTime, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	// Cassandra stores timestamps with millisecond precision
+	updateTime := time.Now().Truncate(time.Millisecond)
 
-	if err != nil {
-		return err
-	}
+	title = markup.Sanitize(title)
+	proposalText = markup.Sanitize(proposalText)
+	proposalHTML, proposalPlain := markup.Render(proposalText)
 
-	err = session.Query(`UPDATE proposals_by_user_id SET title=?, proposal_text=?, last_updated=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, title, proposalText, updateTime, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	hidden := proposal[0].Hidden || hold
 
-	return err
-}
+	applied, err := session.Query(`UPDATE proposals_by_id SET title=?, proposal_text=?, proposal_html=?, proposal_plain=?, last_updated=?, hidden=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?
+							IF last_updated=?`, title, proposalText, proposalHTML, proposalPlain, updateTime, hidden, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username, expectedLastUpdated).MapScanCAS(map[string]interface{}{})
 
-func DeleteProposal(session *gocql.Session, proposalID uuid.UUID) error {
-	proposal, err := GetProposalByProposalID(session, proposalID)
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
 	}
 
-	err = session.Query(`DELETE FROM proposals_by_id
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	if !applied {
+		current, err := queryProposalByID(session, proposalID)
+		if err != nil {
+			return entity.Proposal{}, err
+		}
+		if len(current) == 0 {
+			return entity.Proposal{}, ErrProposalNotFound
+		}
+		return current[0], ErrProposalConflict
+	}
+
+	err = session.Query(`UPDATE proposals_by_created_at SET title=?, proposal_text=?, proposal_html=?, proposal_plain=?, last_updated=?, hidden=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, title, proposalText, proposalHTML, proposalPlain, updateTime, hidden, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
 	}
 
-	err = session.Query(`DELETE FROM proposals_by_created_at
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	err = session.Query(`UPDATE proposals_by_user_id SET title=?, proposal_text=?, proposal_html=?, proposal_plain=?, last_updated=?, hidden=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, title, proposalText, proposalHTML, proposalPlain, updateTime, hidden, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
 	}
 
-	err = session.Query(`DELETE FROM proposals_by_user_id
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	updated := proposal[0]
+	updated.Title = title
+	updated.ProposalText = proposalText
+	updated.ProposalHTML = proposalHTML
+	updated.ProposalPlain = proposalPlain
+	updated.Hidden = hidden
+	updated.LastUpdated = updateTime
+	audit(session, actor, entity.AuditUpdate, proposalID, proposal[0], updated)
 
-	return err
+	return updated, nil
 }
 
-func DeleteAllProposals(session *gocql.Session) error {
+func DeleteAllProposals(session *gocql.Session, actor entity.Actor) error {
+	defer proposalCache.Purge()
+
 	err := session.Query(`TRUNCATE TABLE user_proposals_and_comments.proposals_by_id;`).Exec()
 
 	if err != nil {
@@ -241,15 +535,24 @@ func DeleteAllProposals(session *gocql.Session) error {
 
 	err = session.Query(`TRUNCATE TABLE user_proposals_and_comments.proposals_by_created_at;`).Exec()
 
-	return err
+	if err != nil {
+		return err
+	}
+	audit(session, actor, entity.AuditDeleteAll, uuid.Nil, nil, nil)
+
+	return nil
 }
 
-func UpvoteProposal(session *gocql.Session, proposalID uuid.UUID) error {
+func UpvoteProposal(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID) error {
+	defer proposalCache.Delete(proposalID)
 
-	proposal, err := GetProposalByProposalID(session, proposalID)
+	proposal, err := queryProposalByID(session, proposalID)
 	if err != nil {
 		return err
 	}
+	if len(proposal) == 0 {
+		return ErrProposalNotFound
+	}
 
 	err = session.Query(`UPDATE proposals_by_id SET upvotes=?
 							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].UpVotes+1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
@@ -268,15 +571,27 @@ func UpvoteProposal(session *gocql.Session, proposalID uuid.UUID) error {
 	err = session.Query(`UPDATE proposals_by_user_id SET upvotes=?
 							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].UpVotes+1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	voted := proposal[0]
+	voted.UpVotes++
+	audit(session, actor, entity.AuditUpvote, proposalID, proposal[0], voted)
+
+	return nil
 }
 
-func DownvoteProposal(session *gocql.Session, proposalID uuid.UUID) error {
+func DownvoteProposal(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID) error {
+	defer proposalCache.Delete(proposalID)
 
-	proposal, err := GetProposalByProposalID(session, proposalID)
+	proposal, err := queryProposalByID(session, proposalID)
 	if err != nil {
 		return err
 	}
+	if len(proposal) == 0 {
+		return ErrProposalNotFound
+	}
 
 	err = session.Query(`UPDATE proposals_by_id SET downvotes=?
 							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].DownVotes+1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
@@ -295,84 +610,167 @@ func DownvoteProposal(session *gocql.Session, proposalID uuid.UUID) error {
 	err = session.Query(`UPDATE proposals_by_user_id SET downvotes=?
 							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].DownVotes+1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	voted := proposal[0]
+	voted.DownVotes++
+	audit(session, actor, entity.AuditDownvote, proposalID, proposal[0], voted)
+
+	return nil
 }
 
 func AddToNumberOfComments(session *gocql.Session, proposalID uuid.UUID) error {
+	return AdjustNumberOfComments(session, proposalID, 1)
+}
+
+func SubtractFromNumberOfComments(session *gocql.Session, proposalID uuid.UUID) error {
+	return AdjustNumberOfComments(session, proposalID, -1)
+}
 
-	proposal, err := GetProposalByProposalID(session, proposalID)
+// AdjustNumberOfComments adds delta to the comment count of the proposal.
+// The count never drops below zero.
+func AdjustNumberOfComments(session *gocql.Session, proposalID uuid.UUID, delta int) error {
+	defer proposalCache.Delete(proposalID)
+
+	proposal, err := queryProposalByID(session, proposalID)
 	if err != nil {
 		return err
 	}
+	if len(proposal) == 0 {
+		return ErrProposalNotFound
+	}
+
+	noOfComments := proposal[0].NoOfComments + delta
+	if noOfComments < 0 {
+		noOfComments = 0
+	}
 
 	err = session.Query(`UPDATE proposals_by_id SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].NoOfComments+1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, noOfComments, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
 	if err != nil {
 		return err
 	}
 
 	err = session.Query(`UPDATE proposals_by_user_id SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].NoOfComments+1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, noOfComments, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
 	if err != nil {
 		return err
 	}
 
 	err = session.Query(`UPDATE proposals_by_created_at SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].NoOfComments+1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, noOfComments, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
 	return err
 }
 
-func SubtractFromNumberOfComments(session *gocql.Session, proposalID uuid.UUID) error {
-	proposal, err := GetProposalByProposalID(session, proposalID)
+func SetCommentsToZero(session *gocql.Session, proposalID uuid.UUID) error {
+	return SetNumberOfComments(session, proposalID, 0)
+}
+
+// SetNumberOfComments overwrites the comment count of the proposal in every
+// proposal table, e.g. with the number of comment rows actually stored.
+func SetNumberOfComments(session *gocql.Session, proposalID uuid.UUID, noOfComments int) error {
+	defer proposalCache.Delete(proposalID)
+
+	proposal, err := queryProposalByID(session, proposalID)
 	if err != nil {
 		return err
 	}
+	if len(proposal) == 0 {
+		return ErrProposalNotFound
+	}
 
-	err = session.Query(`UPDATE proposals_by_id SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].NoOfComments-1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	for _, table := range ProposalTables {
+		err = session.Query(`UPDATE `+table+` SET no_of_comments=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, noOfComments, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
 	}
 
-	err = session.Query(`UPDATE proposals_by_user_id SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].NoOfComments-1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	return nil
+}
+
+// UpdateProposalStatus moves the proposal to status and returns it. A status
+// change is not an edit of the proposal, so last_updated is kept.
+func UpdateProposalStatus(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, status string) (entity.Proposal, error) {
+	defer proposalCache.Delete(proposalID)
 
+	proposal, err := queryProposalByID(session, proposalID)
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
+	}
+	if len(proposal) == 0 {
+		return entity.Proposal{}, ErrProposalNotFound
 	}
 
-	err = session.Query(`UPDATE proposals_by_created_at SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, proposal[0].NoOfComments-1, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	err = session.Query(`UPDATE proposals_by_id SET status=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, status, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
-	return err
-}
+	if err != nil {
+		return entity.Proposal{}, err
+	}
+
+	err = session.Query(`UPDATE proposals_by_user_id SET status=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, status, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
-func SetCommentsToZero(session *gocql.Session, proposalID uuid.UUID) error {
-	proposal, err := GetProposalByProposalID(session, proposalID)
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
 	}
 
-	err = session.Query(`UPDATE proposals_by_id SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, 0, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	err = session.Query(`UPDATE proposals_by_created_at SET status=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, status, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
 	}
 
-	err = session.Query(`UPDATE proposals_by_user_id SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, 0, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	updated := proposal[0]
+	updated.Status = status
+	audit(session, actor, entity.AuditStatusChange, proposalID, proposal[0], updated)
+
+	return updated, nil
+}
+
+// SetProposalHidden hides the proposal from the listings or shows it again,
+// and returns it. Like a status change, hiding is not an edit, so
+// last_updated is kept. A proposal already in that state is left alone.
+func SetProposalHidden(session *gocql.Session, actor entity.Actor, proposalID uuid.UUID, hidden bool) (entity.Proposal, error) {
+	defer proposalCache.Delete(proposalID)
 
+	proposal, err := queryProposalByID(session, proposalID)
 	if err != nil {
-		return err
+		return entity.Proposal{}, err
+	}
+	if len(proposal) == 0 {
+		return entity.Proposal{}, ErrProposalNotFound
+	}
+	if proposal[0].Hidden == hidden {
+		return proposal[0], nil
 	}
 
-	err = session.Query(`UPDATE proposals_by_created_at SET no_of_comments=?
-							WHERE id=? AND user_id=? AND created_at=? AND username=?`, 0, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
+	for _, table := range ProposalTables {
+		err = session.Query(`UPDATE `+table+` SET hidden=?
+							WHERE id=? AND user_id=? AND created_at=? AND username=?`, hidden, gocql.UUID(proposalID), gocql.UUID(proposal[0].UserID), proposal[0].CreatedAt, proposal[0].Username).Exec()
 
-	return err
+		if err != nil {
+			return entity.Proposal{}, err
+		}
+	}
+
+	updated := proposal[0]
+	updated.Hidden = hidden
+
+	action := entity.AuditHide
+	if !hidden {
+		action = entity.AuditUnhide
+	}
+	audit(session, actor, action, proposalID, proposal[0], updated)
+
+	return updated, nil
 }
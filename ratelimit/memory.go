@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are dropped from a MemoryBackend.
+const sweepInterval = 10 * time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// MemoryBackend keeps token buckets in process memory.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryBackend) Take(key string, rate Rate) (Result, error) {
+	now := time.Now()
+	capacity := float64(rate.Limit)
+	refillPerSecond := capacity / rate.Period.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now, period: rate.Period}
+		m.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.updated).Seconds() * refillPerSecond
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / refillPerSecond
+		return Result{RetryAfter: time.Duration(wait * float64(time.Second))}, nil
+	}
+
+	b.tokens--
+	return Result{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (m *MemoryBackend) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if now.Sub(b.updated) > b.period {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
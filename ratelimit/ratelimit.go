@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/windswept321/smartest-city-roadmap-go/infrastructure/response"
+)
+
+// Rate is a token bucket of Limit tokens that refills completely every Period.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+func PerSecond(limit int) Rate {
+	return Rate{Limit: limit, Period: time.Second}
+}
+
+func PerMinute(limit int) Rate {
+	return Rate{Limit: limit, Period: time.Minute}
+}
+
+func PerHour(limit int) Rate {
+	return Rate{Limit: limit, Period: time.Hour}
+}
+
+func (r Rate) enabled() bool {
+	return r.Limit > 0 && r.Period > 0
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Backend stores token buckets. The in-memory backend only limits a single
+// instance; a shared store (redis, cassandra, ...) can be plugged in by
+// implementing this interface. Implementations must be safe for concurrent use.
+type Backend interface {
+	Take(key string, rate Rate) (Result, error)
+}
+
+// DefaultBackend is used by every limiter whose Config has no Backend.
+var DefaultBackend Backend = NewMemoryBackend()
+
+type Config struct {
+	// Name namespaces the buckets so routes do not share their limits.
+	Name string
+	// UserRate is applied per authenticated user, IPRate per client IP.
+	// A zero Rate disables that limit.
+	UserRate Rate
+	IPRate   Rate
+	Backend  Backend
+	// UserKey returns the id of the authenticated user of a request, or ""
+	// if there is none. The middleware must then run after authentication.
+	// Without UserKey only IPRate applies.
+	UserKey func(c echo.Context) string
+}
+
+// WithUserKey returns a copy of the config identifying users by key.
+func (config Config) WithUserKey(key func(c echo.Context) string) Config {
+	config.UserKey = key
+	return config
+}
+
+// New returns a middleware enforcing config. Requests over the limit are
+// answered with 429 and a Retry-After header. The IP buckets are keyed by
+// c.RealIP(), so the echo instance needs the IPExtractor of
+// IPExtractorFromEnv; without one echo trusts the headers of any client. Backend errors let the request
+// through so an unavailable store does not take the API down with it.
+func New(config Config) echo.MiddlewareFunc {
+	if config.Backend == nil {
+		config.Backend = DefaultBackend
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.UserRate.enabled() && config.UserKey != nil {
+				if user := config.UserKey(c); user != "" {
+					result, err := config.Backend.Take(config.Name+":user:"+user, config.UserRate)
+					if err == nil && !writeHeaders(c, config.UserRate, result) {
+						return tooManyRequests(c)
+					}
+				}
+			}
+
+			if config.IPRate.enabled() {
+				result, err := config.Backend.Take(config.Name+":ip:"+c.RealIP(), config.IPRate)
+				if err == nil && !writeHeaders(c, config.IPRate, result) {
+					return tooManyRequests(c)
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// IPExtractorFromEnv returns how the client IP keying the IP buckets is read
+// from a request. TRUSTED_PROXIES lists the CIDR ranges of the proxies in
+// front of the service, e.g. 10.0.0.0/8,192.168.0.0/16, and X-Forwarded-For
+// is only followed through them. Unset, the headers are ignored and the IP is
+// the one of the connection, so clients cannot pick their own bucket.
+func IPExtractorFromEnv() (echo.IPExtractor, error) {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if strings.TrimSpace(value) == "" {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	// only the configured ranges are trusted, not echo's default of every
+	// private network
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range strings.Split(value, ",") {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not a CIDR range", cidr)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// writeHeaders sets the rate limit headers for result and reports whether the
+// request is allowed.
+func writeHeaders(c echo.Context, rate Rate, result Result) bool {
+	header := c.Response().Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(rate.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		header.Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+
+	return result.Allowed
+}
+
+func tooManyRequests(c echo.Context) error {
+	resp := response.ErrorResponse{
+		ErrorCode: http.StatusTooManyRequests,
+		Message:   "Too many requests, please try again later",
+	}
+	return c.JSON(http.StatusTooManyRequests, response.Response{Data: resp})
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMemoryBackendTake(t *testing.T) {
+	backend := NewMemoryBackend()
+	rate := PerHour(2)
+
+	for i, wantRemaining := range []int{1, 0} {
+		result, err := backend.Take("a", rate)
+		if err != nil || !result.Allowed || result.Remaining != wantRemaining {
+			t.Fatalf("take %d = %+v, %v", i+1, result, err)
+		}
+	}
+
+	result, _ := backend.Take("a", rate)
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 30*time.Minute {
+		t.Errorf("take over the limit = %+v", result)
+	}
+
+	if result, _ := backend.Take("b", rate); !result.Allowed {
+		t.Errorf("another key was limited: %+v", result)
+	}
+}
+
+// serve sends a request from ip through a limiter and returns the response.
+func serve(limiter echo.MiddlewareFunc, ip, token string) *httptest.ResponseRecorder {
+	return serveVia(limiter, echo.ExtractIPDirect(), ip, token, nil)
+}
+
+// serveVia sends a request with headers from the connection of ip through a
+// limiter on an echo instance reading the client IP with extractor.
+func serveVia(limiter echo.MiddlewareFunc, extractor echo.IPExtractor, ip, token string, headers map[string]string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.IPExtractor = extractor
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.RemoteAddr = ip + ":41234"
+	request.Header.Set("Authorization", token)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+
+	handler := limiter(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	if err := handler(e.NewContext(request, recorder)); err != nil {
+		e.HTTPErrorHandler(err, e.NewContext(request, recorder))
+	}
+
+	return recorder
+}
+
+func TestUserBucketFollowsUserKey(t *testing.T) {
+	users := map[string]string{"token-1": "user-a", "token-2": "user-a", "token-3": "user-b"}
+	config := Config{Name: "test", UserRate: PerHour(2), IPRate: PerHour(100), Backend: NewMemoryBackend()}
+	limiter := New(config.WithUserKey(func(c echo.Context) string {
+		return users[c.Request().Header.Get("Authorization")]
+	}))
+
+	// two tokens of the same user share one bucket
+	for i, token := range []string{"token-1", "token-2"} {
+		if code := serve(limiter, "10.0.0.1", token).Code; code != http.StatusOK {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+	recorder := serve(limiter, "10.0.0.2", "token-1")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the user limit = %d, Retry-After %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+
+	if code := serve(limiter, "10.0.0.1", "token-3").Code; code != http.StatusOK {
+		t.Errorf("another user was limited: %d", code)
+	}
+}
+
+func TestUnknownTokensOnlyGetTheIPBucket(t *testing.T) {
+	config := Config{Name: "test", UserRate: PerHour(100), IPRate: PerHour(2), Backend: NewMemoryBackend()}
+	limiter := New(config.WithUserKey(func(c echo.Context) string { return "" }))
+
+	// rotating junk tokens does not buy new buckets
+	for i, token := range []string{"junk-1", "junk-2"} {
+		if code := serve(limiter, "10.0.0.1", token).Code; code != http.StatusOK {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+	if code := serve(limiter, "10.0.0.1", "junk-3").Code; code != http.StatusTooManyRequests {
+		t.Errorf("request over the IP limit = %d", code)
+	}
+}
+
+func TestWithoutUserKeyOnlyIPRateApplies(t *testing.T) {
+	limiter := New(Config{Name: "test", UserRate: PerHour(1), IPRate: PerHour(5), Backend: NewMemoryBackend()})
+
+	for i := 0; i < 5; i++ {
+		if code := serve(limiter, "10.0.0.1", "token").Code; code != http.StatusOK {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+}
+
+func TestSpoofedHeadersDoNotBuyNewBuckets(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	limiter := New(Config{Name: "test", IPRate: PerHour(2), Backend: NewMemoryBackend()})
+	extractor, err := IPExtractorFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spoofed := []map[string]string{
+		{echo.HeaderXRealIP: "1.1.1.1"},
+		{echo.HeaderXForwardedFor: "2.2.2.2"},
+		{echo.HeaderXRealIP: "3.3.3.3", echo.HeaderXForwardedFor: "3.3.3.3"},
+	}
+	for i, headers := range spoofed[:2] {
+		if code := serveVia(limiter, extractor, "203.0.113.7", "", headers).Code; code != http.StatusOK {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+	if code := serveVia(limiter, extractor, "203.0.113.7", "", spoofed[2]).Code; code != http.StatusTooManyRequests {
+		t.Errorf("request over the IP limit with spoofed headers = %d", code)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.0/24")
+	extractor, err := IPExtractorFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := New(Config{Name: "test", IPRate: PerHour(1), Backend: NewMemoryBackend()})
+
+	// clients behind a trusted proxy get their own buckets
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		headers := map[string]string{echo.HeaderXForwardedFor: client}
+		if code := serveVia(limiter, extractor, "10.1.2.3", "", headers).Code; code != http.StatusOK {
+			t.Errorf("client %s behind the proxy = %d", client, code)
+		}
+	}
+
+	// a client connecting directly cannot claim to be behind the proxy
+	for i, client := range []string{"198.51.100.3", "198.51.100.4"} {
+		headers := map[string]string{echo.HeaderXForwardedFor: client + ", 10.1.2.3"}
+		code := serveVia(limiter, extractor, "203.0.113.7", "", headers).Code
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; code != want {
+			t.Errorf("direct request %d claiming %s = %d, want %d", i+1, client, code, want)
+		}
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0")
+	if _, err := IPExtractorFromEnv(); err == nil {
+		t.Error("IPExtractorFromEnv() accepted an address without a prefix length")
+	}
+}
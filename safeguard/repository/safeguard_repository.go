@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// TokenTTL is how long a confirmation token from a dry run stays valid.
+const TokenTTL = 5 * time.Minute
+
+// Outcomes of a destructive operation.
+const (
+	OutcomeStarted   = "started"
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// NoBackup is the backup of an operation run without a backup configured.
+const NoBackup = "none"
+
+// ErrTokenInvalid means the token is unknown, expired, already used or was
+// issued for another operation or user.
+var ErrTokenInvalid = errors.New("the confirmation token is invalid or expired, request a new one with a dry run")
+
+// Operation records a destructive operation that was run.
+type Operation struct {
+	Name        string         `json:"name"`
+	ID          uuid.UUID      `json:"id"`
+	UserID      uuid.UUID      `json:"user_id"`
+	Username    string         `json:"username"`
+	Environment string         `json:"environment"`
+	Counts      map[string]int `json:"counts"`
+	Backup      string         `json:"backup,omitempty"`
+	Outcome     string         `json:"outcome"`
+	Error       string         `json:"error,omitempty"`
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at,omitempty"`
+}
+
+// CountRows counts the rows of table by paging through its ids, which
+// unlike SELECT COUNT(*) does not time out on large tables. Every proposal
+// and comment table has an id column.
+func CountRows(session *gocql.Session, table string) (int, error) {
+	var count int
+	var id gocql.UUID
+
+	iter := session.Query(`SELECT id FROM ` + table + `;`).Iter()
+
+	for iter.Scan(&id) {
+		count++
+	}
+
+	return count, iter.Close()
+}
+
+// IssueToken stores a new single-use token confirming operation for the
+// user, together with the row counts the user was shown.
+func IssueToken(session *gocql.Session, operation string, userID uuid.UUID, counts map[string]int) (string, time.Time, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(random)
+
+	now := time.Now()
+	err := session.Query(`INSERT INTO confirmation_tokens(token, operation, user_id, counts, created_at)
+							VALUES (?, ?, ?, ?, ?) USING TTL ?;`, token, operation, gocql.UUID(userID), counts, now, int(TokenTTL.Seconds())).Exec()
+
+	return token, now.Add(TokenTTL), err
+}
+
+// ConsumeToken deletes the token if it confirms operation for the user, with
+// a lightweight transaction so it is used at most once.
+func ConsumeToken(session *gocql.Session, token, operation string, userID uuid.UUID) error {
+	applied, err := session.Query(`DELETE FROM confirmation_tokens WHERE token=?
+							IF operation=? AND user_id=?;`, token, operation, gocql.UUID(userID)).MapScanCAS(map[string]interface{}{})
+
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrTokenInvalid
+	}
+
+	return nil
+}
+
+// StartOperation records that op is about to run.
+func StartOperation(session *gocql.Session, op Operation) error {
+	return session.Query(`INSERT INTO destructive_operations(name, id, user_id, username, environment, counts, backup, outcome, started_at)
+							VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, op.Name, gocql.UUID(op.ID), gocql.UUID(op.UserID), op.Username,
+		op.Environment, op.Counts, op.Backup, op.Outcome, op.StartedAt).Exec()
+}
+
+// FinishOperation records the outcome of op.
+func FinishOperation(session *gocql.Session, op Operation) error {
+	return session.Query(`UPDATE destructive_operations SET backup=?, outcome=?, error=?, finished_at=?
+							WHERE name=? AND id=?;`, op.Backup, op.Outcome, op.Error, op.FinishedAt, op.Name, gocql.UUID(op.ID)).Exec()
+}
@@ -0,0 +1,231 @@
+package safeguard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/backup"
+	config "github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/config"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/safeguard/repository"
+)
+
+var ErrEnvironmentNotAllowed = errors.New("destructive operations are disabled in this environment")
+
+// Config decides where destructive operations may run and what is done
+// before they run.
+type Config struct {
+	// Environment is the environment the service runs in.
+	Environment string
+	// AllowedEnvironments lists the environments destructive operations
+	// may run in. Empty refuses them everywhere.
+	AllowedEnvironments []string
+	// BackupDir receives a backup of Keyspace before every destructive
+	// operation when it is set. A dry run fails while it is not a writable
+	// directory, and a failed backup cancels the operation.
+	BackupDir string
+	Keyspace  string
+}
+
+// ConfigFromEnv reads the configuration from APP_ENV (default production),
+// DESTRUCTIVE_OPERATIONS_ENVIRONMENTS (comma separated, default
+// development,test) and DESTRUCTIVE_OPERATIONS_BACKUP_DIR.
+func ConfigFromEnv() Config {
+	environment := os.Getenv("APP_ENV")
+	if environment == "" {
+		environment = "production"
+	}
+
+	allowed := os.Getenv("DESTRUCTIVE_OPERATIONS_ENVIRONMENTS")
+	if allowed == "" {
+		allowed = "development,test"
+	}
+
+	var environments []string
+	for _, e := range strings.Split(allowed, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			environments = append(environments, e)
+		}
+	}
+
+	return Config{
+		Environment:         environment,
+		AllowedEnvironments: environments,
+		BackupDir:           os.Getenv("DESTRUCTIVE_OPERATIONS_BACKUP_DIR"),
+		Keyspace:            config.Keyspace,
+	}
+}
+
+// CheckEnvironment returns ErrEnvironmentNotAllowed unless the environment
+// is in the allowlist.
+func (c Config) CheckEnvironment() error {
+	for _, allowed := range c.AllowedEnvironments {
+		if c.Environment == allowed {
+			return nil
+		}
+	}
+	return ErrEnvironmentNotAllowed
+}
+
+// CheckBackup returns an error if a backup is configured but BackupDir is not
+// a directory the backup can be written to.
+func (c Config) CheckBackup() error {
+	if c.BackupDir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(c.BackupDir)
+	if err == nil && !info.IsDir() {
+		err = errors.New("not a directory")
+	}
+	if err == nil {
+		var probe *os.File
+		if probe, err = os.CreateTemp(c.BackupDir, ".backup-check-*"); err == nil {
+			probe.Close()
+			os.Remove(probe.Name())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("backup: %s cannot take the backup: %w", c.BackupDir, err)
+	}
+
+	return nil
+}
+
+// Actor is the user running an operation.
+type Actor = entity.Actor
+
+// DryRun is what an operation would remove, with the token that confirms it.
+type DryRun struct {
+	Operation   string         `json:"operation"`
+	Environment string         `json:"environment"`
+	Counts      map[string]int `json:"counts"`
+	Backup      bool           `json:"backup"`
+	Token       string         `json:"confirmation_token"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+}
+
+// DryRun counts the rows operation would remove from tables and issues a
+// token the actor has to send to run it.
+func (c Config) DryRun(session *gocql.Session, operation string, actor Actor, tables []string) (DryRun, error) {
+	dryRun := DryRun{Operation: operation, Environment: c.Environment, Backup: c.BackupDir != ""}
+
+	if err := c.CheckEnvironment(); err != nil {
+		return dryRun, err
+	}
+	// refuse before a token is issued rather than when the operation runs
+	if err := c.CheckBackup(); err != nil {
+		return dryRun, err
+	}
+
+	counts, err := countRows(session, tables)
+	if err != nil {
+		return dryRun, err
+	}
+	dryRun.Counts = counts
+
+	dryRun.Token, dryRun.ExpiresAt, err = repository.IssueToken(session, operation, actor.UserID, counts)
+	return dryRun, err
+}
+
+// Run runs fn as operation if the environment allows it and token was issued
+// to the actor by a dry run of the same operation. The token is used up even
+// when fn fails. The operation is recorded before fn runs and its outcome
+// after, together with the row counts of tables and the backup taken.
+func (c Config) Run(session *gocql.Session, operation, token string, actor Actor, tables []string, fn func() error) (repository.Operation, error) {
+	op := repository.Operation{
+		Name:        operation,
+		ID:          uuid.UUID(gocql.TimeUUID()),
+		UserID:      actor.UserID,
+		Username:    actor.Username,
+		Environment: c.Environment,
+		Outcome:     repository.OutcomeStarted,
+		StartedAt:   time.Now().UTC(),
+	}
+
+	if err := c.CheckEnvironment(); err != nil {
+		return op, err
+	}
+	if err := repository.ConsumeToken(session, token, operation, actor.UserID); err != nil {
+		return op, err
+	}
+
+	counts, err := countRows(session, tables)
+	if err != nil {
+		return op, err
+	}
+	op.Counts = counts
+
+	if err := repository.StartOperation(session, op); err != nil {
+		return op, err
+	}
+
+	err = c.backup(session, &op)
+	if err == nil {
+		err = fn()
+	}
+
+	op.Outcome = repository.OutcomeSucceeded
+	if err != nil {
+		op.Outcome = repository.OutcomeFailed
+		op.Error = err.Error()
+	}
+	op.FinishedAt = time.Now().UTC()
+
+	if finishErr := repository.FinishOperation(session, op); err == nil {
+		err = finishErr
+	}
+
+	return op, err
+}
+
+// backup writes the backup and records it in op, or records that none was
+// taken because none is configured.
+func (c Config) backup(session *gocql.Session, op *repository.Operation) error {
+	if c.BackupDir == "" {
+		op.Backup = repository.NoBackup
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-before-%s-%s.tar.gz", c.Keyspace, op.Name, op.StartedAt.Format("20060102-150405"))
+	path := filepath.Join(c.BackupDir, name)
+
+	// a failed backup must not leave a truncated archive under the final name
+	file, err := os.Create(path + ".partial")
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+
+	_, err = backup.Write(session, c.Keyspace, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+".partial", path)
+	}
+	if err != nil {
+		os.Remove(path + ".partial")
+		return fmt.Errorf("backup: %w", err)
+	}
+
+	op.Backup = path
+	return nil
+}
+
+func countRows(session *gocql.Session, tables []string) (map[string]int, error) {
+	counts := make(map[string]int, len(tables))
+	for _, table := range tables {
+		count, err := repository.CountRows(session, table)
+		if err != nil {
+			return nil, err
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
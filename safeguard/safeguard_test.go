@@ -0,0 +1,36 @@
+package safeguard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckBackup(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		backupDir string
+		wantErr   bool
+	}{
+		{"", false},
+		{dir, false},
+		{filepath.Join(dir, "missing"), true},
+		{file, true},
+	}
+
+	for _, tt := range tests {
+		if err := (Config{BackupDir: tt.backupDir}).CheckBackup(); (err != nil) != tt.wantErr {
+			t.Errorf("CheckBackup() with %q = %v, want error %v", tt.backupDir, err, tt.wantErr)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("CheckBackup() left %d entries behind: %v", len(entries), err)
+	}
+}
@@ -0,0 +1,221 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Codes of the rules a field can break.
+const (
+	CodeRequired = "required"
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeUUID     = "invalid_uuid"
+	CodeOneOf    = "not_allowed"
+	CodeType     = "invalid_type"
+	CodeUnknown  = "unknown_field"
+	CodeURL      = "invalid_url"
+)
+
+// FieldError describes one rule a field of a request broke.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors is returned by Validate when at least one field is invalid.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Field + " " + fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator checks structs against the rules in their `validate` tags:
+//
+//	required     the field is not empty (strings are trimmed first)
+//	min=N,max=N  bounds on the number of characters of a string or items of a slice
+//	uuid         the string is a valid UUID
+//	oneof=a b c  the string is one of the listed values
+//
+// Nested structs and slices of structs are validated as well, their fields
+// are reported as "items[2].title". Field names come from the json tag.
+type Validator struct{}
+
+func New() *Validator {
+	return &Validator{}
+}
+
+// Validate implements echo.Validator.
+func (v *Validator) Validate(i interface{}) error {
+	var errs Errors
+	validateStruct(reflect.Indirect(reflect.ValueOf(i)), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Value checks a single value against rules written like a `validate` tag.
+func Value(field string, value interface{}, rules string) Errors {
+	var errs Errors
+	validateValue(reflect.ValueOf(value), field, rules, &errs)
+	return errs
+}
+
+func validateStruct(value reflect.Value, prefix string, errs *Errors) {
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := prefix + fieldName(field)
+		validateValue(value.Field(i), name, field.Tag.Get("validate"), errs)
+	}
+}
+
+func validateValue(value reflect.Value, name, rules string, errs *Errors) {
+	if rules == "-" {
+		return
+	}
+
+	for _, rule := range splitRules(rules) {
+		if fieldError, ok := check(value, rule); !ok {
+			fieldError.Field = name
+			*errs = append(*errs, fieldError)
+			// the other rules are moot once a required field is missing
+			if fieldError.Code == CodeRequired {
+				return
+			}
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			validateStruct(value.Elem(), name+".", errs)
+		}
+	case reflect.Struct:
+		if value.Type() != reflect.TypeOf(time.Time{}) {
+			validateStruct(value, name+".", errs)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			validateStruct(reflect.Indirect(value.Index(i)), fmt.Sprintf("%s[%d].", name, i), errs)
+		}
+	}
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+func splitRules(rules string) []rule {
+	var parsed []rule
+	for _, r := range strings.Split(rules, ",") {
+		if r == "" {
+			continue
+		}
+		name, param := r, ""
+		if i := strings.Index(r, "="); i >= 0 {
+			name, param = r[:i], r[i+1:]
+		}
+		parsed = append(parsed, rule{name: name, param: param})
+	}
+	return parsed
+}
+
+func check(value reflect.Value, r rule) (FieldError, bool) {
+	switch r.name {
+	case "required":
+		if isEmpty(value) {
+			return FieldError{Code: CodeRequired, Message: "is required"}, false
+		}
+	case "min":
+		n, _ := strconv.Atoi(r.param)
+		if length(value) < n && !isEmpty(value) {
+			return FieldError{Code: CodeTooShort, Message: fmt.Sprintf("must have at least %d %s", n, unit(value))}, false
+		}
+	case "max":
+		n, _ := strconv.Atoi(r.param)
+		if length(value) > n {
+			return FieldError{Code: CodeTooLong, Message: fmt.Sprintf("must have at most %d %s", n, unit(value))}, false
+		}
+	case "uuid":
+		if value.Kind() == reflect.String && value.String() != "" {
+			if _, err := uuid.Parse(value.String()); err != nil {
+				return FieldError{Code: CodeUUID, Message: "must be a valid UUID"}, false
+			}
+		}
+	case "oneof":
+		if value.Kind() == reflect.String && value.String() != "" {
+			allowed := strings.Fields(r.param)
+			for _, a := range allowed {
+				if value.String() == a {
+					return FieldError{}, true
+				}
+			}
+			return FieldError{Code: CodeOneOf, Message: "must be one of " + strings.Join(allowed, ", ")}, false
+		}
+	}
+
+	return FieldError{}, true
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Invalid:
+		return true
+	}
+
+	return value.IsZero()
+}
+
+func length(value reflect.Value) int {
+	switch value.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(value.String())
+	case reflect.Slice, reflect.Map:
+		return value.Len()
+	}
+	return 0
+}
+
+func unit(value reflect.Value) string {
+	if value.Kind() == reflect.String {
+		return "characters"
+	}
+	return "items"
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "query", "param", "form"} {
+		if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
@@ -0,0 +1,92 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type item struct {
+	Title string `json:"title" validate:"required,max=5"`
+}
+
+type request struct {
+	ID     string   `json:"id" validate:"required,uuid"`
+	Text   string   `json:"text" validate:"min=2,max=4"`
+	Status string   `json:"status" validate:"oneof=open closed"`
+	Tags   []string `query:"tags" validate:"max=2"`
+	Items  []item   `json:"items"`
+	Skip   []item   `json:"skip" validate:"-"`
+	Plain  string
+	hidden string
+}
+
+func TestValidate(t *testing.T) {
+	const id = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	tests := []struct {
+		name string
+		req  request
+		want []FieldError
+	}{
+		{"valid", request{ID: id, Text: "abc", Status: "open"}, nil},
+		{"required", request{ID: "  "}, []FieldError{{Field: "id", Code: CodeRequired}}},
+		{"uuid", request{ID: "not-a-uuid"}, []FieldError{{Field: "id", Code: CodeUUID}}},
+		{"too short", request{ID: id, Text: "a"}, []FieldError{{Field: "text", Code: CodeTooShort}}},
+		{"too long counts characters", request{ID: id, Text: "äöüß"}, nil},
+		{"too long", request{ID: id, Text: "abcde"}, []FieldError{{Field: "text", Code: CodeTooLong}}},
+		{"oneof", request{ID: id, Status: "gone"}, []FieldError{{Field: "status", Code: CodeOneOf}}},
+		{"slice length", request{ID: id, Tags: []string{"a", "b", "c"}}, []FieldError{{Field: "tags", Code: CodeTooLong}}},
+		{"nested", request{ID: id, Items: []item{{Title: "a"}, {Title: ""}, {Title: "abcdef"}}}, []FieldError{
+			{Field: "items[1].title", Code: CodeRequired},
+			{Field: "items[2].title", Code: CodeTooLong},
+		}},
+		{"skipped", request{ID: id, Skip: []item{{Title: ""}}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().Validate(&tt.req)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("Validate() = %v, want Errors", err)
+			}
+			var got []FieldError
+			for _, fieldError := range errs {
+				got = append(got, FieldError{Field: fieldError.Field, Code: fieldError.Code})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	if errs := Value("title", "abc", "required,max=5"); errs != nil {
+		t.Errorf("Value() = %v, want none", errs)
+	}
+
+	errs := Value("title", "", "required,max=5")
+	if len(errs) != 1 || errs[0].Field != "title" || errs[0].Code != CodeRequired {
+		t.Errorf("Value() = %v, want title required", errs)
+	}
+}
+
+func TestErrorsError(t *testing.T) {
+	errs := Errors{
+		{Field: "id", Code: CodeRequired, Message: "is required"},
+		{Field: "text", Code: CodeTooLong, Message: "must have at most 4 characters"},
+	}
+
+	if got := errs.Error(); !strings.Contains(got, "id is required") || !strings.Contains(got, "text must have at most 4 characters") {
+		t.Errorf("Error() = %q", got)
+	}
+}
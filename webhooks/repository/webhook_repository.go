@@ -0,0 +1,356 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+// AddWebhook stores the webhook.
+func AddWebhook(session *gocql.Session, webhook entity.Webhook) error {
+	defer activeWebhooks.invalidate()
+
+	return session.Query(`INSERT INTO webhooks(id, url, events, secret, active, created_by, created_at)
+							VALUES (?, ?, ?, ?, ?, ?, ?);`, gocql.UUID(webhook.ID), webhook.URL, webhook.Events, webhook.Secret,
+		webhook.Active, gocql.UUID(webhook.CreatedBy), webhook.CreatedAt).Exec()
+}
+
+// GetWebhook returns the webhook with its secret, nil if there is none.
+func GetWebhook(session *gocql.Session, id uuid.UUID) (*entity.Webhook, error) {
+	var m = map[string]interface{}{}
+
+	err := session.Query(`SELECT * FROM webhooks WHERE id=?;`, gocql.UUID(id)).MapScan(m)
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := webhookFromMap(m)
+	return &webhook, nil
+}
+
+// GetWebhooks returns every webhook with its secret.
+func GetWebhooks(session *gocql.Session) ([]entity.Webhook, error) {
+	var webhooks []entity.Webhook
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM webhooks;`).Iter()
+
+	for iter.MapScan(m) {
+		webhooks = append(webhooks, webhookFromMap(m))
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return webhooks, err
+}
+
+// ActiveWebhooksTTL is how long GetActiveWebhooks keeps the webhooks. The
+// functions changing webhooks invalidate them right away, changes made by
+// other instances are seen after at most this long.
+var ActiveWebhooksTTL = 30 * time.Second
+
+// webhookCache holds the active webhooks, every event is matched against
+// them.
+type webhookCache struct {
+	mu       sync.Mutex
+	webhooks []entity.Webhook
+	loaded   time.Time
+}
+
+var activeWebhooks webhookCache
+
+func (c *webhookCache) invalidate() {
+	c.mu.Lock()
+	c.loaded = time.Time{}
+	c.mu.Unlock()
+}
+
+// GetActiveWebhooks returns the webhooks that are not paused, with their
+// secrets, from a cache.
+func GetActiveWebhooks(session *gocql.Session) ([]entity.Webhook, error) {
+	activeWebhooks.mu.Lock()
+	defer activeWebhooks.mu.Unlock()
+
+	if !activeWebhooks.loaded.IsZero() && time.Since(activeWebhooks.loaded) < ActiveWebhooksTTL {
+		return activeWebhooks.webhooks, nil
+	}
+
+	webhooks, err := GetWebhooks(session)
+	if err != nil {
+		return nil, err
+	}
+
+	var active []entity.Webhook
+	for _, webhook := range webhooks {
+		if webhook.Active {
+			active = append(active, webhook)
+		}
+	}
+	activeWebhooks.webhooks = active
+	activeWebhooks.loaded = time.Now()
+
+	return active, nil
+}
+
+// SetWebhookActive pauses or resumes sending events to the webhook. It
+// returns false if there is no such webhook.
+func SetWebhookActive(session *gocql.Session, id uuid.UUID, active bool) (bool, error) {
+	defer activeWebhooks.invalidate()
+
+	return session.Query(`UPDATE webhooks SET active=? WHERE id=? IF EXISTS;`, active, gocql.UUID(id)).
+		MapScanCAS(map[string]interface{}{})
+}
+
+// DeleteWebhook removes the webhook with its delivery log and dead letters.
+// Its pending deliveries are dropped as they come due.
+func DeleteWebhook(session *gocql.Session, id uuid.UUID) error {
+	defer activeWebhooks.invalidate()
+
+	err := session.Query(`DELETE FROM webhooks WHERE id=?;`, gocql.UUID(id)).Exec()
+	if err != nil {
+		return err
+	}
+
+	err = session.Query(`DELETE FROM webhook_deliveries WHERE webhook_id=?;`, gocql.UUID(id)).Exec()
+	if err != nil {
+		return err
+	}
+
+	return session.Query(`DELETE FROM webhook_dead_letters WHERE webhook_id=?;`, gocql.UUID(id)).Exec()
+}
+
+// LogDelivery records an attempt to send an event. The id of the entry is
+// generated, its time is the time of the attempt.
+func LogDelivery(session *gocql.Session, delivery entity.WebhookDelivery) error {
+	return session.Query(`INSERT INTO webhook_deliveries(webhook_id, id, event_id, event, attempt, status_code, error,
+							duration_ms, succeeded) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, gocql.UUID(delivery.WebhookID),
+		gocql.TimeUUID(), gocql.UUID(delivery.EventID), delivery.Event, delivery.Attempt,
+		delivery.StatusCode, delivery.Error, delivery.DurationMS, delivery.Succeeded).Exec()
+}
+
+// GetDeliveries returns up to limit attempts to send events to the webhook,
+// newest first. With before set only attempts older than the one with that
+// id are returned.
+func GetDeliveries(session *gocql.Session, webhookID, before uuid.UUID, limit int) ([]entity.WebhookDelivery, error) {
+	var deliveries []entity.WebhookDelivery
+	var m = map[string]interface{}{}
+
+	query := session.Query(`SELECT * FROM webhook_deliveries WHERE webhook_id=? LIMIT ?;`, gocql.UUID(webhookID), limit)
+	if before != uuid.Nil {
+		query = session.Query(`SELECT * FROM webhook_deliveries WHERE webhook_id=? AND id<? LIMIT ?;`,
+			gocql.UUID(webhookID), gocql.UUID(before), limit)
+	}
+	iter := query.Iter()
+
+	for iter.MapScan(m) {
+		id := m["id"].(gocql.UUID)
+		deliveries = append(deliveries, entity.WebhookDelivery{
+			WebhookID:  uuid.UUID(m["webhook_id"].(gocql.UUID)),
+			ID:         uuid.UUID(id),
+			EventID:    uuid.UUID(m["event_id"].(gocql.UUID)),
+			Event:      m["event"].(string),
+			Attempt:    m["attempt"].(int),
+			StatusCode: m["status_code"].(int),
+			Error:      m["error"].(string),
+			DurationMS: m["duration_ms"].(int),
+			Succeeded:  m["succeeded"].(bool),
+			CreatedAt:  id.Time(),
+		})
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return deliveries, err
+}
+
+// AddDeadLetter stores an event that could not be sent.
+func AddDeadLetter(session *gocql.Session, letter entity.WebhookDeadLetter) error {
+	return session.Query(`INSERT INTO webhook_dead_letters(webhook_id, event_id, event, payload, attempts, last_error, failed_at)
+							VALUES (?, ?, ?, ?, ?, ?, ?);`, gocql.UUID(letter.WebhookID), gocql.UUID(letter.EventID), letter.Event,
+		letter.Payload, letter.Attempts, letter.LastError, letter.FailedAt).Exec()
+}
+
+// GetDeadLetter returns the dead letter of the event, nil if there is none.
+func GetDeadLetter(session *gocql.Session, webhookID, eventID uuid.UUID) (*entity.WebhookDeadLetter, error) {
+	var m = map[string]interface{}{}
+
+	err := session.Query(`SELECT * FROM webhook_dead_letters WHERE webhook_id=? AND event_id=?;`,
+		gocql.UUID(webhookID), gocql.UUID(eventID)).MapScan(m)
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	letter := deadLetterFromMap(m)
+	return &letter, nil
+}
+
+// GetDeadLetters returns every dead letter of the webhook.
+func GetDeadLetters(session *gocql.Session, webhookID uuid.UUID) ([]entity.WebhookDeadLetter, error) {
+	var letters []entity.WebhookDeadLetter
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM webhook_dead_letters WHERE webhook_id=?;`, gocql.UUID(webhookID)).Iter()
+
+	for iter.MapScan(m) {
+		letters = append(letters, deadLetterFromMap(m))
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return letters, err
+}
+
+// DeleteDeadLetter removes the dead letter of the event.
+func DeleteDeadLetter(session *gocql.Session, webhookID, eventID uuid.UUID) error {
+	return session.Query(`DELETE FROM webhook_dead_letters WHERE webhook_id=? AND event_id=?;`,
+		gocql.UUID(webhookID), gocql.UUID(eventID)).Exec()
+}
+
+// DueBucket is how long a span of due times one partition of
+// webhook_pending_by_due holds.
+const DueBucket = time.Minute
+
+// Bucket returns the partition of webhook_pending_by_due holding the
+// deliveries due at t.
+func Bucket(t time.Time) time.Time {
+	return t.UTC().Truncate(DueBucket)
+}
+
+// unclaimed is stored as the claim of deliveries no one claimed, a condition
+// on a null column would never apply.
+var unclaimed = time.Unix(0, 0).UTC()
+
+// AddPendingDelivery stores an event to be sent to a webhook, or without a
+// webhook id to be handed to the webhooks subscribed to it. Storing it again
+// overwrites it.
+func AddPendingDelivery(session *gocql.Session, pending entity.WebhookPendingDelivery) error {
+	return session.Query(`INSERT INTO webhook_pending_by_due(due_bucket, next_attempt_at, webhook_id, event_id, event, payload,
+							attempts, last_error, claimed_until) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, Bucket(pending.NextAttemptAt),
+		pending.NextAttemptAt, gocql.UUID(pending.WebhookID), gocql.UUID(pending.EventID), pending.Event, pending.Payload,
+		pending.Attempts, pending.LastError, unclaimed).Exec()
+}
+
+// GetDueDeliveries returns the deliveries of the bucket that are due at now,
+// claimed ones included.
+func GetDueDeliveries(session *gocql.Session, bucket, now time.Time) ([]entity.WebhookPendingDelivery, error) {
+	var pending []entity.WebhookPendingDelivery
+	var m = map[string]interface{}{}
+
+	iter := session.Query(`SELECT * FROM webhook_pending_by_due WHERE due_bucket=? AND next_attempt_at<=?;`, bucket, now).Iter()
+
+	for iter.MapScan(m) {
+		delivery := entity.WebhookPendingDelivery{
+			WebhookID:     uuid.UUID(m["webhook_id"].(gocql.UUID)),
+			EventID:       uuid.UUID(m["event_id"].(gocql.UUID)),
+			Event:         m["event"].(string),
+			Payload:       m["payload"].(string),
+			Attempts:      m["attempts"].(int),
+			LastError:     m["last_error"].(string),
+			NextAttemptAt: m["next_attempt_at"].(time.Time),
+		}
+		if claimed, _ := m["claimed_until"].(time.Time); claimed.After(unclaimed) {
+			delivery.ClaimedUntil = claimed
+		}
+		pending = append(pending, delivery)
+		m = map[string]interface{}{}
+	}
+
+	err := iter.Close()
+
+	return pending, err
+}
+
+// ClaimPendingDelivery sets the claim of a pending delivery to until, if no
+// one changed it since it was read. Only the caller that claimed it sends
+// it, until then.
+func ClaimPendingDelivery(session *gocql.Session, pending entity.WebhookPendingDelivery, until time.Time) (bool, error) {
+	previous := pending.ClaimedUntil
+	if previous.IsZero() {
+		previous = unclaimed
+	}
+
+	return session.Query(`UPDATE webhook_pending_by_due SET claimed_until=?
+							WHERE due_bucket=? AND next_attempt_at=? AND webhook_id=? AND event_id=? IF claimed_until=?;`,
+		until, Bucket(pending.NextAttemptAt), pending.NextAttemptAt, gocql.UUID(pending.WebhookID), gocql.UUID(pending.EventID),
+		previous).MapScanCAS(map[string]interface{}{})
+}
+
+// ReschedulePendingDelivery moves a claimed delivery to its new
+// NextAttemptAt, storing the attempts made so far. The due time is part of
+// the key, so the row is written anew and the one it was read from, due at
+// previous, removed in the same batch.
+func ReschedulePendingDelivery(session *gocql.Session, pending entity.WebhookPendingDelivery, previous time.Time) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO webhook_pending_by_due(due_bucket, next_attempt_at, webhook_id, event_id, event, payload,
+							attempts, last_error, claimed_until) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, Bucket(pending.NextAttemptAt),
+		pending.NextAttemptAt, gocql.UUID(pending.WebhookID), gocql.UUID(pending.EventID), pending.Event, pending.Payload,
+		pending.Attempts, pending.LastError, unclaimed)
+	batch.Query(`DELETE FROM webhook_pending_by_due WHERE due_bucket=? AND next_attempt_at=? AND webhook_id=? AND event_id=?;`,
+		Bucket(previous), previous, gocql.UUID(pending.WebhookID), gocql.UUID(pending.EventID))
+
+	return session.ExecuteBatch(batch)
+}
+
+// DeletePendingDelivery removes a delivery that was accepted or given up.
+func DeletePendingDelivery(session *gocql.Session, pending entity.WebhookPendingDelivery) error {
+	return session.Query(`DELETE FROM webhook_pending_by_due WHERE due_bucket=? AND next_attempt_at=? AND webhook_id=? AND event_id=?;`,
+		Bucket(pending.NextAttemptAt), pending.NextAttemptAt, gocql.UUID(pending.WebhookID), gocql.UUID(pending.EventID)).Exec()
+}
+
+// GetCursor returns the oldest bucket that may still hold pending
+// deliveries, the zero time if none was stored yet.
+func GetCursor(session *gocql.Session) (time.Time, error) {
+	var bucket time.Time
+
+	err := session.Query(`SELECT due_bucket FROM webhook_cursor WHERE name='pending';`).Scan(&bucket)
+	if err == gocql.ErrNotFound {
+		return time.Time{}, nil
+	}
+
+	return bucket, err
+}
+
+// SetCursor stores the oldest bucket that may still hold pending deliveries.
+func SetCursor(session *gocql.Session, bucket time.Time) error {
+	return session.Query(`INSERT INTO webhook_cursor(name, due_bucket) VALUES ('pending', ?);`, bucket).Exec()
+}
+
+func webhookFromMap(m map[string]interface{}) entity.Webhook {
+	webhook := entity.Webhook{
+		ID:        uuid.UUID(m["id"].(gocql.UUID)),
+		URL:       m["url"].(string),
+		Secret:    m["secret"].(string),
+		Active:    m["active"].(bool),
+		CreatedBy: uuid.UUID(m["created_by"].(gocql.UUID)),
+		CreatedAt: m["created_at"].(time.Time),
+	}
+	if events, ok := m["events"].([]string); ok {
+		webhook.Events = events
+	}
+
+	return webhook
+}
+
+func deadLetterFromMap(m map[string]interface{}) entity.WebhookDeadLetter {
+	return entity.WebhookDeadLetter{
+		WebhookID: uuid.UUID(m["webhook_id"].(gocql.UUID)),
+		EventID:   uuid.UUID(m["event_id"].(gocql.UUID)),
+		Event:     m["event"].(string),
+		Payload:   m["payload"].(string),
+		Attempts:  m["attempts"].(int),
+		LastError: m["last_error"].(string),
+		FailedAt:  m["failed_at"].(time.Time),
+	}
+}
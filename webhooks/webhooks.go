@@ -0,0 +1,602 @@
+// Package webhooks sends the published events to the endpoints subscribed to
+// them. Payloads are JSON signed with the webhook's secret. Every delivery is
+// stored before it is first attempted, failed ones are retried with
+// exponential backoff and kept as dead letters once the attempts are used
+// up, so a restart loses none. Every attempt is logged.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/events"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/webhooks/repository"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of the
+	// timestamp, a dot and the body, keyed with the webhook's secret.
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Payload is the body sent to a webhook.
+type Payload struct {
+	// ID is the same for every attempt to send the event, receivers can use
+	// it to ignore repeated deliveries.
+	ID         uuid.UUID `json:"id"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	ProposalID uuid.UUID `json:"proposal_id"`
+	CommentID  uuid.UUID `json:"comment_id"`
+	Actor      Actor     `json:"actor"`
+	// Before and After are the changed proposal or comment.
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Actor is the user behind an event.
+type Actor struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+}
+
+// Config tunes the delivery of events.
+type Config struct {
+	// MaxAttempts is how often an event is sent before it becomes a dead
+	// letter.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, it doubles with every
+	// further one up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout limits a single attempt.
+	Timeout time.Duration
+	// Concurrency is how many requests are sent at the same time.
+	Concurrency int
+	// PollInterval is how often the stored deliveries are checked for ones
+	// that are due.
+	PollInterval time.Duration
+	// AllowPrivateAddresses lets webhooks point at loopback, private and
+	// link-local addresses, e.g. in development. Otherwise the server could
+	// be made to post to internal services.
+	AllowPrivateAddresses bool
+}
+
+// Default is the configuration controllers build their dispatcher from. An
+// event is given up about half an hour after it happened.
+var Default = Config{
+	MaxAttempts:  8,
+	BaseDelay:    15 * time.Second,
+	MaxDelay:     15 * time.Minute,
+	Timeout:      10 * time.Second,
+	Concurrency:  16,
+	PollInterval: 5 * time.Second,
+}
+
+var ErrPrivateAddress = errors.New("webhook URLs must not point at loopback, private or link-local addresses")
+
+// Dispatcher sends events to the webhooks.
+type Dispatcher struct {
+	session *gocql.Session
+	config  Config
+	client  *http.Client
+	slots   chan struct{}
+	// wake tells the worker that new deliveries were stored
+	wake chan struct{}
+	stop chan struct{}
+
+	start        sync.Once
+	stopped      sync.Once
+	subscription *events.Subscription
+
+	// sending serializes SendDue, which moves cursor
+	sending sync.Mutex
+	cursor  time.Time
+}
+
+// New returns a dispatcher sending the events to the webhooks stored in
+// session.
+func New(session *gocql.Session, config Config) *Dispatcher {
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		// checked on every connection, a host may resolve differently by then
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if config.AllowPrivateAddresses {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || PrivateAddress(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &Dispatcher{
+		session: session,
+		config:  config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			// no proxy, it would be dialed instead of the webhook's address
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				MaxIdleConns:        100,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: config.Timeout,
+			},
+			// a redirect is an answer like any other, it is not followed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		slots: make(chan struct{}, config.Concurrency),
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Start stores the published events for delivery from now on and starts
+// sending the stored deliveries, those left by an earlier run included.
+// Errors are passed to onError, which may be nil. Starting again returns the
+// running subscription.
+func (d *Dispatcher) Start(onError func(error)) *events.Subscription {
+	d.start.Do(func() {
+		// queued, an event the bus dropped would never be sent
+		d.subscription = events.SubscribeQueued("webhooks", func(event events.Event) {
+			err := d.Dispatch(event)
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		})
+		go d.work(onError)
+	})
+
+	return d.subscription
+}
+
+// Stop stops storing events and sending deliveries. Attempts under way
+// finish in the background, the remaining deliveries stay stored for the
+// next start.
+func (d *Dispatcher) Stop() {
+	d.stopped.Do(func() {
+		if d.subscription != nil {
+			d.subscription.Close()
+		}
+		close(d.stop)
+	})
+}
+
+// CheckURL returns ErrPrivateAddress if the host of a webhook URL resolves to
+// a loopback, private or link-local address.
+func (d *Dispatcher) CheckURL(rawURL string) error {
+	if d.config.AllowPrivateAddresses {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if PrivateAddress(ip) {
+			return ErrPrivateAddress
+		}
+	}
+
+	return nil
+}
+
+// reserved are ranges PrivateAddress blocks that net.IP has no method for.
+var reserved = []*net.IPNet{
+	cidr("0.0.0.0/8"),
+	// carrier-grade NAT, used for internal networks by some clouds
+	cidr("100.64.0.0/10"),
+}
+
+func cidr(s string) *net.IPNet {
+	_, network, _ := net.ParseCIDR(s)
+	return network
+}
+
+// PrivateAddress reports whether ip is not a public unicast address, e.g. a
+// loopback, private, link-local or multicast one. Link-local includes the
+// 169.254.169.254 metadata endpoint of the clouds.
+func PrivateAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range reserved {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventName is the name webhooks subscribe to the event by.
+func EventName(event events.Event) string {
+	return event.TargetType + "." + event.Action
+}
+
+// Dispatch stores the event once, if an active webhook subscribes to it.
+// The worker started by Start hands it to the webhooks and sends it.
+func (d *Dispatcher) Dispatch(event events.Event) error {
+	name := EventName(event)
+	subscribed, err := d.subscribed(name)
+	if err != nil || len(subscribed) == 0 {
+		return err
+	}
+
+	payload := Payload{
+		ID:         uuid.New(),
+		Event:      name,
+		OccurredAt: event.At,
+		ProposalID: event.ProposalID,
+		CommentID:  event.CommentID,
+		Actor:      Actor{UserID: event.Actor.UserID, Username: event.Actor.Username},
+		Before:     event.Before,
+		After:      event.After,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	// Cassandra stores timestamps with millisecond precision, the claims
+	// compare them
+	err = repository.AddPendingDelivery(d.session, entity.WebhookPendingDelivery{
+		EventID:       payload.ID,
+		Event:         name,
+		Payload:       string(body),
+		NextAttemptAt: time.Now().Truncate(time.Millisecond),
+	})
+	if err != nil {
+		return err
+	}
+
+	d.wakeUp()
+	return nil
+}
+
+// subscribed returns the active webhooks subscribed to the event name.
+func (d *Dispatcher) subscribed(name string) ([]entity.Webhook, error) {
+	webhooks, err := repository.GetActiveWebhooks(d.session)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribed []entity.Webhook
+	for _, webhook := range webhooks {
+		if webhook.Subscribes(name) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+
+	return subscribed, nil
+}
+
+func (d *Dispatcher) wakeUp() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work sends the due deliveries every PollInterval and whenever new ones
+// were stored, until Stop is called.
+func (d *Dispatcher) work(onError func(error)) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		err := d.SendDue(onError)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// SendDue makes the next attempt of every stored delivery that is due and
+// hands the stored events to their webhooks. The deliveries are read from the
+// buckets between the cursor and now; the cursor moves past buckets found
+// empty, a minute after they ended so late writes of instances with a
+// slower clock are still found. A delivery is claimed before it is sent, so
+// with several instances only one sends it. If the instance stops while
+// sending, the claim runs out and another one retries. Errors of single
+// deliveries are passed to onError, which may be nil.
+func (d *Dispatcher) SendDue(onError func(error)) error {
+	d.sending.Lock()
+	defer d.sending.Unlock()
+
+	now := time.Now()
+	current := repository.Bucket(now)
+	if d.cursor.IsZero() {
+		cursor, err := repository.GetCursor(d.session)
+		if err != nil {
+			return err
+		}
+		if cursor.IsZero() {
+			// nothing was stored before the cursor
+			cursor = current.Add(-repository.DueBucket)
+			if err := repository.SetCursor(d.session, cursor); err != nil {
+				return err
+			}
+		}
+		d.cursor = cursor
+	}
+
+	cursor := d.cursor
+	advancing := true
+	for bucket := d.cursor; !bucket.After(current); bucket = bucket.Add(repository.DueBucket) {
+		pending, err := repository.GetDueDeliveries(d.session, bucket, now)
+		if err != nil {
+			return err
+		}
+
+		if advancing && len(pending) == 0 && bucket.Before(current.Add(-repository.DueBucket)) {
+			cursor = bucket.Add(repository.DueBucket)
+		} else {
+			advancing = false
+		}
+
+		for _, delivery := range pending {
+			if delivery.ClaimedUntil.After(now) {
+				continue
+			}
+			if err := d.claim(delivery, onError); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cursor.After(d.cursor) {
+		// another instance may store an older cursor, that only costs reads
+		if err := repository.SetCursor(d.session, cursor); err != nil {
+			return err
+		}
+		d.cursor = cursor
+	}
+
+	return nil
+}
+
+// claim claims a due delivery and, if no one else did, makes its next
+// attempt in the background.
+func (d *Dispatcher) claim(delivery entity.WebhookPendingDelivery, onError func(error)) error {
+	// wait for a free slot first, so the claim does not run out while the
+	// delivery waits for one
+	d.slots <- struct{}{}
+	until := time.Now().Add(2 * d.config.Timeout).Truncate(time.Millisecond)
+	claimed, err := repository.ClaimPendingDelivery(d.session, delivery, until)
+	if err != nil || !claimed {
+		<-d.slots
+		return err
+	}
+
+	go func() {
+		defer func() { <-d.slots }()
+
+		var err error
+		if delivery.WebhookID == uuid.Nil {
+			err = d.fanOut(delivery)
+		} else {
+			err = d.attempt(delivery)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}()
+
+	return nil
+}
+
+// fanOut stores a delivery of a stored event for every active webhook
+// subscribed to it, then removes the event. The deliveries are due when the
+// event was, so if handing it out fails halfway the retry overwrites those
+// already stored instead of adding to them.
+func (d *Dispatcher) fanOut(event entity.WebhookPendingDelivery) error {
+	subscribed, err := d.subscribed(event.Event)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range subscribed {
+		err := repository.AddPendingDelivery(d.session, entity.WebhookPendingDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       event.EventID,
+			Event:         event.Event,
+			Payload:       event.Payload,
+			NextAttemptAt: event.NextAttemptAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := repository.DeletePendingDelivery(d.session, event); err != nil {
+		return err
+	}
+
+	d.wakeUp()
+	return nil
+}
+
+// attempt sends a claimed delivery once. An accepted one is removed, a failed
+// one is scheduled for the next attempt or, with the attempts used up or the
+// webhook paused, kept as a dead letter. A delivery to a deleted webhook is
+// dropped.
+func (d *Dispatcher) attempt(pending entity.WebhookPendingDelivery) error {
+	webhook, err := repository.GetWebhook(d.session, pending.WebhookID)
+	if err != nil {
+		return err
+	}
+	if webhook == nil {
+		return repository.DeletePendingDelivery(d.session, pending)
+	}
+	if !webhook.Active {
+		return d.giveUp(pending)
+	}
+
+	delivery := d.send(*webhook, pending.Event, pending.EventID, []byte(pending.Payload), pending.Attempts+1)
+	// the log is best-effort, it must not stop the retries
+	_ = repository.LogDelivery(d.session, delivery)
+	if delivery.Succeeded {
+		return repository.DeletePendingDelivery(d.session, pending)
+	}
+
+	pending.Attempts = delivery.Attempt
+	pending.LastError = delivery.Error
+	if pending.Attempts >= d.config.MaxAttempts {
+		return d.giveUp(pending)
+	}
+
+	previous := pending.NextAttemptAt
+	pending.NextAttemptAt = time.Now().Add(d.Backoff(pending.Attempts)).Truncate(time.Millisecond)
+	return repository.ReschedulePendingDelivery(d.session, pending, previous)
+}
+
+// giveUp keeps a delivery as a dead letter.
+func (d *Dispatcher) giveUp(pending entity.WebhookPendingDelivery) error {
+	err := repository.AddDeadLetter(d.session, entity.WebhookDeadLetter{
+		WebhookID: pending.WebhookID,
+		EventID:   pending.EventID,
+		Event:     pending.Event,
+		Payload:   pending.Payload,
+		Attempts:  pending.Attempts,
+		LastError: pending.LastError,
+		FailedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return repository.DeletePendingDelivery(d.session, pending)
+}
+
+// Backoff returns the wait after the given number of failed attempts.
+func (d *Dispatcher) Backoff(failed int) time.Duration {
+	delay := d.config.BaseDelay
+	for i := 1; i < failed && delay < d.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.config.MaxDelay {
+		delay = d.config.MaxDelay
+	}
+
+	return delay
+}
+
+// Send makes one attempt to send body to the webhook. A 2xx response
+// accepts it, anything else is a failed attempt.
+func (d *Dispatcher) Send(webhook entity.Webhook, name string, eventID uuid.UUID, body []byte, attempt int) entity.WebhookDelivery {
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	return d.send(webhook, name, eventID, body, attempt)
+}
+
+// send is Send for callers holding a slot.
+func (d *Dispatcher) send(webhook entity.Webhook, name string, eventID uuid.UUID, body []byte, attempt int) entity.WebhookDelivery {
+	delivery := entity.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   eventID,
+		Event:     name,
+		Attempt:   attempt,
+		CreatedAt: time.Now(),
+	}
+
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timestamp := strconv.FormatInt(delivery.CreatedAt.Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "smartest-city-webhooks")
+	request.Header.Set(HeaderEvent, name)
+	request.Header.Set(HeaderDelivery, eventID.String())
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(request)
+	delivery.DurationMS = int(time.Since(delivery.CreatedAt) / time.Millisecond)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	// read a little of the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Succeeded = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Succeeded {
+		delivery.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+
+	return delivery
+}
+
+// Redeliver makes one attempt to send a dead letter to its webhook. The dead
+// letter is removed once it is accepted.
+func (d *Dispatcher) Redeliver(webhook entity.Webhook, letter entity.WebhookDeadLetter) (entity.WebhookDelivery, error) {
+	delivery := d.Send(webhook, letter.Event, letter.EventID, []byte(letter.Payload), letter.Attempts+1)
+	_ = repository.LogDelivery(d.session, delivery)
+
+	if !delivery.Succeeded {
+		letter.Attempts = delivery.Attempt
+		letter.LastError = delivery.Error
+		letter.FailedAt = time.Now()
+		return delivery, repository.AddDeadLetter(d.session, letter)
+	}
+
+	return delivery, repository.DeleteDeadLetter(d.session, letter.WebhookID, letter.EventID)
+}
+
+// Sign returns the signature header of a delivery.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
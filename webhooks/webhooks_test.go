@@ -0,0 +1,100 @@
+package webhooks
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/windswept321/smartest-city-roadmap-go/proposals-and-comments/entity"
+)
+
+func TestSign(t *testing.T) {
+	const want = "sha256=b4e1493dc904a828d36e7dbb63489bb01cd73796f450c8aeddbced687b367db2"
+
+	if got := Sign("secret", "1614834367", []byte(`{"a":1}`)); got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+	if Sign("other", "1614834367", []byte(`{"a":1}`)) == want {
+		t.Error("Sign() ignores the secret")
+	}
+	if Sign("secret", "1614834368", []byte(`{"a":1}`)) == want {
+		t.Error("Sign() ignores the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := New(nil, Config{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Concurrency: 1})
+
+	for failed, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 20: 10 * time.Second} {
+		if got := d.Backoff(failed); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", failed, got, want)
+		}
+	}
+}
+
+func TestPrivateAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+	}
+
+	for _, tt := range tests {
+		if got := PrivateAddress(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("PrivateAddress(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	d := New(nil, Default)
+	if err := d.CheckURL("http://127.0.0.1:8080/hook"); err != ErrPrivateAddress {
+		t.Errorf("CheckURL(loopback) = %v, want ErrPrivateAddress", err)
+	}
+
+	allowed := Default
+	allowed.AllowPrivateAddresses = true
+	if err := New(nil, allowed).CheckURL("http://127.0.0.1:8080/hook"); err != nil {
+		t.Errorf("CheckURL(loopback) with private addresses allowed = %v", err)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderSignature) != Sign("secret", r.Header.Get(HeaderTimestamp), []byte(`{}`)) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	webhook := entity.Webhook{ID: uuid.New(), URL: server.URL, Secret: "secret", Active: true}
+
+	delivery := New(nil, Default).Send(webhook, "proposal.created", uuid.New(), []byte(`{}`), 1)
+	if delivery.Succeeded || !strings.Contains(delivery.Error, ErrPrivateAddress.Error()) {
+		t.Errorf("Send() to loopback = %+v, want refused", delivery)
+	}
+
+	allowed := Default
+	allowed.AllowPrivateAddresses = true
+	delivery = New(nil, allowed).Send(webhook, "proposal.created", uuid.New(), []byte(`{}`), 1)
+	if !delivery.Succeeded || delivery.StatusCode != http.StatusOK {
+		t.Errorf("Send() with private addresses allowed = %+v", delivery)
+	}
+}